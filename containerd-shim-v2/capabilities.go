@@ -0,0 +1,115 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"regexp"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/sirupsen/logrus"
+)
+
+// capabilityName matches the capability names, CAP_ followed by the
+// upper case kernel name.
+var capabilityName = regexp.MustCompile(`^CAP_[A-Z0-9_]+$`)
+
+// knownCapabilities are the capabilities the shim knows about. The guest
+// kernel may know about newer ones.
+var knownCapabilities = map[string]bool{
+	"CAP_CHOWN":              true,
+	"CAP_DAC_OVERRIDE":       true,
+	"CAP_DAC_READ_SEARCH":    true,
+	"CAP_FOWNER":             true,
+	"CAP_FSETID":             true,
+	"CAP_KILL":               true,
+	"CAP_SETGID":             true,
+	"CAP_SETUID":             true,
+	"CAP_SETPCAP":            true,
+	"CAP_LINUX_IMMUTABLE":    true,
+	"CAP_NET_BIND_SERVICE":   true,
+	"CAP_NET_BROADCAST":      true,
+	"CAP_NET_ADMIN":          true,
+	"CAP_NET_RAW":            true,
+	"CAP_IPC_LOCK":           true,
+	"CAP_IPC_OWNER":          true,
+	"CAP_SYS_MODULE":         true,
+	"CAP_SYS_RAWIO":          true,
+	"CAP_SYS_CHROOT":         true,
+	"CAP_SYS_PTRACE":         true,
+	"CAP_SYS_PACCT":          true,
+	"CAP_SYS_ADMIN":          true,
+	"CAP_SYS_BOOT":           true,
+	"CAP_SYS_NICE":           true,
+	"CAP_SYS_RESOURCE":       true,
+	"CAP_SYS_TIME":           true,
+	"CAP_SYS_TTY_CONFIG":     true,
+	"CAP_MKNOD":              true,
+	"CAP_LEASE":              true,
+	"CAP_AUDIT_WRITE":        true,
+	"CAP_AUDIT_CONTROL":      true,
+	"CAP_SETFCAP":            true,
+	"CAP_MAC_OVERRIDE":       true,
+	"CAP_MAC_ADMIN":          true,
+	"CAP_SYSLOG":             true,
+	"CAP_WAKE_ALARM":         true,
+	"CAP_BLOCK_SUSPEND":      true,
+	"CAP_AUDIT_READ":         true,
+	"CAP_PERFMON":            true,
+	"CAP_BPF":                true,
+	"CAP_CHECKPOINT_RESTORE": true,
+}
+
+// processCapabilities returns the capabilities of the container process,
+// whether ParseConfigJSON already converted them or not.
+func processCapabilities(spec *oci.CompatOCISpec) (types.LinuxCapabilities, error) {
+	if caps, ok := spec.Process.Capabilities.(types.LinuxCapabilities); ok {
+		return caps, nil
+	}
+
+	return oci.ContainerCapabilities(*spec)
+}
+
+// validCapabilities checks the capability sets of the container process,
+// which the agent is given along with the spec to set up the process in
+// the guest. A malformed capability name is rejected, rather than failing
+// the container start in the guest. A well formed name the shim doesn't know
+// about is only warned about, since a newer guest kernel may support it.
+func validCapabilities(spec *oci.CompatOCISpec) error {
+	if spec.Process == nil {
+		return nil
+	}
+
+	caps, err := processCapabilities(spec)
+	if err != nil {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid capabilities: %v", err)
+	}
+
+	sets := []struct {
+		name string
+		caps []string
+	}{
+		{"bounding", caps.Bounding},
+		{"effective", caps.Effective},
+		{"inheritable", caps.Inheritable},
+		{"permitted", caps.Permitted},
+		{"ambient", caps.Ambient},
+	}
+
+	for _, set := range sets {
+		for _, c := range set.caps {
+			if !capabilityName.MatchString(c) {
+				return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid capability %q in the %s set", c, set.name)
+			}
+			if !knownCapabilities[c] {
+				logrus.WithField("capability", c).WithField("set", set.name).Warn("unknown capability")
+			}
+		}
+	}
+
+	return nil
+}
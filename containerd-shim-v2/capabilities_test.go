@@ -0,0 +1,65 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	defaults := []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_BIND_SERVICE", "CAP_AUDIT_WRITE"}
+
+	data := []struct {
+		caps interface{}
+		code codes.Code
+	}{
+		// no capabilities
+		{nil, codes.OK},
+		// the docker default set, without any ambient capability
+		{types.LinuxCapabilities{Bounding: defaults, Effective: defaults, Inheritable: defaults, Permitted: defaults}, codes.OK},
+		// capabilities added to, or dropped from, some sets only
+		{types.LinuxCapabilities{Bounding: append(defaults, "CAP_SYS_ADMIN"), Effective: defaults[:1]}, codes.OK},
+		// capabilities not converted yet
+		{map[string]interface{}{"bounding": []interface{}{"CAP_SYS_PTRACE"}}, codes.OK},
+		{[]interface{}{"CAP_NET_ADMIN"}, codes.OK},
+		// recent capabilities
+		{types.LinuxCapabilities{Bounding: append(defaults, "CAP_PERFMON", "CAP_BPF", "CAP_CHECKPOINT_RESTORE")}, codes.OK},
+		// capabilities unknown to the shim are left to the guest
+		{types.LinuxCapabilities{Bounding: defaults, Permitted: []string{"CAP_FOO"}}, codes.OK},
+		// malformed capabilities
+		{types.LinuxCapabilities{Ambient: []string{"NET_ADMIN"}}, codes.InvalidArgument},
+		{types.LinuxCapabilities{Bounding: []string{"CAP_"}}, codes.InvalidArgument},
+		{types.LinuxCapabilities{Effective: []string{"cap_chown"}}, codes.InvalidArgument},
+		// invalid format
+		{"CAP_CHOWN", codes.InvalidArgument},
+	}
+
+	for i, d := range data {
+		spec := &oci.CompatOCISpec{}
+		spec.Process = &oci.CompatOCIProcess{Capabilities: d.caps}
+
+		err := validCapabilities(spec)
+		assert.Equal(d.code, status.Code(err), "test %d: %v", i, err)
+	}
+
+	// the malformed capability and its set are reported
+	spec := &oci.CompatOCISpec{}
+	spec.Process = &oci.CompatOCIProcess{Capabilities: types.LinuxCapabilities{Inheritable: []string{"CAP_foo"}}}
+	err := validCapabilities(spec)
+	assert.Contains(err.Error(), `"CAP_foo"`)
+	assert.Contains(err.Error(), "inheritable")
+
+	// a spec without any process
+	assert.NoError(validCapabilities(&oci.CompatOCISpec{}))
+}
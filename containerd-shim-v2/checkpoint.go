@@ -0,0 +1,50 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	"github.com/pkg/errors"
+)
+
+// checkpointContainer validates the checkpoint request of a container.
+//
+// The agent protocol has no way to dump the state of the container
+// processes, so a valid request is still reported as not implemented.
+func checkpointContainer(s *service, r *taskAPI.CheckpointTaskRequest) error {
+	c, err := s.getContainer(r.ID)
+	if err != nil {
+		return err
+	}
+
+	if r.Path == "" {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "no checkpoint image path for container %s", c.id)
+	}
+
+	if r.Options != nil {
+		v, err := typeurl.UnmarshalAny(r.Options)
+		if err != nil {
+			return err
+		}
+		if _, ok := v.(*runctypes.CheckpointOptions); !ok {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid checkpoint options type for container %s", c.id)
+		}
+	}
+
+	c.mu.Lock()
+	status := c.status
+	c.mu.Unlock()
+
+	if status != task.StatusRunning && status != task.StatusPaused {
+		return errors.Wrapf(ErrContainerNotRunning, "cannot checkpoint container %s in state %s", c.id, status)
+	}
+
+	return errdefs.ToGRPCf(errdefs.ErrNotImplemented, "cannot checkpoint container %s, the agent has no checkpoint support", c.id)
+}
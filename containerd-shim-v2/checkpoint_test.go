@@ -0,0 +1,80 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	options, err := typeurl.MarshalAny(&runctypes.CheckpointOptions{Exit: true})
+	assert.NoError(err)
+	badOptions, err := typeurl.MarshalAny(&specs.LinuxResources{})
+	assert.NoError(err)
+
+	data := []struct {
+		id      string
+		path    string
+		options bool
+		status  task.Status
+		code    codes.Code
+	}{
+		{"unknown", "/checkpoint", false, task.StatusRunning, codes.NotFound},
+		{testContainerID, "", false, task.StatusRunning, codes.InvalidArgument},
+		{testContainerID, "/checkpoint", false, task.StatusCreated, codes.FailedPrecondition},
+		{testContainerID, "/checkpoint", false, task.StatusStopped, codes.FailedPrecondition},
+		// valid requests, the agent cannot checkpoint
+		{testContainerID, "/checkpoint", false, task.StatusRunning, codes.Unimplemented},
+		{testContainerID, "/checkpoint", true, task.StatusPaused, codes.Unimplemented},
+	}
+
+	for i, d := range data {
+		c.status = d.status
+
+		r := &taskAPI.CheckpointTaskRequest{
+			ID:   d.id,
+			Path: d.path,
+		}
+		if d.options {
+			r.Options = options
+		}
+
+		_, err := s.Checkpoint(context.Background(), r)
+		assert.Error(err, "test %d", i)
+		assert.Equal(d.code, status.Code(err), "test %d", i)
+	}
+
+	c.status = task.StatusRunning
+	_, err = s.Checkpoint(context.Background(), &taskAPI.CheckpointTaskRequest{
+		ID:      testContainerID,
+		Path:    "/checkpoint",
+		Options: badOptions,
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
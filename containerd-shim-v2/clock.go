@@ -0,0 +1,42 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"time"
+)
+
+// clock provides the time to the shim, for the exit timestamps and the
+// timeout or poll loops, so that unit tests can control it.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default clock, relying on the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// getClock returns the clock of the service, defaulting to the real one.
+func (s *service) getClock() clock {
+	if s == nil || s.clock == nil {
+		return realClock{}
+	}
+
+	return s.clock
+}
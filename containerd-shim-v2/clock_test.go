@@ -0,0 +1,94 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock only moves forward when slept on.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	sleeps int
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	c.sleeps++
+}
+
+// After fires immediately, moving the clock forward.
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestGetClock(t *testing.T) {
+	assert := assert.New(t)
+
+	var s *service
+	assert.Equal(realClock{}, s.getClock())
+
+	s = &service{}
+	assert.Equal(realClock{}, s.getClock())
+
+	clk := &fakeClock{}
+	s.clock = clk
+	assert.Equal(clk, s.getClock())
+}
+
+func TestWaitExitTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &fakeClock{now: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+		clock:      clk,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	assert.Equal(clk.now, c.createdAt)
+	close(c.exitIOch)
+
+	_, err = wait(s, c, "")
+	assert.NoError(err)
+	assert.Equal(clk.now, c.exitTime)
+
+	select {
+	case e := <-s.ec:
+		assert.Equal(testContainerID, e.id)
+		assert.Equal(clk.now, e.timestamp)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exit reaped")
+	}
+}
@@ -6,6 +6,8 @@
 package containerdshim
 
 import (
+	"context"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,27 +16,77 @@ import (
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 type container struct {
-	s        *service
-	ttyio    *ttyIO
-	spec     *oci.CompatOCISpec
-	exitTime time.Time
-	execs    map[string]*exec
-	exitIOch chan struct{}
-	exitCh   chan uint32
-	id       string
-	stdin    string
-	stdout   string
-	stderr   string
-	bundle   string
-	cType    vc.ContainerType
-	mu       sync.Mutex
-	exit     uint32
-	status   task.Status
-	terminal bool
+	s         *service
+	ttyio     *ttyIO
+	ioCtx     context.Context
+	ioCancel  context.CancelFunc
+	spec      *oci.CompatOCISpec
+	exitTime  time.Time
+	createdAt time.Time
+	startedAt time.Time
+	execs     map[string]*exec
+	exitIOch  chan struct{}
+	id        string
+	stdin     string
+	stdout    string
+	stderr    string
+	bundle    string
+	cType     vc.ContainerType
+	exit      uint32
+	status    task.Status
+	terminal  bool
+
+	// mu protects the execs map and the status, exit, startedAt and
+	// attached fields of the container and of its execs, which the wait
+	// goroutines update without holding the service mu.
+	mu sync.Mutex
+
+	// attached is set while the IO of the container init process is
+	// copied and the process waited for.
+	attached bool
+
+	// order is the position of the container in the pod dependency
+	// order, only meaningful if hasOrder is set.
+	order    int
+	hasOrder bool
+
+	// blkio holds the guest block IO limits of the container
+	// annotations, set by an update once the container can be updated,
+	// see startContainer.
+	blkio *specs.LinuxBlockIO
+
+	// exitWaiters are notified of the exit of the container init
+	// process, see WaitContainer. They are protected by mu.
+	exitWaiters []*exitWaiter
+}
+
+// ioContext returns the context cancelled to tear down the container IO,
+// including the IO of its execs.
+func (c *container) ioContext() context.Context {
+	if c.ioCtx == nil {
+		return c.s.getContext()
+	}
+
+	return c.ioCtx
+}
+
+// timeToRunning returns the time it took the container to reach the
+// running state since its creation, or zero if it has not been started.
+func (c *container) timeToRunning() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.startedAt.IsZero() {
+		return 0
+	}
+
+	return c.startedAt.Sub(c.createdAt)
 }
 
 func newContainer(s *service, r *taskAPI.CreateTaskRequest, containerType vc.ContainerType, spec *oci.CompatOCISpec) (*container, error) {
@@ -48,19 +100,29 @@ func newContainer(s *service, r *taskAPI.CreateTaskRequest, containerType vc.Con
 	}
 
 	c := &container{
-		s:        s,
-		spec:     spec,
-		id:       r.ID,
-		bundle:   r.Bundle,
-		stdin:    r.Stdin,
-		stdout:   r.Stdout,
-		stderr:   r.Stderr,
-		terminal: r.Terminal,
-		cType:    containerType,
-		execs:    make(map[string]*exec),
-		status:   task.StatusCreated,
-		exitIOch: make(chan struct{}),
-		exitCh:   make(chan uint32, 1),
+		s:         s,
+		spec:      spec,
+		id:        r.ID,
+		bundle:    r.Bundle,
+		stdin:     r.Stdin,
+		stdout:    r.Stdout,
+		stderr:    r.Stderr,
+		terminal:  r.Terminal,
+		cType:     containerType,
+		execs:     make(map[string]*exec),
+		status:    task.StatusCreated,
+		exitIOch:  make(chan struct{}),
+		createdAt: s.getClock().Now(),
+	}
+
+	if value, ok := spec.Annotations[vcAnnotations.ContainerOrder]; ok {
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", vcAnnotations.ContainerOrder, value)
+		}
+		c.order = order
+		c.hasOrder = true
 	}
+
 	return c, nil
 }
@@ -7,6 +7,8 @@ package containerdshim
 
 import (
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -38,3 +40,15 @@ func TestGetExec(t *testing.T) {
 	_, err = c.getExec(TestID)
 	assert.NoError(err)
 }
+
+func TestNewContainerInvalidOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &oci.CompatOCISpec{}
+	spec.Annotations = map[string]string{
+		vcAnnotations.ContainerOrder: "first",
+	}
+
+	_, err := newContainer(nil, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", spec)
+	assert.Error(err)
+}
@@ -9,13 +9,13 @@ package containerdshim
 
 import (
 	"context"
-	"fmt"
 	"github.com/containerd/typeurl"
 	vc "github.com/kata-containers/runtime/virtcontainers"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/pkg/errors"
 	"os"
 	"path/filepath"
+	"time"
 
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 
@@ -23,6 +23,7 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	containerd_types "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/mount"
 	"github.com/sirupsen/logrus"
 	// only register the proto type
@@ -31,6 +32,12 @@ import (
 )
 
 func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns string) (*container, error) {
+	// Creating the container again would overwrite, and leak, the
+	// existing one.
+	if _, ok := s.containers[r.ID]; ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "container %s already exists", r.ID)
+	}
+
 	rootFs := vc.RootFs{Mounted: s.mount}
 	if len(r.Rootfs) == 1 {
 		m := r.Rootfs[0]
@@ -50,13 +57,30 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 		return nil, err
 	}
 
+	blkio, err := blkioResources(ociSpec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validIDMappings(ociSpec); err != nil {
+		return nil, err
+	}
+
+	if err := validSysctls(ociSpec); err != nil {
+		return nil, err
+	}
+
+	if err := validCapabilities(ociSpec); err != nil {
+		return nil, err
+	}
+
 	disableOutput := noNeedForOutput(detach, ociSpec.Process.Terminal)
 	rootfs := filepath.Join(r.Bundle, "rootfs")
 
 	switch containerType {
 	case vc.PodSandbox:
 		if s.sandbox != nil {
-			return nil, fmt.Errorf("cannot create another sandbox in sandbox: %s", s.sandbox.ID())
+			return nil, errors.Wrapf(ErrSandboxExists, "cannot create sandbox %s", r.ID)
 		}
 
 		_, err := loadRuntimeConfig(s, r)
@@ -64,9 +88,11 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 			return nil, err
 		}
 
+		// A cancelled create unmounts the rootfs in its teardown, once
+		// the sandbox creation returns.
 		defer func() {
-			if err != nil && s.mount {
-				if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
+			if err != nil && err != ctx.Err() && s.mount {
+				if err2 := s.getMounter().UnmountAll(rootfs, 0); err2 != nil {
 					logrus.WithError(err2).Warn("failed to cleanup rootfs mount")
 				}
 			}
@@ -85,7 +111,11 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 		// ctx will be canceled after this rpc service call, but the sandbox will live
 		// across multiple rpc service calls.
 		//
-		sandbox, _, err := katautils.CreateSandbox(s.ctx, vci, *ociSpec, *s.config, rootFs, r.ID, bundlePath, "", disableOutput, false, true)
+		var sandbox vc.VCSandbox
+		sandbox, err = createCancellable(ctx, func() (vc.VCSandbox, error) {
+			sandbox, _, err := katautils.CreateSandbox(s.ctx, vci, *ociSpec, *s.config, rootFs, r.ID, bundlePath, "", disableOutput, false, true)
+			return sandbox, err
+		}, s.teardownCancelledCreate(r.ID, r.ID, bundlePath))
 		if err != nil {
 			return nil, err
 		}
@@ -93,13 +123,13 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 
 	case vc.PodContainer:
 		if s.sandbox == nil {
-			return nil, fmt.Errorf("BUG: Cannot start the container, since the sandbox hasn't been created")
+			return nil, errors.Wrapf(ErrSandboxNotCreated, "cannot create container %s", r.ID)
 		}
 
 		if s.mount {
 			defer func() {
-				if err != nil {
-					if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
+				if err != nil && err != ctx.Err() {
+					if err2 := s.getMounter().UnmountAll(rootfs, 0); err2 != nil {
 						logrus.WithError(err2).Warn("failed to cleanup rootfs mount")
 					}
 				}
@@ -110,7 +140,13 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 			}
 		}
 
-		_, err = katautils.CreateContainer(ctx, vci, s.sandbox, *ociSpec, rootFs, r.ID, bundlePath, "", disableOutput, true)
+		sandbox := s.sandbox
+		_, err = createCancellable(ctx, func() (vc.VCSandbox, error) {
+			if _, err := katautils.CreateContainer(ctx, vci, sandbox, *ociSpec, rootFs, r.ID, bundlePath, "", disableOutput, true); err != nil {
+				return nil, err
+			}
+			return sandbox, nil
+		}, s.teardownCancelledCreate(sandbox.ID(), r.ID, bundlePath))
 		if err != nil {
 			return nil, err
 		}
@@ -120,10 +156,78 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest, netns
 	if err != nil {
 		return nil, err
 	}
+	container.blkio = blkio
 
 	return container, nil
 }
 
+// createCancellable runs create, which cannot be interrupted, until it
+// returns or ctx is done. On cancellation, the context error is returned
+// right away and teardown is called once create returns, with the sandbox
+// it returned, nil if it failed.
+func createCancellable(ctx context.Context, create func() (vc.VCSandbox, error), teardown func(vc.VCSandbox)) (vc.VCSandbox, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		sandbox vc.VCSandbox
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		sandbox, err := create()
+		done <- result{sandbox, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.sandbox, res.err
+	case <-ctx.Done():
+	}
+
+	go func() {
+		res := <-done
+		if res.err != nil {
+			res.sandbox = nil
+		}
+		teardown(res.sandbox)
+	}()
+
+	return nil, ctx.Err()
+}
+
+// teardownCancelledCreate returns the teardown of a cancelled create of
+// container cid, which releases what the create left the same way
+// cleanupContainer does. It waits for the service lock, so that it only
+// runs once the cancelled Create has returned.
+func (s *service) teardownCancelledCreate(sid, cid, bundlePath string) func(vc.VCSandbox) {
+	return func(sandbox vc.VCSandbox) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		logger := logrus.WithFields(logrus.Fields{
+			"sandbox":   sid,
+			"container": cid,
+		})
+		logger.Info("tearing down cancelled create")
+
+		if sandbox == nil {
+			// The failed create rolled back by itself, only the rootfs
+			// mounted by the shim is left.
+			if err := unmountRootfs(s.getMounter(), s.getClock(), filepath.Join(bundlePath, "rootfs"), true); err != nil {
+				logger.WithError(err).Warn("failed to cleanup rootfs mount")
+			}
+			return
+		}
+
+		if err := teardownContainer(s.ctx, s.getMounter(), s.getClock(), sandbox, sid, cid, bundlePath); err != nil {
+			logger.WithError(err).Warn("failed to tear down cancelled create")
+		}
+	}
+}
+
 func loadSpec(r *taskAPI.CreateTaskRequest, netns string) (*oci.CompatOCISpec, string, error) {
 	// Checks the MUST and MUST NOT from OCI runtime specification
 	bundlePath, err := validBundle(r.ID, r.Bundle)
@@ -193,11 +297,26 @@ func loadRuntimeConfig(s *service, r *taskAPI.CreateTaskRequest) (*oci.RuntimeCo
 	// For the unit test, the config will be predefined
 	if s.config == nil {
 		s.config = &runtimeConfig
+		setRuntimeOptions(s, s.config)
 	}
 
 	return &runtimeConfig, nil
 }
 
+// setRuntimeOptions sets the service options of the runtime configuration.
+func setRuntimeOptions(s *service, config *oci.RuntimeConfig) {
+	s.outputLogDir = config.OutputLogDir
+	s.stopConfirm = stopConfirmSources[config.StopConfirmation]
+	s.strictUnmount = config.StrictUnmount
+	s.drainTimeout = time.Duration(config.DrainTimeout) * time.Second
+	s.maxExecs = int(config.MaxExecs)
+	s.ioBufferSize = int(config.IOBufferSize)
+	s.execWaitTimeout = time.Duration(config.ExecWaitTimeout) * time.Second
+	s.terminalHeight = config.TerminalHeight
+	s.terminalWidth = config.TerminalWidth
+	s.logRateLimit = uint(config.LogRateLimit)
+}
+
 func checkAndMount(s *service, r *taskAPI.CreateTaskRequest) error {
 	if len(r.Rootfs) == 1 {
 		m := r.Rootfs[0]
@@ -8,21 +8,28 @@ package containerdshim
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
 
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
 	"github.com/kata-containers/runtime/pkg/katautils"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestCreateSandboxSuccess(t *testing.T) {
@@ -369,3 +376,566 @@ func TestCreateContainerConfigFail(t *testing.T) {
 	_, err = s.Create(ctx, req)
 	assert.Error(err)
 }
+
+func TestCreateContainerBlkioAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &updateSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	testingImpl.CreateContainerFunc = func(ctx context.Context, sandboxID string, containerConfig vc.ContainerConfig) (vc.VCSandbox, vc.VCContainer, error) {
+		return sandbox, &vcmock.Container{}, nil
+	}
+
+	defer func() {
+		testingImpl.CreateContainerFunc = nil
+	}()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation:  testContainerTypeContainer,
+		testSandboxIDAnnotation:      testSandboxID,
+		vcAnnotations.BlkioWeight:    "300",
+		vcAnnotations.BlkioWriteIOPS: "254:0=200",
+	}
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+
+	// the limits are kept for the start of the container
+	assert.Empty(sandbox.updates)
+	if c := s.containers[testContainerID]; assert.NotNil(c) {
+		blkio := c.blkio
+		if assert.NotNil(blkio) && assert.NotNil(blkio.Weight) {
+			assert.Equal(uint16(300), *blkio.Weight)
+		}
+		assert.Len(blkio.ThrottleWriteIOPSDevice, 1)
+	}
+
+	// a malformed limit fails the creation before the container is created
+	created := false
+	testingImpl.CreateContainerFunc = func(ctx context.Context, sandboxID string, containerConfig vc.ContainerConfig) (vc.VCSandbox, vc.VCContainer, error) {
+		created = true
+		return sandbox, &vcmock.Container{}, nil
+	}
+
+	spec.Annotations[vcAnnotations.BlkioWeight] = "heavy"
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	req.ID = "other"
+	s.containers = make(map[string]*container)
+	_, err = s.Create(ctx, req)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.False(created)
+}
+
+// createConfigSandbox records the configurations of the containers it
+// creates.
+type createConfigSandbox struct {
+	vcmock.Sandbox
+	created []vc.ContainerConfig
+}
+
+func (s *createConfigSandbox) CreateContainer(conf vc.ContainerConfig) (vc.VCContainer, error) {
+	s.created = append(s.created, conf)
+	return &vcmock.Container{}, nil
+}
+
+func TestCreateContainerIDMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &createConfigSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	mappings := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65535},
+	}
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+	spec.Linux.UIDMappings = mappings
+	spec.Linux.GIDMappings = mappings
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+
+	// the mappings are part of the spec given to the agent
+	if assert.Len(sandbox.created, 1) {
+		var guestSpec specs.Spec
+		assert.NoError(json.Unmarshal([]byte(sandbox.created[0].Annotations[vcAnnotations.ConfigJSONKey]), &guestSpec))
+		assert.Equal(mappings, guestSpec.Linux.UIDMappings)
+		assert.Equal(mappings, guestSpec.Linux.GIDMappings)
+	}
+
+	// overlapping ranges fail the creation before the container is created
+	spec.Linux.GIDMappings = append(spec.Linux.GIDMappings, specs.LinuxIDMapping{ContainerID: 100, HostID: 300000, Size: 10})
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	req.ID = "other"
+	_, err = s.Create(ctx, req)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Len(sandbox.created, 1)
+}
+
+func TestCreateContainerSysctls(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &createConfigSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	sysctls := map[string]string{
+		"net.core.somaxconn": "1024",
+		"kernel.shmmax":      "68719476736",
+	}
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	spec.Linux.Sysctl = sysctls
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+
+	// the sysctls are part of the spec given to the agent
+	if assert.Len(sandbox.created, 1) {
+		var guestSpec specs.Spec
+		assert.NoError(json.Unmarshal([]byte(sandbox.created[0].Annotations[vcAnnotations.ConfigJSONKey]), &guestSpec))
+		assert.Equal(sysctls, guestSpec.Linux.Sysctl)
+	}
+
+	// a sysctl of the whole guest fails the creation of an unprivileged
+	// container before the container is created
+	spec.Linux.Sysctl["vm.swappiness"] = "10"
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	req.ID = "other"
+	_, err = s.Create(ctx, req)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Len(sandbox.created, 1)
+}
+
+func TestCreateContainerCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &createConfigSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	caps := &specs.LinuxCapabilities{
+		Bounding:    []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_ADMIN"},
+		Effective:   []string{"CAP_CHOWN", "CAP_KILL"},
+		Inheritable: []string{"CAP_KILL"},
+		Permitted:   []string{"CAP_CHOWN", "CAP_KILL"},
+	}
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	spec.Process.Capabilities = caps
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+
+	// the capability sets are part of the spec given to the agent
+	if assert.Len(sandbox.created, 1) {
+		var guestSpec specs.Spec
+		assert.NoError(json.Unmarshal([]byte(sandbox.created[0].Annotations[vcAnnotations.ConfigJSONKey]), &guestSpec))
+		assert.Equal(caps, guestSpec.Process.Capabilities)
+	}
+
+	// a malformed capability fails the creation before the container is
+	// created
+	caps.Bounding = append(caps.Bounding, "cap_foo")
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	req.ID = "other"
+	_, err = s.Create(ctx, req)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Len(sandbox.created, 1)
+}
+
+func TestCreateContainerSeccomp(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &createConfigSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActErrno,
+		Architectures: []specs.Arch{specs.ArchX86_64},
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"read", "write", "exit_group"}, Action: specs.ActAllow},
+		},
+	}
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	spec.Linux.Seccomp = seccomp
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+
+	// the profile is part of the spec given to the agent
+	if assert.Len(sandbox.created, 1) {
+		var guestSpec specs.Spec
+		assert.NoError(json.Unmarshal([]byte(sandbox.created[0].Annotations[vcAnnotations.ConfigJSONKey]), &guestSpec))
+		assert.Equal(seccomp, guestSpec.Linux.Seccomp)
+	}
+}
+
+func TestCreateContainerAlreadyExists(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &createConfigSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	_, err = s.Create(ctx, req)
+	assert.NoError(err)
+	first := s.containers[testContainerID]
+	assert.NotNil(first)
+
+	// the second create fails before creating anything
+	_, err = s.Create(ctx, req)
+	assert.Equal(codes.AlreadyExists, status.Code(err))
+	assert.Contains(err.Error(), "already exists")
+	assert.Len(sandbox.created, 1)
+	assert.True(first == s.containers[testContainerID])
+	assert.Len(s.containers, 1)
+}
+
+// blockingCreateSandbox blocks the creation of its containers until release
+// gives the creation result, and reports the containers it deletes.
+type blockingCreateSandbox struct {
+	vcmock.Sandbox
+	entered chan struct{}
+	release chan error
+	deleted chan string
+}
+
+func (s *blockingCreateSandbox) CreateContainer(conf vc.ContainerConfig) (vc.VCContainer, error) {
+	close(s.entered)
+	return &vcmock.Container{}, <-s.release
+}
+
+func (s *blockingCreateSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{State: types.ContainerState{State: types.StateStopped}}, nil
+}
+
+func (s *blockingCreateSandbox) DeleteContainer(contID string) (vc.VCContainer, error) {
+	s.deleted <- contID
+	return &vcmock.Container{}, nil
+}
+
+func TestCreateContainerCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	u := &fakeMounter{}
+	sandbox := &blockingCreateSandbox{
+		Sandbox: vcmock.Sandbox{
+			MockID:         testSandboxID,
+			MockContainers: []*vcmock.Container{{MockID: testSandboxID}},
+		},
+		entered: make(chan struct{}),
+		release: make(chan error),
+		deleted: make(chan string, 1),
+	}
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	assert.NoError(makeOCIBundle(bundlePath))
+
+	ociConfigFile := filepath.Join(bundlePath, "config.json")
+	spec, err := readOCIConfigFile(ociConfigFile)
+	assert.NoError(err)
+
+	spec.Annotations = map[string]string{
+		testContainerTypeAnnotation: testContainerTypeContainer,
+		testSandboxIDAnnotation:     testSandboxID,
+	}
+	assert.NoError(writeOCIConfigFile(spec, ociConfigFile))
+
+	s := &service{
+		id:         testContainerID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		config:     &runtimeConfig,
+		ctx:        context.Background(),
+		mounter:    u,
+	}
+
+	req := &taskAPI.CreateTaskRequest{
+		ID:     testContainerID,
+		Bundle: bundlePath,
+	}
+
+	// a create cancelled beforehand doesn't create anything
+	ctx, cancel := context.WithCancel(namespaces.WithNamespace(context.Background(), "UnitTest"))
+	cancel()
+	_, err = s.Create(ctx, req)
+	assert.Equal(context.Canceled, err)
+	select {
+	case <-sandbox.entered:
+		t.Fatal("the container was created")
+	default:
+	}
+
+	// the create returns on cancellation, while the container is created
+	ctx, cancel = context.WithCancel(namespaces.WithNamespace(context.Background(), "UnitTest"))
+	defer cancel()
+
+	created := make(chan error)
+	go func() {
+		_, err := s.Create(ctx, req)
+		created <- err
+	}()
+
+	<-sandbox.entered
+	cancel()
+
+	select {
+	case err := <-created:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the create didn't return on cancellation")
+	}
+	assert.NotContains(s.containers, testContainerID)
+
+	// the container is torn down once its creation completes
+	sandbox.release <- nil
+	select {
+	case id := <-sandbox.deleted:
+		assert.Equal(testContainerID, id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled container wasn't deleted")
+	}
+
+	// the teardown holds the service lock until it is done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(1, u.calls)
+}
+
+func TestSetRuntimeOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{}
+	setRuntimeOptions(s, &oci.RuntimeConfig{})
+	assert.Empty(s.outputLogDir)
+	assert.Equal(stopConfirmAgent, s.stopConfirm)
+	assert.False(s.strictUnmount)
+	assert.Zero(s.drainTimeout)
+	assert.Zero(s.maxExecs)
+	assert.Zero(s.ioBufferSize)
+	assert.Zero(s.execWaitTimeout)
+	assert.Zero(s.terminalHeight)
+	assert.Zero(s.terminalWidth)
+	assert.Zero(s.logRateLimit)
+
+	setRuntimeOptions(s, &oci.RuntimeConfig{
+		OutputLogDir:     "/var/log/kata-containers",
+		StopConfirmation: "hypervisor",
+		StrictUnmount:    true,
+		DrainTimeout:     60,
+		MaxExecs:         32,
+		IOBufferSize:     128 << 10,
+		ExecWaitTimeout:  300,
+		TerminalHeight:   50,
+		TerminalWidth:    132,
+		LogRateLimit:     1000,
+	})
+	assert.Equal("/var/log/kata-containers", s.outputLogDir)
+	assert.Equal(stopConfirmHypervisor, s.stopConfirm)
+	assert.True(s.strictUnmount)
+	assert.Equal(60*time.Second, s.drainTimeout)
+	assert.Equal(32, s.maxExecs)
+	assert.Equal(128<<10, s.ioBufferSize)
+	assert.Equal(300*time.Second, s.execWaitTimeout)
+	assert.Equal(uint32(50), s.terminalHeight)
+	assert.Equal(uint32(132), s.terminalWidth)
+	assert.Equal(uint(1000), s.logRateLimit)
+}
@@ -7,46 +7,257 @@ package containerdshim
 
 import (
 	"context"
+	"fmt"
 	"path"
+	"sort"
+	"syscall"
+	"time"
 
 	"github.com/containerd/containerd/mount"
-	"github.com/kata-containers/runtime/pkg/katautils"
+	vc "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
 )
 
+// stopConfirmSource selects where deleteContainer looks to confirm
+// that a container has reached the stopped state.
+type stopConfirmSource int
+
+const (
+	// stopConfirmAgent relies on the container status reported
+	// by the agent. This is the default.
+	stopConfirmAgent stopConfirmSource = iota
+
+	// stopConfirmHypervisor polls the sandbox status, which reflects
+	// the hypervisor state, to confirm the container stop.
+	stopConfirmHypervisor
+)
+
+// stopConfirmSources maps the stop_confirmation runtime option values to
+// the stop confirmation sources.
+var stopConfirmSources = map[string]stopConfirmSource{
+	"agent":      stopConfirmAgent,
+	"hypervisor": stopConfirmHypervisor,
+}
+
+// containerStopped reports whether the container is stopped, according
+// to the service's stop confirmation source.
+func containerStopped(s *service, containerID string) (bool, error) {
+	switch s.stopConfirm {
+	case stopConfirmAgent:
+		status, err := s.sandbox.StatusContainer(containerID)
+		if err != nil {
+			return false, err
+		}
+		return status.State.State == types.StateStopped, nil
+	case stopConfirmHypervisor:
+		status := s.sandbox.Status()
+		// The containers cannot run anymore once the VM is down.
+		if status.State.State == types.StateStopped {
+			return true, nil
+		}
+		for _, cStatus := range status.ContainersStatus {
+			if cStatus.ID == containerID {
+				return cStatus.State.State == types.StateStopped, nil
+			}
+		}
+		return false, errors.Wrapf(vc.ErrNoSuchContainer, "container %s not found in sandbox %s status", containerID, s.sandbox.ID())
+	default:
+		return false, fmt.Errorf("unknown stop confirmation source %d", s.stopConfirm)
+	}
+}
+
 func deleteContainer(ctx context.Context, s *service, c *container) error {
+	// Deleting the sandbox container would leave the remaining
+	// containers without their VM.
+	if c.cType.IsSandbox() {
+		for _, other := range s.containers {
+			if !other.cType.IsSandbox() {
+				return ErrSandboxContainerBusy
+			}
+		}
+	}
 
-	status, err := s.sandbox.StatusContainer(c.id)
+	if err := deleteGuestContainer(s, c); err != nil {
+		if !isContainerNotFound(err) {
+			return err
+		}
+		// The container record is only partial, e.g. it has been
+		// created but never made it to the agent: converge by
+		// cleaning up what is left on the host.
+		logrus.WithError(err).WithField("container", c.id).
+			Warn("container not found in the sandbox, cleaning up local resources")
+	}
+
+	// Run post-stop OCI hooks.
+	postStopHooks(ctx, *c.spec, s.sandbox.ID(), c.bundle)
+
+	var unmountErr error
+	if s.mount {
+		rootfs := path.Join(c.bundle, "rootfs")
+		if unmountErr = unmountRootfs(s.getMounter(), s.getClock(), rootfs, !s.strictUnmount); unmountErr != nil {
+			logrus.WithError(unmountErr).Warn("failed to cleanup rootfs mount")
+		} else if leaked, err := verifyNoLeakedMounts([]string{c.bundle}); err != nil {
+			logrus.WithError(err).Warn("failed to check the rootfs mounts")
+		} else if len(leaked) > 0 {
+			// e.g. a mount stacked on the rootfs, which is left behind.
+			logrus.WithField("leaked", leaked).Warn("rootfs still mounted after cleanup")
+		}
+	}
+
+	delete(s.containers, c.id)
+	s.forgetExit(c.id, "")
+
+	if c.ioCancel != nil {
+		c.ioCancel()
+	}
+
+	return unmountErr
+}
+
+// deleteGuestContainer stops the container if needed and deletes it
+// from the sandbox.
+func deleteGuestContainer(s *service, c *container) error {
+	stopped, err := containerStopped(s, c.id)
 	if err != nil {
 		return err
 	}
-	if status.State.State != types.StateStopped {
-		_, err = s.sandbox.StopContainer(c.id)
-		if err != nil {
+	if !stopped {
+		if _, err := s.sandbox.StopContainer(c.id); err != nil {
 			return err
 		}
 	}
 
 	_, err = s.sandbox.DeleteContainer(c.id)
-	if err != nil {
-		return err
+	return err
+}
+
+// deleteOrder returns the containers in the order they must be
+// deleted: the reverse of their dependency order, the containers
+// without any order coming next and the sandbox container last.
+func deleteOrder(containers map[string]*container) []*container {
+	ordered := make([]*container, 0, len(containers))
+	for _, c := range containers {
+		ordered = append(ordered, c)
 	}
 
-	// Run post-stop OCI hooks.
-	if err := katautils.PostStopHooks(ctx, *c.spec, s.sandbox.ID(), c.bundle); err != nil {
+	sort.Slice(ordered, func(i, j int) bool {
+		ci, cj := ordered[i], ordered[j]
+		if ci.cType.IsSandbox() != cj.cType.IsSandbox() {
+			return cj.cType.IsSandbox()
+		}
+		if ci.hasOrder != cj.hasOrder {
+			return ci.hasOrder
+		}
+		if ci.hasOrder && ci.order != cj.order {
+			return ci.order > cj.order
+		}
+		return ci.id < cj.id
+	})
+
+	return ordered
+}
+
+const (
+	// unmountRetries is the number of attempts to unmount a rootfs
+	// reported transiently busy.
+	unmountRetries = 5
+
+	// unmountRetryDelay is the delay before the first unmount retry,
+	// doubled after every attempt.
+	unmountRetryDelay = 10 * time.Millisecond
+)
+
+// unmountRootfs unmounts all the mounts of the rootfs with m, retrying with
+// a bounded backoff slept on clk, as long as the kernel reports it busy. If
+// lazyFallback is set and the rootfs still cannot be unmounted, it is
+// lazily detached so that the kernel reclaims it once it is not referenced
+// anymore.
+func unmountRootfs(m mounter, clk clock, rootfs string, lazyFallback bool) error {
+	logger := logrus.WithField("rootfs", rootfs)
+
+	err := unmountRootfsRetry(m, clk, rootfs)
+	if err == nil {
+		logger.WithField("strategy", "normal").Debug("rootfs unmounted")
+		return nil
+	}
+
+	if !lazyFallback {
 		return err
 	}
 
-	if s.mount {
-		rootfs := path.Join(c.bundle, "rootfs")
-		if err := mount.UnmountAll(rootfs, 0); err != nil {
-			logrus.WithError(err).Warn("failed to cleanup rootfs mount")
-		}
+	logger.WithError(err).Warn("failed to unmount rootfs, trying a lazy unmount")
+
+	if lazyErr := m.UnmountAll(rootfs, syscall.MNT_DETACH); lazyErr != nil {
+		return errors.Wrapf(lazyErr, "failed to lazily unmount %s, normal unmount failed with: %v", rootfs, err)
 	}
 
-	delete(s.containers, c.id)
+	logger.WithField("strategy", "lazy").Info("rootfs unmounted")
 
 	return nil
 }
+
+func unmountRootfsRetry(m mounter, clk clock, rootfs string) error {
+	delay := unmountRetryDelay
+
+	var err error
+	for i := 0; i < unmountRetries; i++ {
+		if err = m.UnmountAll(rootfs, 0); err == nil {
+			return nil
+		}
+
+		if cause := errors.Cause(err); cause != syscall.EBUSY && cause != syscall.EAGAIN {
+			return err
+		}
+
+		if i < unmountRetries-1 {
+			clk.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return errors.Wrapf(err, "failed to unmount %s after %d attempts", rootfs, unmountRetries)
+}
+
+// mountChecker reports whether the given path is currently a mount point.
+type mountChecker func(path string) (bool, error)
+
+// isMounted is the mountChecker used by verifyNoLeakedMounts, it can be
+// replaced in unit tests.
+var isMounted mountChecker = func(path string) (bool, error) {
+	mounts, err := mount.Self()
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range mounts {
+		if m.Mountpoint == path {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// verifyNoLeakedMounts checks whether the rootfs of any of the given
+// bundles is still mounted, and returns the leaked rootfs paths.
+func verifyNoLeakedMounts(bundlePaths []string) ([]string, error) {
+	var leaked []string
+
+	for _, bundle := range bundlePaths {
+		rootfs := path.Join(bundle, "rootfs")
+
+		mounted, err := isMounted(rootfs)
+		if err != nil {
+			return nil, err
+		}
+
+		if mounted {
+			leaked = append(leaked, rootfs)
+		}
+	}
+
+	return leaked, nil
+}
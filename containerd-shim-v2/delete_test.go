@@ -7,14 +7,31 @@
 package containerdshim
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	pkgErrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestDeleteContainerSuccessAndFail(t *testing.T) {
@@ -59,3 +76,512 @@ func testConfigSetup(t *testing.T) (rootPath string, configPath string) {
 	configPath = filepath.Join(bundlePath, "config.json")
 	return tmpdir, configPath
 }
+
+func TestVerifyNoLeakedMounts(t *testing.T) {
+	assert := assert.New(t)
+
+	savedIsMounted := isMounted
+	defer func() {
+		isMounted = savedIsMounted
+	}()
+
+	leakedRootfs := filepath.Join("/bundle2", "rootfs")
+	isMounted = func(path string) (bool, error) {
+		return path == leakedRootfs, nil
+	}
+
+	leaked, err := verifyNoLeakedMounts([]string{"/bundle1", "/bundle2", "/bundle3"})
+	assert.NoError(err)
+	assert.Equal([]string{leakedRootfs}, leaked)
+
+	isMounted = func(path string) (bool, error) {
+		return false, nil
+	}
+
+	leaked, err = verifyNoLeakedMounts([]string{"/bundle1", "/bundle2"})
+	assert.NoError(err)
+	assert.Empty(leaked)
+
+	isMounted = func(path string) (bool, error) {
+		return false, errors.New("mountinfo unavailable")
+	}
+
+	_, err = verifyNoLeakedMounts([]string{"/bundle1"})
+	assert.Error(err)
+}
+
+// stopConfirmSandbox reports different container states from the agent
+// and from the sandbox (hypervisor) status.
+type stopConfirmSandbox struct {
+	vcmock.Sandbox
+	agentState   types.StateString
+	sandboxState types.StateString
+	stopped      bool
+}
+
+func (s *stopConfirmSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: s.agentState},
+	}, nil
+}
+
+func (s *stopConfirmSandbox) Status() vc.SandboxStatus {
+	return vc.SandboxStatus{
+		ID:    s.MockID,
+		State: types.SandboxState{State: s.sandboxState},
+		ContainersStatus: []vc.ContainerStatus{
+			{
+				ID:    testContainerID,
+				State: types.ContainerState{State: s.sandboxState},
+			},
+		},
+	}
+}
+
+func (s *stopConfirmSandbox) StopContainer(contID string) (vc.VCContainer, error) {
+	s.stopped = true
+	return &vcmock.Container{}, nil
+}
+
+func TestContainerStoppedSources(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &stopConfirmSandbox{
+		Sandbox:      vcmock.Sandbox{MockID: testSandboxID},
+		agentState:   types.StateStopped,
+		sandboxState: types.StateRunning,
+	}
+
+	s := &service{
+		id:      testSandboxID,
+		sandbox: sandbox,
+	}
+
+	// default to the agent status
+	stopped, err := containerStopped(s, testContainerID)
+	assert.NoError(err)
+	assert.True(stopped)
+
+	s.stopConfirm = stopConfirmHypervisor
+	stopped, err = containerStopped(s, testContainerID)
+	assert.NoError(err)
+	assert.False(stopped)
+
+	sandbox.sandboxState = types.StateStopped
+	stopped, err = containerStopped(s, testContainerID)
+	assert.NoError(err)
+	assert.True(stopped)
+
+	sandbox.sandboxState = types.StateRunning
+	_, err = containerStopped(s, "unknown")
+	assert.True(isContainerNotFound(err))
+
+	s.stopConfirm = stopConfirmSource(-1)
+	_, err = containerStopped(s, testContainerID)
+	assert.Error(err)
+}
+
+func TestDeleteContainerStopConfirmHypervisor(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &stopConfirmSandbox{
+		Sandbox:      vcmock.Sandbox{MockID: testSandboxID},
+		agentState:   types.StateStopped,
+		sandboxState: types.StateRunning,
+	}
+
+	s := &service{
+		id:          testSandboxID,
+		sandbox:     sandbox,
+		containers:  make(map[string]*container),
+		stopConfirm: stopConfirmHypervisor,
+	}
+
+	reqCreate := &taskAPI.CreateTaskRequest{
+		ID: testContainerID,
+	}
+	c, err := newContainer(s, reqCreate, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	err = deleteContainer(context.Background(), s, c)
+	assert.NoError(err)
+	assert.True(sandbox.stopped)
+	assert.NotContains(s.containers, testContainerID)
+}
+
+// deleteOrderSandbox records the order in which containers are deleted.
+type deleteOrderSandbox struct {
+	vcmock.Sandbox
+	deleted []string
+}
+
+func (s *deleteOrderSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: types.StateStopped},
+	}, nil
+}
+
+func (s *deleteOrderSandbox) DeleteContainer(contID string) (vc.VCContainer, error) {
+	s.deleted = append(s.deleted, contID)
+	return &vcmock.Container{}, nil
+}
+
+func TestDeleteOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		containers: make(map[string]*container),
+	}
+
+	orders := map[string]string{
+		"init-0": "0",
+		"init-1": "1",
+		"app":    "2",
+		"other":  "",
+	}
+	for id, order := range orders {
+		spec := &oci.CompatOCISpec{}
+		if order != "" {
+			spec.Annotations = map[string]string{
+				vcAnnotations.ContainerOrder: order,
+			}
+		}
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: id}, "", spec)
+		assert.NoError(err)
+		s.containers[id] = c
+	}
+
+	// the sandbox container comes last, even without any order
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: "0-sandbox"}, vc.PodSandbox, nil)
+	assert.NoError(err)
+	s.containers[c.id] = c
+
+	var ids []string
+	for _, c := range deleteOrder(s.containers) {
+		ids = append(ids, c.id)
+	}
+	assert.Equal([]string{"app", "init-1", "init-0", "other", "0-sandbox"}, ids)
+}
+
+func TestUnmountRootfsRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	busy := pkgErrors.Wrapf(syscall.EBUSY, "failed to unmount target")
+
+	data := []struct {
+		errs      []error
+		expectErr bool
+		calls     int
+		backoff   time.Duration
+	}{
+		{nil, false, 1, 0},
+		{[]error{busy, syscall.EAGAIN}, false, 3, 30 * time.Millisecond},
+		{[]error{busy, busy, busy, busy, busy}, true, unmountRetries, 150 * time.Millisecond},
+		{[]error{syscall.EPERM}, true, 1, 0},
+	}
+
+	start := time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+	for i, d := range data {
+		u := &fakeMounter{errs: d.errs}
+		clk := &fakeClock{now: start}
+
+		err := unmountRootfs(u, clk, "/bundle/rootfs", false)
+		if d.expectErr {
+			assert.Error(err, "test %d", i)
+		} else {
+			assert.NoError(err, "test %d", i)
+		}
+		assert.Equal(d.calls, u.calls, "test %d", i)
+		assert.Equal(d.backoff, clk.Now().Sub(start), "test %d", i)
+	}
+}
+
+func TestDeleteContainerUnmountFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &stopConfirmSandbox{
+		Sandbox:    vcmock.Sandbox{MockID: testSandboxID},
+		agentState: types.StateStopped,
+	}
+
+	s := &service{
+		id:            testSandboxID,
+		sandbox:       sandbox,
+		containers:    make(map[string]*container),
+		mount:         true,
+		strictUnmount: true,
+		mounter:       &fakeMounter{errs: []error{syscall.EPERM}},
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	err = deleteContainer(context.Background(), s, c)
+	assert.Equal(syscall.EPERM, err)
+	assert.NotContains(s.containers, testContainerID)
+}
+
+func TestDeleteContainerLeakedMount(t *testing.T) {
+	assert := assert.New(t)
+
+	savedIsMounted := isMounted
+	defer func() {
+		isMounted = savedIsMounted
+	}()
+
+	var checked []string
+	isMounted = func(path string) (bool, error) {
+		checked = append(checked, path)
+		return true, nil
+	}
+
+	sandbox := &stopConfirmSandbox{
+		Sandbox:    vcmock.Sandbox{MockID: testSandboxID},
+		agentState: types.StateStopped,
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		mount:      true,
+		mounter:    &fakeMounter{},
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID, Bundle: "/bundle"}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	// a leaked mount is only reported
+	err = deleteContainer(context.Background(), s, c)
+	assert.NoError(err)
+	assert.NotContains(s.containers, testContainerID)
+	assert.Equal([]string{"/bundle/rootfs"}, checked)
+}
+
+func TestDeleteSandboxContainerBusy(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &deleteOrderSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	sc, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testSandboxID}, vc.PodSandbox, nil)
+	assert.NoError(err)
+	s.containers[sc.id] = sc
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodContainer, nil)
+	assert.NoError(err)
+	s.containers[c.id] = c
+
+	// refused while an app container remains
+	err = deleteContainer(context.Background(), s, sc)
+	assert.Equal(ErrSandboxContainerBusy, err)
+	assert.Empty(sandbox.deleted)
+	assert.Contains(s.containers, testSandboxID)
+
+	err = deleteContainer(context.Background(), s, c)
+	assert.NoError(err)
+
+	// allowed once the sandbox container is alone
+	err = deleteContainer(context.Background(), s, sc)
+	assert.NoError(err)
+	assert.Equal([]string{testContainerID, testSandboxID}, sandbox.deleted)
+	assert.Empty(s.containers)
+}
+
+// partialSandbox fails the container status and deletion with the
+// configured errors.
+type partialSandbox struct {
+	vcmock.Sandbox
+	statusErr error
+	deleteErr error
+	deleted   []string
+}
+
+func (s *partialSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	if s.statusErr != nil {
+		return vc.ContainerStatus{}, s.statusErr
+	}
+
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: types.StateStopped},
+	}, nil
+}
+
+func (s *partialSandbox) DeleteContainer(contID string) (vc.VCContainer, error) {
+	s.deleted = append(s.deleted, contID)
+	return &vcmock.Container{}, s.deleteErr
+}
+
+func TestDeleteContainerNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	notFound := pkgErrors.Wrapf(vcTypes.ErrNoSuchContainer, "container %s", testContainerID)
+	otherErr := errors.New("agent failure")
+
+	data := []struct {
+		statusErr error
+		deleteErr error
+		deleted   int
+		expectErr error
+	}{
+		// never made it to the agent
+		{notFound, nil, 0, nil},
+		// vanished from the sandbox while being deleted
+		{nil, notFound, 1, nil},
+		// the other errors are still reported
+		{otherErr, nil, 0, otherErr},
+		{nil, otherErr, 1, otherErr},
+	}
+
+	for i, d := range data {
+		u := &fakeMounter{}
+
+		sandbox := &partialSandbox{
+			Sandbox:   vcmock.Sandbox{MockID: testSandboxID},
+			statusErr: d.statusErr,
+			deleteErr: d.deleteErr,
+		}
+
+		s := &service{
+			id:         testSandboxID,
+			sandbox:    sandbox,
+			containers: make(map[string]*container),
+			mount:      true,
+			mounter:    u,
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID, Bundle: "/bundle"}, "", nil)
+		assert.NoError(err, "test %d", i)
+		s.containers[testContainerID] = c
+
+		err = deleteContainer(context.Background(), s, c)
+		assert.Len(sandbox.deleted, d.deleted, "test %d", i)
+		if d.expectErr != nil {
+			assert.Equal(d.expectErr, err, "test %d", i)
+			assert.Contains(s.containers, testContainerID, "test %d", i)
+			assert.Equal(0, u.calls, "test %d", i)
+			continue
+		}
+
+		assert.NoError(err, "test %d", i)
+		assert.NotContains(s.containers, testContainerID, "test %d", i)
+		assert.Equal([]string{"/bundle/rootfs"}, u.targets, "test %d", i)
+		assert.Equal([]int{0}, u.flags, "test %d", i)
+	}
+}
+
+func TestUnmountRootfsLazyFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	// success on the first try, no lazy unmount
+	u := &fakeMounter{}
+	err := unmountRootfs(u, realClock{}, "/bundle/rootfs", true)
+	assert.NoError(err)
+	assert.Equal([]int{0}, u.flags)
+
+	// fallback to a lazy unmount
+	u = &fakeMounter{errs: []error{syscall.EPERM}}
+	err = unmountRootfs(u, realClock{}, "/bundle/rootfs", true)
+	assert.NoError(err)
+	assert.Equal([]int{0, syscall.MNT_DETACH}, u.flags)
+
+	// lazy unmount failure
+	u = &fakeMounter{errs: []error{syscall.EPERM, syscall.EPERM}}
+	err = unmountRootfs(u, realClock{}, "/bundle/rootfs", true)
+	assert.Error(err)
+	assert.Equal(syscall.EPERM, pkgErrors.Cause(err))
+
+	// strict behavior
+	u = &fakeMounter{errs: []error{syscall.EPERM}}
+	err = unmountRootfs(u, realClock{}, "/bundle/rootfs", false)
+	assert.Equal(syscall.EPERM, err)
+	assert.Equal([]int{0}, u.flags)
+}
+
+// TestConcurrentStartDelete is meant to be run with the race detector: the
+// execs are created, started, waited for and deleted while the container is
+// started, and its wait goroutines update the processes.
+func TestConcurrentStartDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		ec:         make(chan exit),
+	}
+	go func() {
+		for range s.ec {
+		}
+	}()
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodContainer, nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	spec, err := typeurl.MarshalAny(&specs.Process{Args: []string{"sh"}})
+	assert.NoError(err)
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := s.Start(ctx, &taskAPI.StartRequest{ID: testContainerID})
+		assert.NoError(err)
+		_, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID})
+		assert.NoError(err)
+	}()
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(execID string) {
+			defer wg.Done()
+
+			_, err := s.Exec(ctx, &taskAPI.ExecProcessRequest{ID: testContainerID, ExecID: execID, Spec: spec})
+			assert.NoError(err)
+			_, err = s.Start(ctx, &taskAPI.StartRequest{ID: testContainerID, ExecID: execID})
+			assert.NoError(err)
+			_, err = s.State(ctx, &taskAPI.StateRequest{ID: testContainerID, ExecID: execID})
+			assert.NoError(err)
+			_, err = s.State(ctx, &taskAPI.StateRequest{ID: testContainerID})
+			assert.NoError(err)
+			_, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID, ExecID: execID})
+			assert.NoError(err)
+			_, err = s.Delete(ctx, &taskAPI.DeleteRequest{ID: testContainerID, ExecID: execID})
+			assert.NoError(err)
+		}(fmt.Sprintf("exec-%d", i))
+	}
+	wg.Wait()
+
+	assert.Empty(c.execs)
+
+	// a single concurrent delete wins
+	deleted := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := s.Delete(ctx, &taskAPI.DeleteRequest{ID: testContainerID})
+			deleted <- err
+		}()
+	}
+
+	results := []codes.Code{status.Code(<-deleted), status.Code(<-deleted)}
+	assert.Contains(results, codes.OK)
+	assert.Contains(results, codes.NotFound)
+	assert.Empty(s.containers)
+}
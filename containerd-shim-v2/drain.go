@@ -0,0 +1,107 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDrainTimeout bounds the stop of all the containers when the shim
+// is terminated and the service doesn't set any drain timeout.
+const defaultDrainTimeout = 30 * time.Second
+
+// handleTermination drains the containers when the shim gets SIGTERM, e.g.
+// on host shutdown, which the containerd shim library otherwise ignores.
+// The shim keeps serving afterwards, for containerd to collect the exits
+// and delete the tasks.
+func (s *service) handleTermination(signals <-chan os.Signal) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case sig := <-signals:
+			logrus.WithField("signal", sig).Info("draining the containers")
+			if err := s.drain(); err != nil {
+				logrus.WithError(err).Warn("failed to drain the containers")
+			}
+		}
+	}
+}
+
+// drain stops all the running containers of the service, in their delete
+// order, the way cleanupContainer stops one: each is sent its stop signal
+// and given its grace period before being killed. The whole drain is
+// bounded by the drain timeout, the grace periods being cut short to fit
+// in, and the containers left once it is over being killed at once. A
+// container failing to stop doesn't prevent the others from being
+// stopped, all the failures are returned together.
+func (s *service) drain() error {
+	clk := s.getClock()
+
+	timeout := s.drainTimeout
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	deadline := clk.Now().Add(timeout)
+
+	// The containers are only snapshotted under the service lock, so that
+	// the drain doesn't block the task API calls reaping them.
+	s.mu.Lock()
+	sandbox := s.sandbox
+	var ids []string
+	for _, c := range deleteOrder(s.containers) {
+		ids = append(ids, c.id)
+	}
+	s.mu.Unlock()
+
+	if sandbox == nil {
+		return nil
+	}
+
+	var failed []string
+	for _, cid := range ids {
+		if err := drainContainer(sandbox, clk, cid, deadline); err != nil {
+			logrus.WithError(err).WithField("container", cid).Warn("failed to stop container")
+			failed = append(failed, fmt.Sprintf("%s: %v", cid, err))
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("%d container(s) failed to stop: %s", len(failed), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// drainContainer stops the container if it is still running, before the
+// drain deadline.
+func drainContainer(sandbox vc.VCSandbox, clk clock, cid string, deadline time.Time) error {
+	status, err := sandbox.StatusContainer(cid)
+	if err != nil {
+		return err
+	}
+
+	if oci.StateToOCIState(status.State.State) == oci.StateStopped {
+		return nil
+	}
+
+	signal, timeout := stopConfig(cid, status)
+	if left := deadline.Sub(clk.Now()); left <= 0 {
+		signal = syscall.SIGKILL
+	} else if left < timeout {
+		timeout = left
+	}
+
+	return stopWithin(sandbox, clk, cid, signal, timeout)
+}
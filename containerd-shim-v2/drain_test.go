@@ -0,0 +1,158 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// drainSandbox runs several containers, each stopping like a
+// stopSignalSandbox, an unknown container failing its status.
+type drainSandbox struct {
+	vcmock.Sandbox
+	containers map[string]*stopSignalSandbox
+}
+
+func (s *drainSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	c, ok := s.containers[contID]
+	if !ok {
+		return vc.ContainerStatus{}, vcTypes.ErrNoSuchContainer
+	}
+
+	return c.StatusContainer(contID)
+}
+
+func (s *drainSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	return s.containers[contID].KillContainer(contID, signal, all)
+}
+
+func TestDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &pollClock{}
+	sandbox := &drainSandbox{
+		Sandbox:    vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*stopSignalSandbox),
+	}
+
+	s := &service{
+		id:           testSandboxID,
+		sandbox:      sandbox,
+		containers:   make(map[string]*container),
+		clock:        clk,
+		drainTimeout: 15 * time.Second,
+	}
+
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: id}, "", nil)
+		assert.NoError(err)
+		s.containers[id] = c
+	}
+
+	newStopping := func(stopsOn ...syscall.Signal) *stopSignalSandbox {
+		return &stopSignalSandbox{
+			clk:       clk,
+			stopsOn:   stopsOn,
+			stopAfter: 2,
+		}
+	}
+
+	// "a" stops on SIGTERM, "b" ignores it, "c" is already stopped and
+	// "d" cannot be queried, "e" and "f" ignore SIGTERM too
+	sandbox.containers["a"] = newStopping(syscall.SIGTERM)
+	sandbox.containers["b"] = newStopping()
+	sandbox.containers["c"] = &stopSignalSandbox{clk: clk, stopped: true}
+	sandbox.containers["e"] = newStopping()
+	sandbox.containers["f"] = newStopping()
+
+	err := s.drain()
+	assert.Error(err)
+	assert.Contains(err.Error(), "1 container(s) failed to stop: d:")
+
+	assert.Equal([]syscall.Signal{syscall.SIGTERM}, sandbox.containers["a"].signals)
+	assert.Empty(sandbox.containers["c"].signals)
+
+	// "b" is killed once its full grace period is over
+	b := sandbox.containers["b"]
+	assert.Equal([]syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, b.signals)
+	assert.Equal(defaultStopTimeout, b.sentAt[1].Sub(b.sentAt[0]))
+
+	// the grace period of "e" is cut short by the drain deadline
+	e := sandbox.containers["e"]
+	assert.Equal([]syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, e.signals)
+	assert.Equal(15*time.Second, e.sentAt[1].Sub(time.Time{}))
+
+	// "f" is left once the deadline is over, it is killed at once
+	assert.Equal([]syscall.Signal{syscall.SIGKILL}, sandbox.containers["f"].signals)
+
+	// the containers are only stopped, containerd deletes them
+	assert.Len(s.containers, 6)
+}
+
+// lockingSandbox takes the service lock when a container is killed, as the
+// task API calls reaping the container exits would.
+type lockingSandbox struct {
+	drainSandbox
+	s *service
+}
+
+func (s *lockingSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	return s.drainSandbox.KillContainer(contID, signal, all)
+}
+
+func TestDrainUnlocked(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &pollClock{}
+	sandbox := &lockingSandbox{
+		drainSandbox: drainSandbox{
+			Sandbox:    vcmock.Sandbox{MockID: testSandboxID},
+			containers: make(map[string]*stopSignalSandbox),
+		},
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		clock:      clk,
+	}
+	sandbox.s = s
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	sandbox.containers[testContainerID] = &stopSignalSandbox{
+		clk:       clk,
+		stopsOn:   []syscall.Signal{syscall.SIGTERM},
+		stopAfter: 2,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.drain()
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the drain holds the service lock")
+	}
+
+	assert.Equal([]syscall.Signal{syscall.SIGTERM}, sandbox.containers[testContainerID].signals)
+}
@@ -6,6 +6,7 @@
 package containerdshim
 
 import (
+	"fmt"
 	"strings"
 	"syscall"
 
@@ -16,6 +17,41 @@ import (
 	vc "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 )
 
+// ErrSandboxContainerBusy is returned when deleting the sandbox container
+// while other containers are still part of the sandbox.
+var ErrSandboxContainerBusy = errors.New("Sandbox container cannot be deleted while containers remain in the sandbox")
+
+// ErrContainerNotRunning is returned when requesting the stats of a
+// container which is not running.
+var ErrContainerNotRunning = errors.New("Container is not running")
+
+// The errors below are wrapped with the details of the failure, they are
+// matched with errors.Cause.
+var (
+	// ErrContainerNotFound is returned when the service has no
+	// container of the requested id.
+	ErrContainerNotFound = errors.New("container does not exist")
+
+	// ErrSandboxNotCreated is returned when a container needs the
+	// sandbox, which hasn't been created yet.
+	ErrSandboxNotCreated = errors.New("the sandbox hasn't been created")
+
+	// ErrSandboxExists is returned when creating a sandbox while the
+	// service already has one.
+	ErrSandboxExists = errors.New("the sandbox has already been created")
+
+	// ErrContainerTypeEmpty is returned when starting a container whose
+	// type is not known.
+	ErrContainerTypeEmpty = errors.New("the container type is empty")
+
+	// ErrInvalidContainerID is returned for a missing or malformed
+	// container id.
+	ErrInvalidContainerID = errors.New("Invalid container ID")
+
+	// ErrInvalidBundle is returned for a missing or unusable bundle.
+	ErrInvalidBundle = errors.New("Invalid bundle")
+)
+
 // toGRPC maps the virtcontainers error into a grpc error,
 // using the original error message as a description.
 func toGRPC(err error) error {
@@ -28,12 +64,24 @@ func toGRPC(err error) error {
 		return err
 	}
 
-	err = errors.Cause(err)
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrContainerNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrInvalidContainerID, ErrInvalidBundle:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case ErrSandboxNotCreated, ErrSandboxExists:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	err = cause
 	switch {
 	case isInvalidArgument(err):
-		return status.Errorf(codes.InvalidArgument, err.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
 	case isNotFound(err):
-		return status.Errorf(codes.NotFound, err.Error())
+		return status.Error(codes.NotFound, err.Error())
+	case err == ErrSandboxContainerBusy, err == ErrContainerNotRunning:
+		return status.Error(codes.FailedPrecondition, err.Error())
 	}
 
 	return err
@@ -45,6 +93,31 @@ func toGRPCf(err error, format string, args ...interface{}) error {
 	return toGRPC(errors.Wrapf(err, format, args...))
 }
 
+// cleanupErrors gathers the errors of all the failed cleanup steps.
+type cleanupErrors struct {
+	errs []error
+}
+
+func (e *cleanupErrors) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("%d cleanup step(s) failed: %s", len(e.errs), strings.Join(msgs, "; "))
+}
+
+// Errors returns all the errors collected during the cleanup.
+func (e *cleanupErrors) Errors() []error {
+	return e.errs
+}
+
+// isContainerNotFound reports whether the error means the sandbox has
+// no such container.
+func isContainerNotFound(err error) bool {
+	return errors.Cause(err) == vc.ErrNoSuchContainer
+}
+
 func isGRPCError(err error) bool {
 	_, ok := status.FromError(err)
 	return ok
@@ -60,3 +133,61 @@ func isNotFound(err error) bool {
 	return err == vc.ErrNoSuchContainer || err == syscall.ENOENT ||
 		strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "not exist")
 }
+
+// codedError attaches a grpc code to an error, keeping the original
+// error available through Cause().
+type codedError struct {
+	code codes.Code
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Cause() error {
+	return e.err
+}
+
+// GRPCStatus makes the code visible to the grpc and ttrpc status helpers.
+func (e *codedError) GRPCStatus() *status.Status {
+	return status.New(e.code, e.err.Error())
+}
+
+// agentErrorCode walks the chain of wrapped errors looking for a code
+// reported by the agent, either as a grpc status or as a system error.
+func agentErrorCode(err error) (codes.Code, bool) {
+	for err != nil {
+		if errno, ok := err.(syscall.Errno); ok {
+			return errnoToCode(errno), true
+		}
+
+		if st, ok := status.FromError(err); ok {
+			return st.Code(), true
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return codes.Unknown, false
+		}
+		err = cause.Cause()
+	}
+
+	return codes.Unknown, false
+}
+
+// errnoToCode maps a system error to the closest grpc code.
+func errnoToCode(errno syscall.Errno) codes.Code {
+	switch errno {
+	case syscall.ENOENT:
+		return codes.NotFound
+	case syscall.EACCES, syscall.EPERM:
+		return codes.PermissionDenied
+	case syscall.EINVAL:
+		return codes.InvalidArgument
+	case syscall.EEXIST:
+		return codes.AlreadyExists
+	}
+
+	return codes.Unknown
+}
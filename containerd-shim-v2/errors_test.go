@@ -6,18 +6,23 @@
 package containerdshim
 
 import (
+	"context"
 	"syscall"
 	"testing"
 
-	vc "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestToGRPC(t *testing.T) {
 	assert := assert.New(t)
 
-	for _, err := range []error{vc.ErrNeedSandbox, vc.ErrNeedSandboxID,
-		vc.ErrNeedContainerID, vc.ErrNeedState, syscall.EINVAL, vc.ErrNoSuchContainer, syscall.ENOENT} {
+	for _, err := range []error{vcTypes.ErrNeedSandbox, vcTypes.ErrNeedSandboxID,
+		vcTypes.ErrNeedContainerID, vcTypes.ErrNeedState, syscall.EINVAL, vcTypes.ErrNoSuchContainer, syscall.ENOENT} {
 		assert.False(isGRPCError(err))
 		err = toGRPC(err)
 		assert.True(isGRPCError(err))
@@ -27,3 +32,74 @@ func TestToGRPC(t *testing.T) {
 		assert.True(isGRPCError(err))
 	}
 }
+
+func TestToGRPCSandboxContainerBusy(t *testing.T) {
+	assert := assert.New(t)
+
+	err := toGRPC(ErrSandboxContainerBusy)
+	assert.True(isGRPCError(err))
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestToGRPCContainerNotRunning(t *testing.T) {
+	assert := assert.New(t)
+
+	err := toGRPC(ErrContainerNotRunning)
+	assert.True(isGRPCError(err))
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestServiceErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		containers: make(map[string]*container),
+	}
+
+	_, err := s.getContainer("unknown")
+	assert.True(errors.Cause(err) == ErrContainerNotFound)
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Contains(err.Error(), "unknown")
+
+	c := &container{id: testContainerID}
+	err = startContainer(context.Background(), s, c)
+	assert.True(errors.Cause(err) == ErrContainerTypeEmpty)
+
+	c.cType = vc.PodContainer
+	err = startContainer(context.Background(), s, c)
+	assert.True(errors.Cause(err) == ErrSandboxNotCreated)
+	assert.Contains(err.Error(), testContainerID)
+
+	_, err = validBundle("", "/bundle")
+	assert.True(errors.Cause(err) == ErrInvalidContainerID)
+	_, err = validBundle("foo/bar", "/bundle")
+	assert.True(errors.Cause(err) == ErrInvalidContainerID)
+	_, err = validBundle(testContainerID, "")
+	assert.True(errors.Cause(err) == ErrInvalidBundle)
+	_, err = validBundle(testContainerID, "/does/not/exist")
+	assert.True(errors.Cause(err) == ErrInvalidBundle)
+}
+
+func TestToGRPCServiceErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		err  error
+		code codes.Code
+	}{
+		{ErrContainerNotFound, codes.NotFound},
+		{ErrInvalidContainerID, codes.InvalidArgument},
+		{ErrInvalidBundle, codes.InvalidArgument},
+		{ErrSandboxNotCreated, codes.FailedPrecondition},
+		{ErrSandboxExists, codes.FailedPrecondition},
+	}
+
+	for i, d := range data {
+		wrapped := errors.Wrapf(d.err, "cannot create container %s", testContainerID)
+
+		err := toGRPC(wrapped)
+		assert.Equal(d.code, status.Code(err), "test %d", i)
+		assert.Equal(wrapped.Error(), status.Convert(err).Message(), "test %d", i)
+	}
+}
@@ -33,6 +33,10 @@ type exec struct {
 	exitCh   chan uint32
 
 	exitTime time.Time
+
+	// attached is set while the IO of the exec process is copied and
+	// the process waited for.
+	attached bool
 }
 
 type tty struct {
@@ -120,7 +124,26 @@ func newExec(c *container, stdin, stdout, stderr string, terminal bool, jspec *g
 	return exec, nil
 }
 
+// runningExecs returns the number of execs of the container which are
+// started and haven't exited yet.
+func (c *container) runningExecs() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	running := 0
+	for _, e := range c.execs {
+		if e.status == task.StatusRunning {
+			running++
+		}
+	}
+
+	return running
+}
+
 func (c *container) getExec(id string) (*exec, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.execs == nil {
 		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "exec does not exist %s", id)
 	}
@@ -16,6 +16,8 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestExecNoSpecFail(t *testing.T) {
@@ -48,3 +50,53 @@ func TestExecNoSpecFail(t *testing.T) {
 	_, err = s.Exec(ctx, reqExec)
 	assert.Error(err)
 }
+
+func TestResizePty(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &winsizeSandbox{
+		ioStreamErrorSandbox: ioStreamErrorSandbox{
+			Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		},
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	execs := &exec{tty: &tty{terminal: true}}
+	c.execs[TestID] = execs
+
+	ctx := context.Background()
+
+	// the main process
+	_, err = s.ResizePty(ctx, &taskAPI.ResizePtyRequest{ID: testContainerID, Width: 80, Height: 24})
+	assert.NoError(err)
+
+	// an exec not started yet only records its size
+	_, err = s.ResizePty(ctx, &taskAPI.ResizePtyRequest{ID: testContainerID, ExecID: TestID, Width: 100, Height: 30})
+	assert.NoError(err)
+	assert.Equal(uint32(100), execs.tty.width)
+	assert.Equal(uint32(30), execs.tty.height)
+
+	// a running exec
+	execs.id = "exec-token"
+	_, err = s.ResizePty(ctx, &taskAPI.ResizePtyRequest{ID: testContainerID, ExecID: TestID, Width: 120, Height: 40})
+	assert.NoError(err)
+
+	assert.Equal([]string{testContainerID, "exec-token"}, sandbox.processes)
+	assert.Equal([][2]uint32{{24, 80}, {40, 120}}, sandbox.sizes)
+
+	_, err = s.ResizePty(ctx, &taskAPI.ResizePtyRequest{ID: testContainerID, ExecID: "unknown", Width: 80, Height: 24})
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Contains(err.Error(), "no such exec")
+
+	_, err = s.ResizePty(ctx, &taskAPI.ResizePtyRequest{ID: "unknown", Width: 80, Height: 24})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
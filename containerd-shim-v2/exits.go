@@ -0,0 +1,204 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+)
+
+// exitsFile is the file of the sandbox runtime directory remembering the
+// exits of the processes which are not deleted yet, across shim restarts.
+const exitsFile = "exits.json"
+
+// savedExit is the persisted exit of a container init process, or of one of
+// its execs.
+type savedExit struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	Status      uint32    `json:"status"`
+	ExitedAt    time.Time `json:"exited_at"`
+}
+
+type exitKey struct {
+	containerID string
+	execID      string
+}
+
+// exitStore keeps the process exits in a file until the processes are
+// deleted, so that a restarted shim can still report them.
+type exitStore struct {
+	mu    sync.Mutex
+	path  string
+	exits map[exitKey]savedExit
+}
+
+func newExitStore(path string) *exitStore {
+	return &exitStore{
+		path:  path,
+		exits: make(map[exitKey]savedExit),
+	}
+}
+
+// sandboxExitStore returns the exit store of the sandbox, loaded with the
+// exits remembered by a previous shim.
+func sandboxExitStore(sandboxID string) *exitStore {
+	st := newExitStore(filepath.Join(store.SandboxRuntimeRootPath(sandboxID), exitsFile))
+	if err := st.load(); err != nil {
+		logrus.WithError(err).WithField("sandbox", sandboxID).Warn("failed to load the remembered exits")
+	}
+
+	return st
+}
+
+// load reads the exits of the store file, a missing file holding none.
+func (st *exitStore) load() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	data, err := ioutil.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var exits []savedExit
+	if err := json.Unmarshal(data, &exits); err != nil {
+		return err
+	}
+
+	for _, e := range exits {
+		st.exits[exitKey{e.ContainerID, e.ExecID}] = e
+	}
+
+	return nil
+}
+
+// flush writes the exits to the store file, replacing it atomically, or
+// removes it once there is no exit to remember.
+func (st *exitStore) flush() error {
+	if len(st.exits) == 0 {
+		if err := os.Remove(st.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	exits := make([]savedExit, 0, len(st.exits))
+	for _, e := range st.exits {
+		exits = append(exits, e)
+	}
+	sort.Slice(exits, func(i, j int) bool {
+		if exits[i].ContainerID != exits[j].ContainerID {
+			return exits[i].ContainerID < exits[j].ContainerID
+		}
+		return exits[i].ExecID < exits[j].ExecID
+	})
+
+	data, err := json.Marshal(exits)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(st.path), store.DirMode); err != nil {
+		return err
+	}
+
+	tmp := st.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, st.path)
+}
+
+// save remembers the exit of a process.
+func (st *exitStore) save(e savedExit) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.exits[exitKey{e.ContainerID, e.ExecID}] = e
+
+	return st.flush()
+}
+
+// get returns the remembered exit of a process.
+func (st *exitStore) get(containerID, execID string) (savedExit, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.exits[exitKey{containerID, execID}]
+	return e, ok
+}
+
+// remove forgets the exit of a process, the exits of all its execs too
+// for a container init process.
+func (st *exitStore) remove(containerID, execID string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	removed := false
+	for k := range st.exits {
+		if k.containerID == containerID && (k.execID == execID || execID == "") {
+			delete(st.exits, k)
+			removed = true
+		}
+	}
+
+	if !removed {
+		return nil
+	}
+
+	return st.flush()
+}
+
+// rememberExit persists the exit of a process if the service has an exit
+// store, a failure being only logged.
+func (s *service) rememberExit(containerID, execID string, status uint32, exitedAt time.Time) {
+	if s.exits == nil {
+		return
+	}
+
+	if err := s.exits.save(savedExit{containerID, execID, status, exitedAt}); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"container": containerID,
+			"exec":      execID,
+		}).Warn("failed to remember the process exit")
+	}
+}
+
+// rememberedExit returns the exit of a process reaped by a previous shim.
+func (s *service) rememberedExit(containerID, execID string) (savedExit, bool) {
+	if s.exits == nil {
+		return savedExit{}, false
+	}
+
+	return s.exits.get(containerID, execID)
+}
+
+// forgetExit drops the remembered exit of a deleted process.
+func (s *service) forgetExit(containerID, execID string) {
+	if s.exits == nil {
+		return
+	}
+
+	if err := s.exits.remove(containerID, execID); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"container": containerID,
+			"exec":      execID,
+		}).Warn("failed to forget the process exit")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExitStore(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "exits")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, testSandboxID, exitsFile)
+	exitedAt := time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	st := newExitStore(path)
+	assert.NoError(st.load())
+	assert.NoError(st.save(savedExit{testContainerID, "", 1, exitedAt}))
+	assert.NoError(st.save(savedExit{testContainerID, TestID, 137, exitedAt}))
+	assert.NoError(st.save(savedExit{"other", "", 2, exitedAt}))
+
+	// a restarted shim reads them back
+	st = newExitStore(path)
+	assert.NoError(st.load())
+	e, ok := st.get(testContainerID, TestID)
+	assert.True(ok)
+	assert.Equal(uint32(137), e.Status)
+	assert.True(exitedAt.Equal(e.ExitedAt))
+
+	// deleting a container forgets its execs too
+	assert.NoError(st.remove(testContainerID, ""))
+	_, ok = st.get(testContainerID, TestID)
+	assert.False(ok)
+	_, ok = st.get("other", "")
+	assert.True(ok)
+
+	assert.NoError(st.remove("other", ""))
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+
+	// corrupted file
+	assert.NoError(ioutil.WriteFile(path, []byte("{"), 0600))
+	assert.Error(newExitStore(path).load())
+}
+
+func TestWaitRememberedExit(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "exits")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, exitsFile)
+	clk := &fakeClock{now: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+		clock:      clk,
+		exits:      newExitStore(path),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	close(c.exitIOch)
+
+	_, err = wait(s, c, "")
+	assert.NoError(err)
+	s.rememberExit(testContainerID, TestID, 137, clk.now)
+
+	// the shim restarts
+	s = &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		exits:      newExitStore(path),
+	}
+	assert.NoError(s.exits.load())
+
+	ctx := context.Background()
+
+	resp, err := s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID})
+	assert.NoError(err)
+	assert.Equal(uint32(0), resp.ExitStatus)
+	assert.True(clk.now.Equal(resp.ExitedAt))
+
+	resp, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID, ExecID: TestID})
+	assert.NoError(err)
+	assert.Equal(uint32(137), resp.ExitStatus)
+
+	// the exits are forgotten on delete
+	deleted, err := s.Delete(ctx, &taskAPI.DeleteRequest{ID: testContainerID, ExecID: TestID})
+	assert.NoError(err)
+	assert.Equal(uint32(137), deleted.ExitStatus)
+	_, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID, ExecID: TestID})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	_, err = s.Delete(ctx, &taskAPI.DeleteRequest{ID: testContainerID})
+	assert.NoError(err)
+	_, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sentSignal is a SignalProcess call.
+type sentSignal struct {
+	processID string
+	signum    syscall.Signal
+	all       bool
+}
+
+// signalSandbox records the signals sent to its processes.
+type signalSandbox struct {
+	vcmock.Sandbox
+	signals []sentSignal
+}
+
+func (s *signalSandbox) SignalProcess(containerID, processID string, signum syscall.Signal, all bool) error {
+	s.signals = append(s.signals, sentSignal{processID, signum, all})
+	return nil
+}
+
+func TestKill(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &signalSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	c.execs[TestID] = &exec{id: "exec-token"}
+
+	ctx := context.Background()
+
+	data := []struct {
+		execID   string
+		signum   syscall.Signal
+		all      bool
+		code     codes.Code
+		expected *sentSignal
+	}{
+		{"", syscall.SIGTERM, false, codes.OK, &sentSignal{testContainerID, syscall.SIGTERM, false}},
+		{TestID, syscall.SIGINT, false, codes.OK, &sentSignal{"exec-token", syscall.SIGINT, false}},
+		{"", syscall.Signal(maxSignal), false, codes.OK, &sentSignal{testContainerID, syscall.Signal(maxSignal), false}},
+		// broadcast to every process
+		{"", syscall.SIGKILL, true, codes.OK, &sentSignal{testContainerID, syscall.SIGKILL, true}},
+		{TestID, syscall.SIGKILL, true, codes.OK, &sentSignal{testContainerID, syscall.SIGKILL, true}},
+		// invalid signals
+		{"", 0, false, codes.InvalidArgument, nil},
+		{"", syscall.Signal(maxSignal + 1), false, codes.InvalidArgument, nil},
+		{"unknown", syscall.SIGTERM, false, codes.NotFound, nil},
+	}
+
+	for i, d := range data {
+		sandbox.signals = nil
+
+		_, err := s.Kill(ctx, &taskAPI.KillRequest{
+			ID:     testContainerID,
+			ExecID: d.execID,
+			Signal: uint32(d.signum),
+			All:    d.all,
+		})
+		assert.Equal(d.code, status.Code(err), "test %d", i)
+
+		if d.expected == nil {
+			assert.Empty(sandbox.signals, "test %d", i)
+		} else {
+			assert.Equal([]sentSignal{*d.expected}, sandbox.signals, "test %d", i)
+		}
+	}
+}
@@ -6,21 +6,22 @@
 package containerdshim
 
 import (
+	"sort"
+
 	"github.com/containerd/cgroups"
 	"github.com/containerd/typeurl"
 
 	google_protobuf "github.com/gogo/protobuf/types"
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/types"
 )
 
 func marshalMetrics(s *service, containerID string) (*google_protobuf.Any, error) {
-	stats, err := s.sandbox.StatsContainer(containerID)
+	metrics, err := containerMetrics(s, containerID)
 	if err != nil {
 		return nil, err
 	}
 
-	metrics := statsToMetrics(stats.CgroupStats)
-
 	data, err := typeurl.MarshalAny(metrics)
 	if err != nil {
 		return nil, err
@@ -29,15 +30,49 @@ func marshalMetrics(s *service, containerID string) (*google_protobuf.Any, error
 	return data, nil
 }
 
+// containerMetrics retrieves the cgroup stats of the container from the
+// guest. The stats of a paused container are still reported, as its
+// processes keep their resources.
+func containerMetrics(s *service, containerID string) (*cgroups.Metrics, error) {
+	status, err := s.sandbox.StatusContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state := status.State.State; state != types.StateRunning && state != types.StatePaused {
+		return nil, ErrContainerNotRunning
+	}
+
+	stats, err := s.sandbox.StatsContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return statsToMetrics(stats.CgroupStats), nil
+}
+
 func statsToMetrics(cgStats *vc.CgroupStats) *cgroups.Metrics {
+	if cgStats == nil {
+		return &cgroups.Metrics{}
+	}
+
+	// Keep the hugepage sizes ordered, for stable metrics.
+	pagesizes := make([]string, 0, len(cgStats.HugetlbStats))
+	for pagesize := range cgStats.HugetlbStats {
+		pagesizes = append(pagesizes, pagesize)
+	}
+	sort.Strings(pagesizes)
+
 	var hugetlb []*cgroups.HugetlbStat
-	for _, v := range cgStats.HugetlbStats {
+	for _, pagesize := range pagesizes {
+		v := cgStats.HugetlbStats[pagesize]
 		hugetlb = append(
 			hugetlb,
 			&cgroups.HugetlbStat{
-				Usage:   v.Usage,
-				Max:     v.MaxUsage,
-				Failcnt: v.Failcnt,
+				Usage:    v.Usage,
+				Max:      v.MaxUsage,
+				Failcnt:  v.Failcnt,
+				Pagesize: pagesize,
 			})
 	}
 
@@ -53,17 +88,59 @@ func statsToMetrics(cgStats *vc.CgroupStats) *cgroups.Metrics {
 		CPU: &cgroups.CPUStat{
 			Usage: &cgroups.CPUUsage{
 				Total:  cgStats.CPUStats.CPUUsage.TotalUsage,
+				Kernel: cgStats.CPUStats.CPUUsage.UsageInKernelmode,
+				User:   cgStats.CPUStats.CPUUsage.UsageInUsermode,
 				PerCPU: perCPU,
 			},
+			Throttling: &cgroups.Throttle{
+				Periods:          cgStats.CPUStats.ThrottlingData.Periods,
+				ThrottledPeriods: cgStats.CPUStats.ThrottlingData.ThrottledPeriods,
+				ThrottledTime:    cgStats.CPUStats.ThrottlingData.ThrottledTime,
+			},
 		},
 		Memory: &cgroups.MemoryStat{
-			Cache: cgStats.MemoryStats.Cache,
-			Usage: &cgroups.MemoryEntry{
-				Limit: cgStats.MemoryStats.Usage.Limit,
-				Usage: cgStats.MemoryStats.Usage.Usage,
-			},
+			Cache:     cgStats.MemoryStats.Cache,
+			Usage:     memoryEntry(cgStats.MemoryStats.Usage),
+			Swap:      memoryEntry(cgStats.MemoryStats.SwapUsage),
+			Kernel:    memoryEntry(cgStats.MemoryStats.KernelUsage),
+			KernelTCP: memoryEntry(cgStats.MemoryStats.KernelTCPUsage),
+		},
+		Blkio: &cgroups.BlkIOStat{
+			IoServiceBytesRecursive: blkioEntries(cgStats.BlkioStats.IoServiceBytesRecursive),
+			IoServicedRecursive:     blkioEntries(cgStats.BlkioStats.IoServicedRecursive),
+			IoQueuedRecursive:       blkioEntries(cgStats.BlkioStats.IoQueuedRecursive),
+			IoServiceTimeRecursive:  blkioEntries(cgStats.BlkioStats.IoServiceTimeRecursive),
+			IoWaitTimeRecursive:     blkioEntries(cgStats.BlkioStats.IoWaitTimeRecursive),
+			IoMergedRecursive:       blkioEntries(cgStats.BlkioStats.IoMergedRecursive),
+			IoTimeRecursive:         blkioEntries(cgStats.BlkioStats.IoTimeRecursive),
+			SectorsRecursive:        blkioEntries(cgStats.BlkioStats.SectorsRecursive),
 		},
 	}
 
 	return metrics
 }
+
+func memoryEntry(data vc.MemoryData) *cgroups.MemoryEntry {
+	return &cgroups.MemoryEntry{
+		Limit:   data.Limit,
+		Usage:   data.Usage,
+		Max:     data.MaxUsage,
+		Failcnt: data.Failcnt,
+	}
+}
+
+func blkioEntries(entries []vc.BlkioStatEntry) []*cgroups.BlkIOEntry {
+	var blkio []*cgroups.BlkIOEntry
+	for _, e := range entries {
+		blkio = append(
+			blkio,
+			&cgroups.BlkIOEntry{
+				Op:    e.Op,
+				Major: e.Major,
+				Minor: e.Minor,
+				Value: e.Value,
+			})
+	}
+
+	return blkio
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2018 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/cgroups"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statsSandbox reports the configured container state and canned stats.
+type statsSandbox struct {
+	vcmock.Sandbox
+	state types.StateString
+	stats vc.ContainerStats
+}
+
+func (s *statsSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: s.state},
+	}, nil
+}
+
+func (s *statsSandbox) StatsContainer(contID string) (vc.ContainerStats, error) {
+	return s.stats, nil
+}
+
+func testCgroupStats() *vc.CgroupStats {
+	return &vc.CgroupStats{
+		CPUStats: vc.CPUStats{
+			CPUUsage: vc.CPUUsage{
+				TotalUsage:        300,
+				PercpuUsage:       []uint64{100, 200},
+				UsageInKernelmode: 50,
+				UsageInUsermode:   250,
+			},
+			ThrottlingData: vc.ThrottlingData{
+				Periods:          10,
+				ThrottledPeriods: 2,
+				ThrottledTime:    1000,
+			},
+		},
+		MemoryStats: vc.MemoryStats{
+			Cache: 4096,
+			Usage: vc.MemoryData{
+				Usage:    1024,
+				MaxUsage: 2048,
+				Failcnt:  1,
+				Limit:    8192,
+			},
+			SwapUsage: vc.MemoryData{Usage: 512},
+		},
+		PidsStats: vc.PidsStats{
+			Current: 3,
+			Limit:   100,
+		},
+		BlkioStats: vc.BlkioStats{
+			IoServiceBytesRecursive: []vc.BlkioStatEntry{
+				{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+			},
+		},
+		HugetlbStats: map[string]vc.HugetlbStats{
+			"2MB": {Usage: 2, MaxUsage: 4, Failcnt: 0},
+			"1GB": {Usage: 1, MaxUsage: 1, Failcnt: 1},
+		},
+	}
+}
+
+func TestStatsToMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := statsToMetrics(testCgroupStats())
+
+	assert.Equal(&cgroups.CPUUsage{
+		Total:  300,
+		Kernel: 50,
+		User:   250,
+		PerCPU: []uint64{100, 200},
+	}, metrics.CPU.Usage)
+	assert.Equal(&cgroups.Throttle{
+		Periods:          10,
+		ThrottledPeriods: 2,
+		ThrottledTime:    1000,
+	}, metrics.CPU.Throttling)
+
+	assert.Equal(uint64(4096), metrics.Memory.Cache)
+	assert.Equal(&cgroups.MemoryEntry{
+		Limit:   8192,
+		Usage:   1024,
+		Max:     2048,
+		Failcnt: 1,
+	}, metrics.Memory.Usage)
+	assert.Equal(uint64(512), metrics.Memory.Swap.Usage)
+
+	assert.Equal(&cgroups.PidsStat{Current: 3, Limit: 100}, metrics.Pids)
+
+	assert.Equal([]*cgroups.BlkIOEntry{
+		{Op: "Read", Major: 8, Minor: 0, Value: 4096},
+	}, metrics.Blkio.IoServiceBytesRecursive)
+	assert.Empty(metrics.Blkio.IoServicedRecursive)
+
+	// ordered by page size
+	assert.Equal([]*cgroups.HugetlbStat{
+		{Usage: 1, Max: 1, Failcnt: 1, Pagesize: "1GB"},
+		{Usage: 2, Max: 4, Failcnt: 0, Pagesize: "2MB"},
+	}, metrics.Hugetlb)
+
+	// the agent may not report any stats
+	assert.Equal(&cgroups.Metrics{}, statsToMetrics(nil))
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &statsSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		state:   types.StateRunning,
+		stats:   vc.ContainerStats{CgroupStats: testCgroupStats()},
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	resp, err := s.Stats(context.Background(), &taskAPI.StatsRequest{ID: testContainerID})
+	assert.NoError(err)
+
+	v, err := typeurl.UnmarshalAny(resp.Stats)
+	assert.NoError(err)
+	metrics, ok := v.(*cgroups.Metrics)
+	assert.True(ok)
+	assert.Equal(uint64(300), metrics.CPU.Usage.Total)
+	assert.Equal(uint64(1024), metrics.Memory.Usage.Usage)
+
+	// still reported while paused
+	sandbox.state = types.StatePaused
+	_, err = s.Stats(context.Background(), &taskAPI.StatsRequest{ID: testContainerID})
+	assert.NoError(err)
+
+	for _, state := range []types.StateString{types.StateReady, types.StateStopped} {
+		sandbox.state = state
+		_, err = s.Stats(context.Background(), &taskAPI.StatsRequest{ID: testContainerID})
+		assert.Error(err, "state %s", state)
+		assert.Equal(codes.FailedPrecondition, status.Code(err), "state %s", state)
+	}
+
+	_, err = s.Stats(context.Background(), &taskAPI.StatsRequest{ID: "unknown"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"github.com/containerd/containerd/mount"
+)
+
+// mounter unmounts the host mounts of the shim, e.g. the container rootfs,
+// so that unit tests can run without root and real mounts.
+type mounter interface {
+	// UnmountAll unmounts all the mounts stacked on target.
+	UnmountAll(target string, flags int) error
+}
+
+// containerdMounter is the default mounter, relying on the containerd
+// mount package.
+type containerdMounter struct{}
+
+func (containerdMounter) UnmountAll(target string, flags int) error {
+	return mount.UnmountAll(target, flags)
+}
+
+// getMounter returns the mounter of the service, defaulting to the
+// containerd one.
+func (s *service) getMounter() mounter {
+	if s == nil || s.mounter == nil {
+		return containerdMounter{}
+	}
+
+	return s.mounter
+}
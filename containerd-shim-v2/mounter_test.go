@@ -0,0 +1,48 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMounter records the unmounts and returns the configured errors in
+// sequence, then succeeds.
+type fakeMounter struct {
+	errs    []error
+	calls   int
+	targets []string
+	flags   []int
+}
+
+func (m *fakeMounter) UnmountAll(target string, flags int) error {
+	m.calls++
+	m.targets = append(m.targets, target)
+	m.flags = append(m.flags, flags)
+	if len(m.errs) == 0 {
+		return nil
+	}
+
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return err
+}
+
+func TestGetMounter(t *testing.T) {
+	assert := assert.New(t)
+
+	var s *service
+	assert.Equal(containerdMounter{}, s.getMounter())
+
+	s = &service{}
+	assert.Equal(containerdMounter{}, s.getMounter())
+
+	m := &fakeMounter{}
+	s.mounter = m
+	assert.Equal(m, s.getMounter())
+}
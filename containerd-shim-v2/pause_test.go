@@ -8,8 +8,11 @@ package containerdshim
 
 import (
 	"context"
+	"errors"
 	"testing"
 
+	"github.com/containerd/containerd/api/types/task"
+
 	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 
@@ -18,6 +21,8 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/types"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestPauseContainerSuccess(t *testing.T) {
@@ -201,3 +206,93 @@ func TestResumeContainerFail(t *testing.T) {
 	_, err := s.Resume(ctx, reqResume)
 	assert.Error(err)
 }
+
+// freezeSandbox records the freezer transitions requested for the
+// containers, failing them with the configured error.
+type freezeSandbox struct {
+	vcmock.Sandbox
+	calls []string
+	err   error
+	state types.StateString
+}
+
+func (s *freezeSandbox) PauseContainer(contID string) error {
+	s.calls = append(s.calls, "pause")
+	return s.err
+}
+
+func (s *freezeSandbox) ResumeContainer(contID string) error {
+	s.calls = append(s.calls, "resume")
+	return s.err
+}
+
+func (s *freezeSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: s.state},
+	}, nil
+}
+
+func TestPauseResumeTransitions(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &freezeSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	c.status = task.StatusRunning
+
+	ctx := context.Background()
+	pause := &taskAPI.PauseRequest{ID: testContainerID}
+	resume := &taskAPI.ResumeRequest{ID: testContainerID}
+
+	// resuming a running container is a no-op
+	_, err = s.Resume(ctx, resume)
+	assert.NoError(err)
+	assert.Empty(sandbox.calls)
+	assert.Equal(task.StatusRunning, c.status)
+
+	_, err = s.Pause(ctx, pause)
+	assert.NoError(err)
+	assert.Equal(task.StatusPaused, c.status)
+
+	// pausing again doesn't reach the agent
+	_, err = s.Pause(ctx, pause)
+	assert.NoError(err)
+	assert.Equal([]string{"pause"}, sandbox.calls)
+
+	_, err = s.Resume(ctx, resume)
+	assert.NoError(err)
+	assert.Equal(task.StatusRunning, c.status)
+	assert.Equal([]string{"pause", "resume"}, sandbox.calls)
+
+	// a failed transition resyncs the status with the sandbox
+	sandbox.err = errors.New("freezer failure")
+	sandbox.state = types.StateRunning
+	_, err = s.Pause(ctx, pause)
+	assert.Error(err)
+	assert.Equal(task.StatusRunning, c.status)
+
+	// a stopped container cannot be paused
+	sandbox.err = nil
+	sandbox.calls = nil
+	c.status = task.StatusStopped
+	_, err = s.Pause(ctx, pause)
+	assert.Error(err)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	assert.Equal(task.StatusStopped, c.status)
+
+	_, err = s.Resume(ctx, resume)
+	assert.NoError(err)
+	assert.Empty(sandbox.calls)
+	assert.Equal(task.StatusStopped, c.status)
+}
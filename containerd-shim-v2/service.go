@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	sysexec "os/exec"
+	"os/signal"
 	"sync"
 	"syscall"
 	"time"
@@ -33,6 +34,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
 )
 
 const (
@@ -44,9 +46,17 @@ const (
 	chSize      = 128
 	exitCode255 = 255
 
+	// execTimeoutExitCode is the exit status reported for the execs
+	// whose exit hasn't been observed before their wait timeout.
+	execTimeoutExitCode = 124
+
 	// A time span used to wait for publish a containerd event,
 	// once it costs a longer time than timeOut, it will be canceld.
 	timeOut = 5 * time.Second
+
+	// The maximum number of exits kept while no consumer is
+	// attached to the service's exit channel.
+	maxBufferedExits = 1024
 )
 
 var (
@@ -78,12 +88,19 @@ func New(ctx context.Context, id string, publisher events.Publisher) (cdshim.Shi
 		ec:         make(chan exit, bufferSize),
 		cancel:     cancel,
 		mount:      false,
+		exits:      sandboxExitStore(id),
+		// processExits may not run before the first exits are reaped.
+		bufferExits: true,
 	}
 
 	go s.processExits()
 
 	go s.forward(publisher)
 
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM)
+	go s.handleTermination(terminate)
+
 	return s, nil
 }
 
@@ -97,6 +114,9 @@ type exit struct {
 
 // service is the shim implementation of a remote shim over GRPC
 type service struct {
+	// mu serializes the task API calls, it protects containers and
+	// the execs of each container, which are only added or removed
+	// holding the container mu too.
 	mu          sync.Mutex
 	eventSendMu sync.Mutex
 
@@ -109,6 +129,55 @@ type service struct {
 	// will not do the rootfs mount.
 	mount bool
 
+	// if set, a container rootfs which cannot be unmounted is left
+	// mounted instead of being lazily detached.
+	strictUnmount bool
+
+	// stopConfirm selects how the container stop is confirmed
+	// before deleting it.
+	stopConfirm stopConfirmSource
+
+	// logRateLimit is the maximum number of output lines per second
+	// forwarded for each container, 0 disables the limit.
+	logRateLimit uint
+
+	// if set, the output of the container init processes is also
+	// copied to a file of this directory, see containerOutputLog.
+	outputLogDir string
+
+	// ioBufferSize is the size of the buffers copying the process
+	// IO streams, 0 selects the built-in default.
+	ioBufferSize int
+
+	// if not zero, the execs whose exit hasn't been observed within
+	// this time are reported as exited with execTimeoutExitCode.
+	execWaitTimeout time.Duration
+
+	// maxExecs is the maximum number of execs running at once in each
+	// container, 0 disables the limit.
+	maxExecs int
+
+	// the size of the exec terminals started without any explicit
+	// size, 0 selects the built-in default.
+	terminalHeight uint32
+	terminalWidth  uint32
+
+	// drainTimeout bounds the stop of all the containers when the shim
+	// is terminated, 0 selects defaultDrainTimeout.
+	drainTimeout time.Duration
+
+	// clock is used for the timestamps and the timeouts, the real
+	// clock is used if not set.
+	clock clock
+
+	// mounter is used to unmount the container rootfs, the containerd
+	// mount package is used if not set.
+	mounter mounter
+
+	// exits remembers the exits of the processes not deleted yet, for
+	// a restarted shim. They are not remembered if not set.
+	exits *exitStore
+
 	ctx        context.Context
 	sandbox    vc.VCSandbox
 	containers map[string]*container
@@ -119,6 +188,14 @@ type service struct {
 
 	ec chan exit
 	id string
+
+	// if set, the exits reaped before a consumer is attached to ec are
+	// buffered, instead of blocking cReap, and replayed on attach.
+	bufferExits   bool
+	exitsMu       sync.Mutex
+	exitsAttached bool
+	bufferedExits []exit
+	droppedExits  int
 }
 
 func newCommand(ctx context.Context, containerdBinary, id, containerdAddress string) (*sysexec.Cmd, error) {
@@ -302,7 +379,7 @@ func (s *service) Cleanup(ctx context.Context) (_ *taskAPI.DeleteResponse, err e
 
 	switch containerType {
 	case vc.PodSandbox:
-		err = cleanupContainer(ctx, s.id, s.id, path)
+		err = cleanupContainer(ctx, s.getMounter(), s.getClock(), s.id, s.id, path)
 		if err != nil {
 			return nil, err
 		}
@@ -312,14 +389,14 @@ func (s *service) Cleanup(ctx context.Context) (_ *taskAPI.DeleteResponse, err e
 			return nil, err
 		}
 
-		err = cleanupContainer(ctx, sandboxID, s.id, path)
+		err = cleanupContainer(ctx, s.getMounter(), s.getClock(), sandboxID, s.id, path)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	return &taskAPI.DeleteResponse{
-		ExitedAt:   time.Now(),
+		ExitedAt:   s.getClock().Now(),
 		ExitStatus: 128 + uint32(unix.SIGKILL),
 	}, nil
 }
@@ -427,6 +504,15 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *task
 
 	c, err := s.getContainer(r.ID)
 	if err != nil {
+		// The process may have been reaped by a previous shim.
+		if e, ok := s.rememberedExit(r.ID, r.ExecID); ok {
+			s.forgetExit(r.ID, r.ExecID)
+			return &taskAPI.DeleteResponse{
+				ExitStatus: e.Status,
+				ExitedAt:   e.ExitedAt,
+				Pid:        s.pid,
+			}, nil
+		}
 		return nil, err
 	}
 
@@ -452,16 +538,20 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *task
 			}
 		}
 
+		c.mu.Lock()
+		exitStatus, exitedAt := c.exit, c.exitTime
+		c.mu.Unlock()
+
 		s.send(&eventstypes.TaskDelete{
 			ContainerID: s.id,
 			Pid:         s.pid,
-			ExitStatus:  c.exit,
-			ExitedAt:    c.exitTime,
+			ExitStatus:  exitStatus,
+			ExitedAt:    exitedAt,
 		})
 
 		return &taskAPI.DeleteResponse{
-			ExitStatus: c.exit,
-			ExitedAt:   c.exitTime,
+			ExitStatus: exitStatus,
+			ExitedAt:   exitedAt,
 			Pid:        s.pid,
 		}, nil
 	}
@@ -471,11 +561,16 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *task
 		return nil, err
 	}
 
+	c.mu.Lock()
 	delete(c.execs, r.ExecID)
+	exitCode, exitedAt := execs.exitCode, execs.exitTime
+	c.mu.Unlock()
+
+	s.forgetExit(c.id, r.ExecID)
 
 	return &taskAPI.DeleteResponse{
-		ExitStatus: uint32(execs.exitCode),
-		ExitedAt:   execs.exitTime,
+		ExitStatus: uint32(exitCode),
+		ExitedAt:   exitedAt,
 		Pid:        s.pid,
 	}, nil
 }
@@ -494,7 +589,7 @@ func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *p
 		return nil, err
 	}
 
-	if execs := c.execs[r.ExecID]; execs != nil {
+	if _, err := c.getExec(r.ExecID); err == nil {
 		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "id %s", r.ExecID)
 	}
 
@@ -503,7 +598,9 @@ func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *p
 		return nil, errdefs.ToGRPC(err)
 	}
 
+	c.mu.Lock()
 	c.execs[r.ExecID] = execs
+	c.mu.Unlock()
 
 	s.send(&eventstypes.TaskExecAdded{
 		ContainerID: c.id,
@@ -531,13 +628,18 @@ func (s *service) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (_
 	if r.ExecID != "" {
 		execs, err := c.getExec(r.ExecID)
 		if err != nil {
-			return nil, err
+			return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "cannot resize the pty of exec %s of container %s: no such exec", r.ExecID, r.ID)
 		}
 		execs.tty.height = r.Height
 		execs.tty.width = r.Width
 
-		processID = execs.id
+		// The guest process doesn't exist until the exec is started,
+		// which then applies the recorded size.
+		if execs.id == "" {
+			return empty, nil
+		}
 
+		processID = execs.id
 	}
 	err = s.sandbox.WinsizeProcess(c.id, processID, r.Height, r.Width)
 	if err != nil {
@@ -562,6 +664,9 @@ func (s *service) State(ctx context.Context, r *taskAPI.StateRequest) (_ *taskAP
 	}
 
 	if r.ExecID == "" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
 		return &taskAPI.StateResponse{
 			ID:         c.id,
 			Bundle:     c.bundle,
@@ -581,6 +686,9 @@ func (s *service) State(ctx context.Context, r *taskAPI.StateRequest) (_ *taskAP
 		return nil, err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return &taskAPI.StateResponse{
 		ID:         execs.id,
 		Bundle:     c.bundle,
@@ -609,24 +717,37 @@ func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (_ *ptypes
 		return nil, err
 	}
 
-	c.status = task.StatusPausing
+	c.mu.Lock()
+	status := c.status
+	if status == task.StatusCreated || status == task.StatusRunning {
+		c.status = task.StatusPausing
+	}
+	c.mu.Unlock()
 
-	err = s.sandbox.PauseContainer(r.ID)
-	if err == nil {
-		c.status = task.StatusPaused
+	switch status {
+	case task.StatusPaused:
 		return empty, nil
+	case task.StatusCreated, task.StatusRunning:
+	default:
+		return nil, errors.Wrapf(ErrContainerNotRunning, "cannot pause container %s in state %s", c.id, status)
 	}
 
-	c.status, err = s.getContainerStatus(c.id)
-	if err != nil {
-		c.status = task.StatusUnknown
+	// The agent freezes the container processes through the freezer
+	// cgroup of the guest.
+	if err = s.sandbox.PauseContainer(c.id); err != nil {
+		s.refreshContainerStatus(c)
+		return nil, err
 	}
 
+	c.mu.Lock()
+	c.status = task.StatusPaused
+	c.mu.Unlock()
+
 	s.send(&eventstypes.TaskPaused{
 		ContainerID: c.id,
 	})
 
-	return empty, err
+	return empty, nil
 }
 
 // Resume the container
@@ -643,22 +764,42 @@ func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (_ *ptyp
 		return nil, err
 	}
 
-	err = s.sandbox.ResumeContainer(c.id)
-	if err == nil {
-		c.status = task.StatusRunning
+	c.mu.Lock()
+	status := c.status
+	c.mu.Unlock()
+
+	// Resuming a container which is not paused is a no-op.
+	if status != task.StatusPaused {
 		return empty, nil
 	}
 
-	c.status, err = s.getContainerStatus(c.id)
-	if err != nil {
-		c.status = task.StatusUnknown
+	if err = s.sandbox.ResumeContainer(c.id); err != nil {
+		s.refreshContainerStatus(c)
+		return nil, err
 	}
 
+	c.mu.Lock()
+	c.status = task.StatusRunning
+	c.mu.Unlock()
+
 	s.send(&eventstypes.TaskResumed{
 		ContainerID: c.id,
 	})
 
-	return empty, err
+	return empty, nil
+}
+
+// refreshContainerStatus resyncs the container status with the sandbox,
+// after a state transition failed midway.
+func (s *service) refreshContainerStatus(c *container) {
+	status, err := s.getContainerStatus(c.id)
+	if err != nil {
+		status = task.StatusUnknown
+	}
+
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
 }
 
 // Kill a process with the provided signal
@@ -671,6 +812,9 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.E
 	defer s.mu.Unlock()
 
 	signum := syscall.Signal(r.Signal)
+	if err := validSignal(signum); err != nil {
+		return nil, err
+	}
 
 	c, err := s.getContainer(r.ID)
 	if err != nil {
@@ -686,6 +830,12 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.E
 		processID = execs.id
 	}
 
+	// All broadcasts the signal to every process of the container,
+	// through its init process.
+	if r.All {
+		processID = c.id
+	}
+
 	return empty, s.sandbox.SignalProcess(c.id, processID, signum, r.All)
 }
 
@@ -747,7 +897,14 @@ func (s *service) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskReque
 		err = toGRPC(err)
 	}()
 
-	return nil, errdefs.ToGRPCf(errdefs.ErrNotImplemented, "service Checkpoint")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = checkpointContainer(s, r); err != nil {
+		return nil, err
+	}
+
+	return empty, nil
 }
 
 // Connect returns shim information such as the shim's pid
@@ -819,6 +976,11 @@ func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (_ *
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	c, err := s.getContainer(r.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	var resources *specs.LinuxResources
 	v, err := typeurl.UnmarshalAny(r.Resources)
 	if err != nil {
@@ -829,7 +991,13 @@ func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (_ *
 		return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "Invalid resources type for %s", s.id)
 	}
 
-	err = s.sandbox.UpdateContainer(r.ID, *resources)
+	if err = validateResources(s, c.id, resources); err != nil {
+		return nil, err
+	}
+
+	// The sandbox forwards the new cgroup limits to the agent, and
+	// hotplugs the vCPUs and memory needed by the new constraints.
+	err = s.sandbox.UpdateContainer(c.id, *resources)
 	if err != nil {
 		return nil, errdefs.ToGRPC(err)
 	}
@@ -850,40 +1018,103 @@ func (s *service) Wait(ctx context.Context, r *taskAPI.WaitRequest) (_ *taskAPI.
 	s.mu.Unlock()
 
 	if err != nil {
+		// The process may have been reaped by a previous shim.
+		if e, ok := s.rememberedExit(r.ID, r.ExecID); ok {
+			return &taskAPI.WaitResponse{
+				ExitStatus: e.Status,
+				ExitedAt:   e.ExitedAt,
+			}, nil
+		}
 		return nil, err
 	}
 
 	//wait for container
 	if r.ExecID == "" {
-		ret = <-c.exitCh
-
-		// refill the exitCh with the container process's exit code in case
-		// there were other waits on this process.
-		c.exitCh <- ret
-	} else { //wait for exec
-		execs, err := c.getExec(r.ExecID)
+		exited, err := s.WaitContainer(ctx, r.ID)
 		if err != nil {
 			return nil, err
 		}
-		ret = <-execs.exitCh
 
-		// refill the exitCh with the exec process's exit code in case
-		// there were other waits on this process.
-		execs.exitCh <- ret
+		e, ok := <-exited
+		if !ok {
+			return nil, ctx.Err()
+		}
+
+		return &taskAPI.WaitResponse{
+			ExitStatus: uint32(e.status),
+			ExitedAt:   e.timestamp,
+		}, nil
+	}
+
+	//wait for exec
+	execs, err := c.getExec(r.ExecID)
+	if err != nil {
+		return nil, err
 	}
+	ret = <-execs.exitCh
+
+	// refill the exitCh with the exec process's exit code in case
+	// there were other waits on this process.
+	execs.exitCh <- ret
+
+	c.mu.Lock()
+	exitedAt := c.exitTime
+	c.mu.Unlock()
 
 	return &taskAPI.WaitResponse{
 		ExitStatus: ret,
-		ExitedAt:   c.exitTime,
+		ExitedAt:   exitedAt,
 	}, nil
 }
 
 func (s *service) processExits() {
+	for _, e := range s.attachExitConsumer() {
+		s.checkProcesses(e)
+	}
+
 	for e := range s.ec {
 		s.checkProcesses(e)
 	}
 }
 
+// bufferExit keeps the exit aside if no consumer is attached yet to the
+// exit channel. It returns false if the exit has to be sent to ec.
+func (s *service) bufferExit(e exit) bool {
+	s.exitsMu.Lock()
+	defer s.exitsMu.Unlock()
+
+	if !s.bufferExits || s.exitsAttached {
+		return false
+	}
+
+	if len(s.bufferedExits) >= maxBufferedExits {
+		s.droppedExits++
+		logrus.WithFields(logrus.Fields{
+			"container": e.id,
+			"exec":      e.execid,
+			"dropped":   s.droppedExits,
+		}).Error("exit buffer is full, dropping process exit")
+		return true
+	}
+
+	s.bufferedExits = append(s.bufferedExits, e)
+
+	return true
+}
+
+// attachExitConsumer marks the exit channel as consumed and returns the
+// exits buffered so far, which must be handled before reading ec.
+func (s *service) attachExitConsumer() []exit {
+	s.exitsMu.Lock()
+	defer s.exitsMu.Unlock()
+
+	s.exitsAttached = true
+	exits := s.bufferedExits
+	s.bufferedExits = nil
+
+	return exits
+}
+
 func (s *service) checkProcesses(e exit) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -902,11 +1133,20 @@ func (s *service) checkProcesses(e exit) {
 	})
 }
 
+// getContext returns the context of the service, cancelled on shutdown.
+func (s *service) getContext() context.Context {
+	if s == nil || s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
 func (s *service) getContainer(id string) (*container, error) {
 	c := s.containers[id]
 
 	if c == nil {
-		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container does not exist %s", id)
+		return nil, &codedError{code: codes.NotFound, err: errors.Wrap(ErrContainerNotFound, id)}
 	}
 
 	return c, nil
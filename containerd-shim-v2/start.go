@@ -8,20 +8,81 @@ package containerdshim
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/kata-containers/runtime/pkg/katautils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
 )
 
+const (
+	// the size of an exec terminal started without any explicit size.
+	defaultTerminalHeight = 24
+	defaultTerminalWidth  = 80
+)
+
+// terminalSize returns the default exec terminal size of the service.
+func (s *service) terminalSize() (height, width uint32) {
+	height, width = s.terminalHeight, s.terminalWidth
+	if height == 0 {
+		height = defaultTerminalHeight
+	}
+	if width == 0 {
+		width = defaultTerminalWidth
+	}
+
+	return height, width
+}
+
+// containerTerminalSize returns the initial terminal size of the container
+// init process, the console size of its spec completed with the service
+// defaults.
+func containerTerminalSize(s *service, c *container) (height, width uint32) {
+	defaultHeight, defaultWidth := s.terminalSize()
+	height, width = defaultHeight, defaultWidth
+
+	if c.spec != nil && c.spec.Process != nil && c.spec.Process.ConsoleSize != nil {
+		if h := c.spec.Process.ConsoleSize.Height; h != 0 {
+			height = uint32(h)
+		}
+		if w := c.spec.Process.ConsoleSize.Width; w != 0 {
+			width = uint32(w)
+		}
+	}
+
+	return height, width
+}
+
+// updateBlkio sets the block IO limits of the container annotations. The
+// agent isn't given the block IO limits of the spec, which refer to the host
+// devices, these ones are set by an update instead.
+func updateBlkio(s *service, c *container) error {
+	if c.blkio == nil {
+		return nil
+	}
+
+	return s.sandbox.UpdateContainer(c.id, specs.LinuxResources{BlockIO: c.blkio})
+}
+
 func startContainer(ctx context.Context, s *service, c *container) error {
 	//start a container
 	if c.cType == "" {
-		err := fmt.Errorf("Bug, the container %s type is empty", c.id)
-		return err
+		return errors.Wrapf(ErrContainerTypeEmpty, "cannot start container %s", c.id)
 	}
 
 	if s.sandbox == nil {
-		err := fmt.Errorf("Bug, the sandbox hasn't been created for this container %s", c.id)
+		return errors.Wrapf(ErrSandboxNotCreated, "cannot start container %s", c.id)
+	}
+
+	if err := validProcess(c); err != nil {
 		return err
 	}
 
@@ -30,7 +91,17 @@ func startContainer(ctx context.Context, s *service, c *container) error {
 		if err != nil {
 			return err
 		}
+
+		// The sandbox container can only be updated once the sandbox
+		// is running.
+		if err := updateBlkio(s, c); err != nil {
+			return err
+		}
 	} else {
+		if err := updateBlkio(s, c); err != nil {
+			return err
+		}
+
 		_, err := s.sandbox.StartContainer(c.id)
 		if err != nil {
 			return err
@@ -45,26 +116,66 @@ func startContainer(ctx context.Context, s *service, c *container) error {
 		return err
 	}
 
-	c.status = task.StatusRunning
+	if c.terminal {
+		height, width := containerTerminalSize(s, c)
+		// The container is already running, the client resizing the
+		// terminal later on fixes a failure.
+		if err := s.sandbox.WinsizeProcess(c.id, c.id, height, width); err != nil {
+			logrus.WithError(err).WithField("container", c.id).Warn("failed to set the initial terminal size")
+		}
+	}
 
 	stdin, stdout, stderr, err := s.sandbox.IOStream(c.id, c.id)
 	if err != nil {
+		// Don't leave the container process running without any IO
+		// wired up, it is reported exited like a lost process.
+		if err2 := s.sandbox.KillContainer(c.id, syscall.SIGKILL, true); err2 != nil {
+			logrus.WithError(err2).WithField("container", c.id).Warn("failed to kill container after IO stream failure")
+		}
+		processExited(s, c, nil, "", exitCode255)
 		return err
 	}
 
+	c.mu.Lock()
+	c.status = task.StatusRunning
+	c.startedAt = s.getClock().Now()
+	c.mu.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"container":       c.id,
+		"time-to-running": c.timeToRunning(),
+	}).Info("container running")
+
+	return attachContainerIO(ctx, s, c, stdin, stdout, stderr)
+}
+
+// attachContainerIO copies the IO streams of the container init process
+// and waits for it to exit.
+func attachContainerIO(ctx context.Context, s *service, c *container, stdin io.WriteCloser, stdout, stderr io.Reader) error {
 	if c.stdin != "" || c.stdout != "" || c.stderr != "" {
 		tty, err := newTtyIO(ctx, c.stdin, c.stdout, c.stderr, c.terminal)
 		if err != nil {
 			return err
 		}
+		if s.logRateLimit > 0 {
+			limiter := newLogRateLimiter(s.logRateLimit, s.getClock())
+			tty.Stdout = limiter.writer(tty.Stdout)
+			tty.Stderr = limiter.writer(tty.Stderr)
+		}
 		c.ttyio = tty
-		go ioCopy(c.exitIOch, tty, stdin, stdout, stderr)
+		if c.ioCtx == nil {
+			c.ioCtx, c.ioCancel = context.WithCancel(s.getContext())
+		}
+		go ioCopy(c.ioCtx, c.exitIOch, tty, stdin, stdout, stderr, openOutputLog(s, c), s.ioBufferSize)
 	} else {
 		//close the io exit channel, since there is no io for this container,
 		//otherwise the following wait goroutine will hang on this channel.
 		close(c.exitIOch)
 	}
 
+	c.mu.Lock()
+	c.attached = true
+	c.mu.Unlock()
+
 	go wait(s, c, "")
 
 	return nil
@@ -82,16 +193,45 @@ func startExec(ctx context.Context, s *service, containerID, execID string) (*ex
 		return nil, err
 	}
 
+	// A terminal exec is allowed whatever the terminal setting of the
+	// container init process, but it is pointless without any input.
+	if execs.tty.terminal && execs.tty.stdin == "" {
+		return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "terminal exec %s of container %s requires stdin", execID, containerID)
+	}
+
+	if s.maxExecs > 0 && c.runningExecs() >= s.maxExecs {
+		return nil, &codedError{
+			code: codes.ResourceExhausted,
+			err:  fmt.Errorf("too many exec sessions in container %s, at most %d can run at once", containerID, s.maxExecs),
+		}
+	}
+
 	_, proc, err := s.sandbox.EnterContainer(containerID, *execs.cmds)
 	if err != nil {
-		err := fmt.Errorf("cannot enter container %s, with err %s", containerID, err)
+		err := errors.Wrapf(err, "cannot enter container %s", containerID)
+		if code, ok := agentErrorCode(err); ok {
+			return nil, &codedError{code: code, err: err}
+		}
 		return nil, err
 	}
+	c.mu.Lock()
 	execs.id = proc.Token
-
 	execs.status = task.StatusRunning
-	if execs.tty.height != 0 && execs.tty.width != 0 {
-		err = s.sandbox.WinsizeProcess(c.id, execs.id, execs.tty.height, execs.tty.width)
+	c.mu.Unlock()
+
+	height, width := execs.tty.height, execs.tty.width
+	if execs.tty.terminal {
+		// Give a sensible size to the terminal until the first resize.
+		defaultHeight, defaultWidth := s.terminalSize()
+		if height == 0 {
+			height = defaultHeight
+		}
+		if width == 0 {
+			width = defaultWidth
+		}
+	}
+	if height != 0 && width != 0 {
+		err = s.sandbox.WinsizeProcess(c.id, execs.id, height, width)
 		if err != nil {
 			return nil, err
 		}
@@ -101,15 +241,92 @@ func startExec(ctx context.Context, s *service, containerID, execID string) (*ex
 	if err != nil {
 		return nil, err
 	}
+
+	if err := attachExecIO(ctx, s, c, execID, execs, stdin, stdout, stderr); err != nil {
+		return nil, err
+	}
+
+	return execs, nil
+}
+
+// attachExecIO copies the IO streams of the exec process and waits for it
+// to exit.
+func attachExecIO(ctx context.Context, s *service, c *container, execID string, execs *exec, stdin io.WriteCloser, stdout, stderr io.Reader) error {
 	tty, err := newTtyIO(ctx, execs.tty.stdin, execs.tty.stdout, execs.tty.stderr, execs.tty.terminal)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	execs.ttyio = tty
 
-	go ioCopy(execs.exitIOch, tty, stdin, stdout, stderr)
+	go ioCopy(c.ioContext(), execs.exitIOch, tty, stdin, stdout, stderr, nil, s.ioBufferSize)
+
+	c.mu.Lock()
+	execs.attached = true
+	c.mu.Unlock()
 
 	go wait(s, c, execID)
 
-	return execs, nil
+	return nil
+}
+
+// validProcess checks the OCI process of the container has a command to
+// run, an absolute working directory and KEY=VALUE environment variables,
+// which the agent would otherwise only report once in the guest.
+func validProcess(c *container) error {
+	if c.spec.Process == nil {
+		return nil
+	}
+
+	if len(c.spec.Process.Args) == 0 {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "Cannot start container %s: the OCI process args are empty", c.id)
+	}
+
+	if cwd := c.spec.Process.Cwd; cwd != "" && !filepath.IsAbs(cwd) {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "Cannot start container %s: the OCI process cwd %q is not an absolute path", c.id, cwd)
+	}
+
+	for _, env := range c.spec.Process.Env {
+		if strings.Index(env, "=") <= 0 {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "Cannot start container %s: the OCI process env entry %q is not formatted as KEY=VALUE", c.id, env)
+		}
+	}
+
+	return nil
+}
+
+// containerOutputLog returns the path of the file the output of the
+// container init process is copied to, in the service outputLogDir. It
+// returns an empty path if the output isn't copied.
+func containerOutputLog(s *service, c *container) string {
+	if s.outputLogDir == "" {
+		return ""
+	}
+
+	return filepath.Join(s.outputLogDir, c.id+"-output.log")
+}
+
+// openOutputLog opens the file the output of the container init process
+// is copied to, or returns nil if the output isn't copied. The container
+// is started even if the file cannot be opened.
+func openOutputLog(s *service, c *container) io.WriteCloser {
+	path := containerOutputLog(s, c)
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logrus.WithError(err).WithField("container", c.id).Warn("failed to open the output log")
+		return nil
+	}
+
+	// A restarted container appends to the file of its previous run.
+	info, err := f.Stat()
+	if err != nil {
+		logrus.WithError(err).WithField("container", c.id).Warn("failed to open the output log")
+		f.Close()
+		return nil
+	}
+
+	return newOutputLog(f, outputLogDepth, info.Size(), outputLogMaxSize)
 }
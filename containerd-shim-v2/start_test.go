@@ -8,14 +8,24 @@ package containerdshim
 
 import (
 	"context"
+	"io"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 
 	vc "github.com/kata-containers/runtime/virtcontainers"
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -175,3 +185,591 @@ func TestStartStartContainerSucess(t *testing.T) {
 	_, err = s.Start(ctx, reqStart)
 	assert.NoError(err)
 }
+
+// enterErrorSandbox fails every EnterContainer() with the configured error.
+type enterErrorSandbox struct {
+	vcmock.Sandbox
+	err     error
+	entered bool
+}
+
+func (s *enterErrorSandbox) EnterContainer(containerID string, cmd types.Cmd) (vc.VCContainer, *vc.Process, error) {
+	s.entered = true
+	return nil, nil, s.err
+}
+
+func TestStartExecAgentErrorCode(t *testing.T) {
+	assert := assert.New(t)
+
+	agentErr := status.Error(codes.NotFound, "executable file not found in $PATH")
+
+	data := []struct {
+		err      error
+		hasCode  bool
+		expected codes.Code
+	}{
+		{syscall.ENOENT, true, codes.NotFound},
+		{errors.Wrap(syscall.EACCES, "exec failed"), true, codes.PermissionDenied},
+		{errors.WithMessage(syscall.EINVAL, "exec failed"), true, codes.InvalidArgument},
+		{agentErr, true, codes.NotFound},
+		{errors.Wrap(agentErr, "agent request failed"), true, codes.NotFound},
+		{syscall.EIO, true, codes.Unknown},
+		{errors.New("no code"), false, codes.Unknown},
+	}
+
+	for i, d := range data {
+		sandbox := &enterErrorSandbox{
+			Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+			err:     d.err,
+		}
+
+		s := &service{
+			id:         testSandboxID,
+			sandbox:    sandbox,
+			containers: make(map[string]*container),
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+		assert.NoError(err)
+		c.execs[TestID] = &exec{cmds: &types.Cmd{}, tty: &tty{}}
+		s.containers[testContainerID] = c
+
+		_, err = startExec(context.Background(), s, testContainerID, TestID)
+		assert.Error(err, "test %d", i)
+
+		// the agent error must be preserved
+		assert.True(errors.Cause(err) == errors.Cause(d.err), "test %d", i)
+
+		st, ok := status.FromError(err)
+		assert.Equal(d.hasCode, ok, "test %d", i)
+		if d.hasCode {
+			assert.Equal(d.expected, st.Code(), "test %d", i)
+			assert.Contains(st.Message(), testContainerID, "test %d", i)
+		}
+	}
+}
+
+func TestStartExecTerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	enterErr := errors.New("enter failed")
+
+	data := []struct {
+		terminal bool
+		stdin    string
+		entered  bool
+	}{
+		{true, "/tmp/stdin", true},
+		{true, "", false},
+		{false, "/tmp/stdin", true},
+		{false, "", true},
+	}
+
+	for i, d := range data {
+		sandbox := &enterErrorSandbox{
+			Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+			err:     enterErr,
+		}
+
+		s := &service{
+			id:         testSandboxID,
+			sandbox:    sandbox,
+			containers: make(map[string]*container),
+		}
+
+		// the container init has no terminal, which must not prevent
+		// terminal execs.
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+		assert.NoError(err)
+		c.execs[TestID] = &exec{
+			cmds: &types.Cmd{},
+			tty: &tty{
+				stdin:    d.stdin,
+				terminal: d.terminal,
+			},
+		}
+		s.containers[testContainerID] = c
+
+		_, err = startExec(context.Background(), s, testContainerID, TestID)
+		assert.Error(err, "test %d", i)
+		assert.Equal(d.entered, sandbox.entered, "test %d", i)
+
+		if d.entered {
+			assert.True(errors.Cause(err) == enterErr, "test %d", i)
+		} else {
+			assert.Equal(codes.InvalidArgument, status.Code(err), "test %d", i)
+		}
+	}
+}
+
+func TestStartExecLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	enterErr := errors.New("enter failed")
+
+	sandbox := &enterErrorSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		err:     enterErr,
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		maxExecs:   2,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	c.execs["running1"] = &exec{cmds: &types.Cmd{}, tty: &tty{}, status: task.StatusRunning}
+	c.execs["exited"] = &exec{cmds: &types.Cmd{}, tty: &tty{}, status: task.StatusStopped}
+	c.execs[TestID] = &exec{cmds: &types.Cmd{}, tty: &tty{}, status: task.StatusCreated}
+	s.containers[testContainerID] = c
+
+	// the exited exec doesn't count
+	_, err = startExec(context.Background(), s, testContainerID, TestID)
+	assert.True(errors.Cause(err) == enterErr)
+	assert.True(sandbox.entered)
+
+	c.execs["running2"] = &exec{cmds: &types.Cmd{}, tty: &tty{}, status: task.StatusRunning}
+	sandbox.entered = false
+
+	_, err = startExec(context.Background(), s, testContainerID, TestID)
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	assert.Contains(err.Error(), "too many exec sessions")
+	assert.False(sandbox.entered)
+
+	// no limit by default
+	s.maxExecs = 0
+	_, err = startExec(context.Background(), s, testContainerID, TestID)
+	assert.True(errors.Cause(err) == enterErr)
+	assert.True(sandbox.entered)
+}
+
+func TestStartContainerTimeToRunning(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &vcmock.Sandbox{
+		MockID: testSandboxID,
+	}
+
+	sandbox.MockContainers = []*vcmock.Container{
+		{
+			MockID:      testContainerID,
+			MockSandbox: sandbox,
+		},
+	}
+
+	testingImpl.StartContainerFunc = func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error) {
+		return sandbox.MockContainers[0], nil
+	}
+
+	defer func() {
+		testingImpl.StartContainerFunc = nil
+	}()
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodContainer, nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	assert.Zero(c.timeToRunning())
+
+	time.Sleep(time.Millisecond)
+
+	ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+	err = startContainer(ctx, s, c)
+	assert.NoError(err)
+
+	assert.True(c.timeToRunning() > 0)
+}
+
+// ioStreamErrorSandbox fails every IOStream() call.
+type ioStreamErrorSandbox struct {
+	vcmock.Sandbox
+	killed  []string
+	stopped []string
+}
+
+func (s *ioStreamErrorSandbox) IOStream(containerID, processID string) (io.WriteCloser, io.Reader, io.Reader, error) {
+	return nil, nil, nil, errors.New("no IO stream")
+}
+
+func (s *ioStreamErrorSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	s.killed = append(s.killed, contID)
+	return nil
+}
+
+func (s *ioStreamErrorSandbox) StopContainer(contID string) (vc.VCContainer, error) {
+	s.stopped = append(s.stopped, contID)
+	return &vcmock.Container{}, nil
+}
+
+func TestStartContainerIOStreamFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, cType := range []vc.ContainerType{vc.PodContainer, vc.PodSandbox} {
+		sandbox := &ioStreamErrorSandbox{
+			Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		}
+
+		s := &service{
+			id:         testSandboxID,
+			sandbox:    sandbox,
+			containers: make(map[string]*container),
+			ec:         make(chan exit, 1),
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, cType, nil)
+		assert.NoError(err)
+		s.containers[testContainerID] = c
+
+		waiter, err := s.WaitContainer(context.Background(), testContainerID)
+		assert.NoError(err)
+
+		ctx := namespaces.WithNamespace(context.Background(), "UnitTest")
+		err = startContainer(ctx, s, c)
+		assert.Error(err, "container type %s", cType)
+
+		// the container was never reported running
+		assert.Zero(c.timeToRunning(), "container type %s", cType)
+		assert.Equal(task.StatusStopped, c.status, "container type %s", cType)
+
+		// its process is killed, without stopping the container, and
+		// it goes through the normal exit path
+		assert.Equal([]string{testContainerID}, sandbox.killed, "container type %s", cType)
+		assert.Empty(sandbox.stopped, "container type %s", cType)
+		assert.Equal(uint32(exitCode255), c.exit, "container type %s", cType)
+
+		e, ok := <-waiter
+		assert.True(ok, "container type %s", cType)
+		assert.Equal(exitCode255, e.status, "container type %s", cType)
+
+		select {
+		case e := <-s.ec:
+			assert.Equal(testContainerID, e.id, "container type %s", cType)
+			assert.Equal(exitCode255, e.status, "container type %s", cType)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("container type %s: the exit wasn't reaped", cType)
+		}
+	}
+}
+
+// winsizeSandbox records the exec terminal size and fails IOStream() to stop
+// startExec right after.
+type winsizeSandbox struct {
+	ioStreamErrorSandbox
+	sizes     [][2]uint32
+	processes []string
+}
+
+func (s *winsizeSandbox) EnterContainer(containerID string, cmd types.Cmd) (vc.VCContainer, *vc.Process, error) {
+	return &vcmock.Container{}, &vc.Process{Token: "exec-token"}, nil
+}
+
+func (s *winsizeSandbox) WinsizeProcess(containerID, processID string, height, width uint32) error {
+	s.sizes = append(s.sizes, [2]uint32{height, width})
+	s.processes = append(s.processes, processID)
+	return nil
+}
+
+func TestStartExecTerminalSize(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		terminal        bool
+		height, width   uint32
+		defaultHeight   uint32
+		defaultWidth    uint32
+		expectedWinsize [][2]uint32
+	}{
+		// zero size terminal gets the default size
+		{true, 0, 0, 0, 0, [][2]uint32{{defaultTerminalHeight, defaultTerminalWidth}}},
+		{true, 40, 0, 0, 0, [][2]uint32{{40, defaultTerminalWidth}}},
+		// explicit size
+		{true, 40, 120, 0, 0, [][2]uint32{{40, 120}}},
+		// default overridden by the service
+		{true, 0, 0, 50, 200, [][2]uint32{{50, 200}}},
+		// no terminal
+		{false, 0, 0, 0, 0, nil},
+		{false, 40, 120, 0, 0, [][2]uint32{{40, 120}}},
+	}
+
+	for i, d := range data {
+		sandbox := &winsizeSandbox{
+			ioStreamErrorSandbox: ioStreamErrorSandbox{
+				Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+			},
+		}
+
+		s := &service{
+			id:             testSandboxID,
+			sandbox:        sandbox,
+			containers:     make(map[string]*container),
+			terminalHeight: d.defaultHeight,
+			terminalWidth:  d.defaultWidth,
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+		assert.NoError(err)
+		c.execs[TestID] = &exec{
+			cmds: &types.Cmd{},
+			tty: &tty{
+				stdin:    "/tmp/stdin",
+				height:   d.height,
+				width:    d.width,
+				terminal: d.terminal,
+			},
+		}
+		s.containers[testContainerID] = c
+
+		_, err = startExec(context.Background(), s, testContainerID, TestID)
+		assert.Error(err, "test %d", i)
+		assert.Equal(d.expectedWinsize, sandbox.sizes, "test %d", i)
+	}
+}
+
+func TestStartContainerTerminalSize(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		terminal        bool
+		consoleSize     *specs.Box
+		defaultHeight   uint32
+		defaultWidth    uint32
+		expectedWinsize [][2]uint32
+	}{
+		{true, nil, 0, 0, [][2]uint32{{defaultTerminalHeight, defaultTerminalWidth}}},
+		{true, &specs.Box{Height: 40, Width: 120}, 0, 0, [][2]uint32{{40, 120}}},
+		{true, &specs.Box{Height: 40}, 0, 0, [][2]uint32{{40, defaultTerminalWidth}}},
+		{true, nil, 50, 200, [][2]uint32{{50, 200}}},
+		// no terminal, no size
+		{false, &specs.Box{Height: 40, Width: 120}, 0, 0, nil},
+	}
+
+	for i, d := range data {
+		sandbox := &winsizeSandbox{
+			ioStreamErrorSandbox: ioStreamErrorSandbox{
+				Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+			},
+		}
+
+		s := &service{
+			id:             testSandboxID,
+			sandbox:        sandbox,
+			containers:     make(map[string]*container),
+			terminalHeight: d.defaultHeight,
+			terminalWidth:  d.defaultWidth,
+		}
+
+		spec := &oci.CompatOCISpec{
+			Process: &oci.CompatOCIProcess{},
+		}
+		spec.Process.Args = []string{"sh"}
+		spec.Process.ConsoleSize = d.consoleSize
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID, Terminal: d.terminal}, vc.PodContainer, spec)
+		assert.NoError(err, "test %d", i)
+		s.containers[testContainerID] = c
+
+		// the IO stream failure stops the start right after
+		err = startContainer(context.Background(), s, c)
+		assert.Error(err, "test %d", i)
+		assert.Equal(d.expectedWinsize, sandbox.sizes, "test %d", i)
+		if d.terminal {
+			assert.Equal([]string{testContainerID}, sandbox.processes, "test %d", i)
+		}
+	}
+}
+
+// startCountSandbox counts the sandbox and container starts.
+type startCountSandbox struct {
+	vcmock.Sandbox
+	starts int
+}
+
+func (s *startCountSandbox) Start() error {
+	s.starts++
+	return nil
+}
+
+func (s *startCountSandbox) StartContainer(contID string) (vc.VCContainer, error) {
+	s.starts++
+	return &vcmock.Container{}, nil
+}
+
+// blkioSandbox records the starts and updates, and fails the updates
+// until the sandbox is started like a sandbox which isn't running.
+type blkioSandbox struct {
+	vcmock.Sandbox
+	started bool
+	calls   []string
+	updates []specs.LinuxResources
+}
+
+func (s *blkioSandbox) Start() error {
+	s.started = true
+	s.calls = append(s.calls, "Start")
+	return nil
+}
+
+func (s *blkioSandbox) StartContainer(contID string) (vc.VCContainer, error) {
+	s.calls = append(s.calls, "StartContainer")
+	return &vcmock.Container{}, nil
+}
+
+func (s *blkioSandbox) UpdateContainer(contID string, resources specs.LinuxResources) error {
+	if !s.started {
+		return errors.New("sandbox not running")
+	}
+	s.calls = append(s.calls, "UpdateContainer")
+	s.updates = append(s.updates, resources)
+	return nil
+}
+
+func TestStartContainerBlkio(t *testing.T) {
+	assert := assert.New(t)
+
+	weight := uint16(300)
+	blkio := &specs.LinuxBlockIO{Weight: &weight}
+
+	data := []struct {
+		cType vc.ContainerType
+		calls []string
+	}{
+		// the sandbox container is updated once the sandbox runs
+		{vc.PodSandbox, []string{"Start", "UpdateContainer"}},
+		// a container is updated before its process starts
+		{vc.PodContainer, []string{"UpdateContainer", "StartContainer"}},
+	}
+
+	for _, d := range data {
+		sandbox := &blkioSandbox{
+			Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+			started: d.cType == vc.PodContainer,
+		}
+		s := &service{
+			id:         testSandboxID,
+			sandbox:    sandbox,
+			containers: make(map[string]*container),
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, d.cType, nil)
+		assert.NoError(err)
+		c.blkio = blkio
+		s.containers[testContainerID] = c
+
+		err = startContainer(context.Background(), s, c)
+		assert.NoError(err, "%s", d.cType)
+		assert.Equal(d.calls, sandbox.calls, "%s", d.cType)
+		if assert.Len(sandbox.updates, 1, "%s", d.cType) {
+			assert.Equal(blkio, sandbox.updates[0].BlockIO)
+		}
+		assert.Equal(task.StatusRunning, c.status)
+	}
+
+	// no update without limits
+	sandbox := &blkioSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodSandbox, nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	assert.NoError(startContainer(context.Background(), s, c))
+	assert.Equal([]string{"Start"}, sandbox.calls)
+}
+
+func TestStartContainerEmptyArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &startCountSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	for _, cType := range []vc.ContainerType{vc.PodSandbox, vc.PodContainer} {
+		spec := &oci.CompatOCISpec{
+			Process: &oci.CompatOCIProcess{},
+		}
+		spec.Process.Args = []string{}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, cType, spec)
+		assert.NoError(err)
+		s.containers[testContainerID] = c
+
+		err = startContainer(context.Background(), s, c)
+		assert.Equal(codes.InvalidArgument, status.Code(err))
+		assert.Contains(err.Error(), testContainerID)
+		assert.Contains(err.Error(), "args are empty")
+		assert.Equal(task.StatusCreated, c.status)
+	}
+
+	assert.Equal(0, sandbox.starts)
+}
+
+func TestStartContainerInvalidProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &startCountSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}}
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	data := []struct {
+		cwd      string
+		env      []string
+		expected string
+	}{
+		{"relative/dir", nil, `cwd "relative/dir" is not an absolute path`},
+		{"./", nil, `cwd "./" is not an absolute path`},
+		{"/", []string{"PATH=/bin", "FOO"}, `env entry "FOO" is not formatted as KEY=VALUE`},
+		{"/", []string{"=bar"}, `env entry "=bar" is not formatted as KEY=VALUE`},
+		{"/", []string{""}, `env entry "" is not formatted as KEY=VALUE`},
+	}
+
+	for i, d := range data {
+		spec := &oci.CompatOCISpec{
+			Process: &oci.CompatOCIProcess{},
+		}
+		spec.Process.Args = []string{"sh"}
+		spec.Process.Cwd = d.cwd
+		spec.Process.Env = d.env
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodContainer, spec)
+		assert.NoError(err)
+		s.containers[testContainerID] = c
+
+		err = startContainer(context.Background(), s, c)
+		assert.Equal(codes.InvalidArgument, status.Code(err), "test %d", i)
+		assert.Contains(err.Error(), testContainerID, "test %d", i)
+		assert.Contains(err.Error(), d.expected, "test %d", i)
+		assert.Equal(task.StatusCreated, c.status, "test %d", i)
+	}
+
+	assert.Equal(0, sandbox.starts)
+
+	// a valid process goes through
+	spec := &oci.CompatOCISpec{
+		Process: &oci.CompatOCIProcess{},
+	}
+	spec.Process.Args = []string{"sh"}
+	spec.Process.Cwd = "/home/user"
+	spec.Process.Env = []string{"PATH=/bin", "EMPTY=", "OPTS=a=b"}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, vc.PodContainer, spec)
+	assert.NoError(err)
+	assert.NoError(validProcess(c))
+}
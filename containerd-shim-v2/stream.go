@@ -6,25 +6,55 @@
 package containerdshim
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/containerd/fifo"
+	"github.com/sirupsen/logrus"
 )
 
 // The buffer size used to specify the buffer for IO streams copy
 const bufSize = 32 << 10
 
 var (
-	bufPool = sync.Pool{
+	bufPool = newBufferPool(bufSize)
+
+	// bufPools holds the pools of the non default buffer sizes.
+	bufPoolsMu sync.Mutex
+	bufPools   = map[int]*sync.Pool{}
+)
+
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
 		New: func() interface{} {
-			buffer := make([]byte, bufSize)
+			buffer := make([]byte, size)
 			return &buffer
 		},
 	}
-)
+}
+
+// bufferPool returns the pool of the IO copy buffers of the given size,
+// a size not greater than 0 selecting the default bufSize.
+func bufferPool(size int) *sync.Pool {
+	if size <= 0 || size == bufSize {
+		return bufPool
+	}
+
+	bufPoolsMu.Lock()
+	defer bufPoolsMu.Unlock()
+
+	pool, ok := bufPools[size]
+	if !ok {
+		pool = newBufferPool(size)
+		bufPools[size] = pool
+	}
+
+	return pool
+}
 
 type ttyIO struct {
 	Stdin  io.ReadCloser
@@ -32,6 +62,12 @@ type ttyIO struct {
 	Stderr io.Writer
 }
 
+// sharedOutput reports whether the process stderr is redirected to its
+// stdout fifo, e.g. for 2>&1.
+func sharedOutput(stdout, stderr string, console bool) bool {
+	return !console && stdout != "" && stderr == stdout
+}
+
 func (tty *ttyIO) close() {
 
 	if tty.Stdin != nil {
@@ -46,82 +82,407 @@ func (tty *ttyIO) close() {
 		}
 	}
 	cf(tty.Stdout)
-	cf(tty.Stderr)
+	if tty.Stderr != tty.Stdout {
+		cf(tty.Stderr)
+	}
 }
 
-func newTtyIO(ctx context.Context, stdin, stdout, stderr string, console bool) (*ttyIO, error) {
-	var in io.ReadCloser
-	var outw io.Writer
-	var errw io.Writer
-	var err error
+// openFifos opens the host ends of the process IO fifos, closing the ones
+// already opened on failure. A stderr shared with stdout is only opened
+// once, as stdout.
+func openFifos(ctx context.Context, stdin, stdout, stderr string, console bool) (in io.ReadCloser, outw, errw io.WriteCloser, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, c := range []io.Closer{in, outw, errw} {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}()
 
 	if stdin != "" {
-		in, err = fifo.OpenFifo(ctx, stdin, syscall.O_RDONLY, 0)
-		if err != nil {
-			return nil, err
+		if in, err = fifo.OpenFifo(ctx, stdin, syscall.O_RDONLY, 0); err != nil {
+			return nil, nil, nil, err
 		}
 	}
 
 	if stdout != "" {
-		outw, err = fifo.OpenFifo(ctx, stdout, syscall.O_WRONLY, 0)
-		if err != nil {
-			return nil, err
+		if outw, err = fifo.OpenFifo(ctx, stdout, syscall.O_WRONLY, 0); err != nil {
+			return in, nil, nil, err
 		}
 	}
 
-	if !console && stderr != "" {
-		errw, err = fifo.OpenFifo(ctx, stderr, syscall.O_WRONLY, 0)
-		if err != nil {
-			return nil, err
+	if !console && stderr != "" && !sharedOutput(stdout, stderr, console) {
+		if errw, err = fifo.OpenFifo(ctx, stderr, syscall.O_WRONLY, 0); err != nil {
+			return in, outw, nil, err
 		}
 	}
 
-	ttyIO := &ttyIO{
-		Stdin:  in,
-		Stdout: outw,
-		Stderr: errw,
+	return in, outw, errw, nil
+}
+
+func newTtyIO(ctx context.Context, stdin, stdout, stderr string, console bool) (*ttyIO, error) {
+	in, outw, errw, err := openFifos(ctx, stdin, stdout, stderr, console)
+	if err != nil {
+		return nil, err
+	}
+
+	ttyIO := &ttyIO{}
+	if in != nil {
+		ttyIO.Stdin = in
+	}
+	if outw != nil {
+		ttyIO.Stdout = outw
+	}
+	if errw != nil {
+		ttyIO.Stderr = errw
+	}
+	if sharedOutput(stdout, stderr, console) {
+		w := &sharedWriter{w: outw}
+		ttyIO.Stdout = w
+		ttyIO.Stderr = w
 	}
 
 	return ttyIO, nil
 }
 
-func ioCopy(exitch chan struct{}, tty *ttyIO, stdinPipe io.WriteCloser, stdoutPipe, stderrPipe io.Reader) {
+// sharedWriter is the fifo written by both the stdout and stderr copies
+// when stderr is redirected to stdout. Their writes are serialized, not to
+// interleave their partial writes.
+type sharedWriter struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func (sw *sharedWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.w.Write(p)
+}
+
+func (sw *sharedWriter) Close() error {
+	return sw.w.Close()
+}
+
+// copyBuffer copies src to dst through a buffer taken from pool. The
+// ReaderFrom and WriterTo implementations of the streams are hidden, so
+// that io.CopyBuffer always copies through the pooled buffer.
+func copyBuffer(dst io.Writer, src io.Reader, pool *sync.Pool) (int64, error) {
+	p := pool.Get().(*[]byte)
+	defer pool.Put(p)
+
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *p)
+}
+
+// ioCopy pumps the IO between the tty and the process pipes until the
+// process streams are closed or ctx is cancelled, and then closes exitch.
+// The streams are copied through pooled buffers of bufferSize bytes, see
+// bufferPool. If tee isn't nil, the process output is also written to it,
+// and it is closed once the copy is over.
+func ioCopy(ctx context.Context, exitch chan struct{}, tty *ttyIO, stdinPipe io.WriteCloser, stdoutPipe, stderrPipe io.Reader, tee io.WriteCloser, bufferSize int) {
 	var wg sync.WaitGroup
 	var closeOnce sync.Once
 
+	pool := bufferPool(bufferSize)
+
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+
+	// On cancellation, closing both ends of the streams unblocks the
+	// pending copies.
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			closeOnce.Do(tty.close)
+			for _, p := range []interface{}{stdinPipe, stdoutPipe, stderrPipe} {
+				if c, ok := p.(io.Closer); ok {
+					c.Close()
+				}
+			}
+		case <-done:
+		}
+	}()
+
+	// The host closing stdin only closes the guest stdin, the process
+	// output keeps being pumped until the process exits, e.g. for
+	// `echo foo | cat`.
+	stdinDone := make(chan struct{})
 	if tty.Stdin != nil {
-		wg.Add(1)
 		go func() {
-			p := bufPool.Get().(*[]byte)
-			defer bufPool.Put(p)
-			io.CopyBuffer(stdinPipe, tty.Stdin, *p)
-			wg.Done()
+			defer close(stdinDone)
+			if _, err := copyBuffer(stdinPipe, tty.Stdin, pool); err == nil {
+				stdinPipe.Close()
+			}
 		}()
+	} else {
+		close(stdinDone)
 	}
 
-	if tty.Stdout != nil {
-		wg.Add(1)
+	stdout, stderr := tty.Stdout, tty.Stderr
+	if tee != nil {
+		if stdout != nil {
+			stdout = io.MultiWriter(stdout, tee)
+		}
+		if stderr != nil {
+			stderr = io.MultiWriter(stderr, tee)
+		}
+	}
 
+	if stdout != nil {
+		wg.Add(1)
 		go func() {
-			p := bufPool.Get().(*[]byte)
-			defer bufPool.Put(p)
-			io.CopyBuffer(tty.Stdout, stdoutPipe, *p)
+			copyBuffer(stdout, stdoutPipe, pool)
 			wg.Done()
-			closeOnce.Do(tty.close)
 		}()
 	}
 
-	if tty.Stderr != nil && stderrPipe != nil {
+	if stderr != nil && stderrPipe != nil {
 		wg.Add(1)
 		go func() {
-			p := bufPool.Get().(*[]byte)
-			defer bufPool.Put(p)
-			io.CopyBuffer(tty.Stderr, stderrPipe, *p)
+			copyBuffer(stderr, stderrPipe, pool)
 			wg.Done()
 		}()
 	}
 
 	wg.Wait()
+
+	// The process has exited, closing the host stdin unblocks its
+	// copy if the client never closed it.
+	if tty.Stdin != nil {
+		tty.Stdin.Close()
+	}
+	<-stdinDone
+
+	close(done)
+	<-watcherDone
 	closeOnce.Do(tty.close)
+	if tee != nil {
+		tee.Close()
+	}
 	close(exitch)
 }
+
+// logRateLimiter is a token bucket limiting the number of lines per second
+// forwarded from a container's output streams. The lines exceeding the rate
+// are held back until the bucket refills, throttling the container output.
+type logRateLimiter struct {
+	mu     sync.Mutex
+	clock  clock
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLogRateLimiter(linesPerSecond uint, clk clock) *logRateLimiter {
+	return &logRateLimiter{
+		clock:  clk,
+		rate:   float64(linesPerSecond),
+		tokens: float64(linesPerSecond),
+	}
+}
+
+// wait takes a token from the bucket, sleeping until the bucket refills
+// if there is none left.
+func (l *logRateLimiter) wait() {
+	l.mu.Lock()
+
+	now := l.clock.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+
+	// The token is taken ahead, the bucket going in debt for the time
+	// it takes to refill.
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.clock.Sleep(delay)
+	}
+}
+
+// writer wraps w so that the lines written to it are rate limited.
+func (l *logRateLimiter) writer(w io.Writer) io.Writer {
+	if w == nil {
+		return nil
+	}
+
+	return &rateLimitedWriter{
+		w:       w,
+		limiter: l,
+	}
+}
+
+// rateLimitedWriter forwards the lines written to it to the underlying
+// writer, at the rate of its limiter. A line split across several writes
+// only counts once.
+type rateLimitedWriter struct {
+	w       io.Writer
+	limiter *logRateLimiter
+	midLine bool
+}
+
+func (rw *rateLimitedWriter) Write(p []byte) (int, error) {
+	data := p
+
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		if !rw.midLine {
+			rw.limiter.wait()
+		}
+		rw.midLine = line[len(line)-1] != '\n'
+
+		if _, err := rw.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes the underlying writer, so that ttyIO.close() still
+// releases the wrapped fifo.
+func (rw *rateLimitedWriter) Close() error {
+	if c, ok := rw.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// outputLogDepth is the number of writes an outputLog queues before
+// dropping the process output.
+const outputLogDepth = 256
+
+// outputLogMaxSize is the size, in bytes, an output log file grows to
+// before the process output stops being copied to it.
+const outputLogMaxSize = 16 * 1024 * 1024
+
+// outputLog copies the process output to a file, for debugging. The writes
+// are queued and written to the file by a goroutine, so that a stalled file
+// never blocks the copy to the client: the output is dropped, and counted,
+// once outputLogDepth writes are pending. The file doesn't grow past
+// maxSize bytes, the output being dropped then.
+type outputLog struct {
+	mu      sync.Mutex
+	w       io.WriteCloser
+	queue   chan []byte
+	done    chan struct{}
+	closed  bool
+	dropped uint64
+
+	// size is only updated by flush.
+	size    int64
+	maxSize int64
+}
+
+// newOutputLog returns an outputLog writing to w, which already holds size
+// bytes.
+func newOutputLog(w io.WriteCloser, depth int, size, maxSize int64) *outputLog {
+	l := &outputLog{
+		w:       w,
+		queue:   make(chan []byte, depth),
+		done:    make(chan struct{}),
+		size:    size,
+		maxSize: maxSize,
+	}
+
+	go l.flush()
+
+	return l
+}
+
+func (l *outputLog) flush() {
+	defer close(l.done)
+
+	failed := l.size >= l.maxSize
+	if failed {
+		logrus.Warn("the process output log is full, dropping the output")
+	}
+
+	for data := range l.queue {
+		if failed {
+			continue
+		}
+
+		if left := l.maxSize - l.size; int64(len(data)) > left {
+			data = data[:left]
+		}
+
+		n, err := l.w.Write(data)
+		l.size += int64(n)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to write the process output log, dropping the output")
+			failed = true
+		} else if l.size >= l.maxSize {
+			logrus.WithField("size", l.size).Warn("the process output log is full, dropping the output")
+			failed = true
+		}
+	}
+}
+
+// Write never blocks nor fails, the data being dropped instead if too
+// many writes are pending.
+func (l *outputLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return len(p), nil
+	}
+
+	// p is a pooled copy buffer, it is reused once Write returns.
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	select {
+	case l.queue <- data:
+	default:
+		l.dropped++
+	}
+
+	return len(p), nil
+}
+
+// droppedWrites returns the number of writes dropped so far.
+func (l *outputLog) droppedWrites() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dropped
+}
+
+// Close writes the pending output and closes the file.
+func (l *outputLog) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+
+	if l.dropped > 0 {
+		logrus.WithField("writes", l.dropped).Warn("the process output log dropped some output")
+	}
+
+	return l.w.Close()
+}
@@ -0,0 +1,516 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/fifo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &fakeClock{now: time.Now()}
+	start := clk.Now()
+	l := newLogRateLimiter(2, clk)
+
+	var out bytes.Buffer
+	w := l.writer(&out)
+
+	// a burst of 5 lines, only 2 of them fit in the bucket, the others
+	// are held back until it refills
+	n, err := w.Write([]byte("line1\nline2\nline3\nline4\nline5\n"))
+	assert.NoError(err)
+	assert.Equal(30, n)
+	assert.Equal("line1\nline2\nline3\nline4\nline5\n", out.String())
+	assert.Equal(3, clk.sleeps)
+	assert.Equal(1500*time.Millisecond, clk.Now().Sub(start))
+
+	// the bucket refills over time
+	clk.now = clk.now.Add(time.Second)
+	clk.sleeps = 0
+	out.Reset()
+
+	// a line written in several chunks only counts once
+	_, err = w.Write([]byte("li"))
+	assert.NoError(err)
+	_, err = w.Write([]byte("ne6\nline7\n"))
+	assert.NoError(err)
+	assert.Equal("line6\nline7\n", out.String())
+	assert.Equal(0, clk.sleeps)
+}
+
+func TestLogRateLimiterSharedBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &fakeClock{now: time.Now()}
+	l := newLogRateLimiter(1, clk)
+
+	var stdout, stderr bytes.Buffer
+	_, err := l.writer(&stdout).Write([]byte("out\n"))
+	assert.NoError(err)
+	_, err = l.writer(&stderr).Write([]byte("err\n"))
+	assert.NoError(err)
+
+	assert.Equal("out\n", stdout.String())
+	assert.Equal("err\n", stderr.String())
+	assert.Equal(1, clk.sleeps)
+
+	assert.Nil(l.writer(nil))
+}
+
+// nopWriteCloser records whether it has been closed.
+type nopWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *nopWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestIoCopyCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	// neither the tty nor the process ever close their streams
+	ttyStdin, _ := io.Pipe()
+	stdinPipe := &nopWriteCloser{}
+	stdoutPipe, _ := io.Pipe()
+	stdout := &nopWriteCloser{}
+
+	tty := &ttyIO{
+		Stdin:  ttyStdin,
+		Stdout: stdout,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exitch := make(chan struct{})
+
+	go ioCopy(ctx, exitch, tty, stdinPipe, stdoutPipe, nil, nil, 0)
+
+	select {
+	case <-exitch:
+		t.Fatal("ioCopy returned before the context was cancelled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case <-exitch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ioCopy didn't return after the context was cancelled")
+	}
+
+	assert.True(stdout.closed)
+	assert.True(stdinPipe.closed)
+}
+
+func TestIoCopyStreamsClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	stdout := &nopWriteCloser{}
+	tty := &ttyIO{
+		Stdout: stdout,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exitch := make(chan struct{})
+
+	go ioCopy(ctx, exitch, tty, nil, bytes.NewBufferString("output\n"), nil, nil, 0)
+
+	select {
+	case <-exitch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ioCopy didn't return once the streams were closed")
+	}
+
+	assert.Equal("output\n", stdout.String())
+	assert.True(stdout.closed)
+}
+
+// strictWriteCloser fails the writes once it has been closed.
+type strictWriteCloser struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *strictWriteCloser) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *strictWriteCloser) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	return nil
+}
+
+func (w *strictWriteCloser) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.buf.String()
+}
+
+func TestIoCopyStdinClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	// the process behaves like `cat`, only exiting a while after the
+	// end of its stdin
+	stdinReader, stdinPipe := io.Pipe()
+	stdoutPipe, stdoutWriter := io.Pipe()
+	stderrPipe, stderrWriter := io.Pipe()
+	stdinClosed := make(chan struct{})
+	processExit := make(chan struct{})
+	go func() {
+		io.Copy(stdoutWriter, stdinReader)
+		close(stdinClosed)
+		<-processExit
+		stdoutWriter.Write([]byte("bar\n"))
+		stderrWriter.Write([]byte("exiting\n"))
+		stdoutWriter.Close()
+		stderrWriter.Close()
+	}()
+
+	stdout := &strictWriteCloser{}
+	stderr := &strictWriteCloser{}
+	tty := &ttyIO{
+		Stdin:  ioutil.NopCloser(strings.NewReader("foo\n")),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exitch := make(chan struct{})
+
+	go ioCopy(ctx, exitch, tty, stdinPipe, stdoutPipe, stderrPipe, nil, 0)
+
+	select {
+	case <-stdinClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the guest stdin wasn't closed at the end of the host stdin")
+	}
+
+	select {
+	case <-exitch:
+		t.Fatal("ioCopy returned before the process exited")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(processExit)
+
+	select {
+	case <-exitch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ioCopy didn't return once the process exited")
+	}
+
+	assert.Equal("foo\nbar\n", stdout.String())
+	assert.Equal("exiting\n", stderr.String())
+	assert.True(stdout.closed)
+	assert.True(stderr.closed)
+}
+
+func TestBufferPool(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(bufPool, bufferPool(0))
+	assert.Equal(bufPool, bufferPool(bufSize))
+
+	pool := bufferPool(4096)
+	assert.Equal(pool, bufferPool(4096))
+	assert.NotEqual(bufPool, pool)
+
+	p := pool.Get().(*[]byte)
+	assert.Len(*p, 4096)
+	pool.Put(p)
+}
+
+func TestIoCopyBufferSize(t *testing.T) {
+	assert := assert.New(t)
+
+	stdoutData := make([]byte, 1<<20+17)
+	stderrData := make([]byte, 64<<10+3)
+	rand.Read(stdoutData)
+	rand.Read(stderrData)
+
+	for _, size := range []int{0, 1000, 4096, 256 << 10} {
+		stdout := &nopWriteCloser{}
+		stderr := &nopWriteCloser{}
+		tty := &ttyIO{
+			Stdout: stdout,
+			Stderr: stderr,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		exitch := make(chan struct{})
+
+		go ioCopy(ctx, exitch, tty, nil, bytes.NewReader(stdoutData), bytes.NewReader(stderrData), nil, size)
+
+		select {
+		case <-exitch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ioCopy didn't return with a %d bytes buffer", size)
+		}
+		cancel()
+
+		assert.True(bytes.Equal(stdoutData, stdout.Bytes()), "buffer size %d", size)
+		assert.True(bytes.Equal(stderrData, stderr.Bytes()), "buffer size %d", size)
+	}
+}
+
+// countingWriter counts the writes, each of them being a syscall for the
+// fifos.
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func BenchmarkIoCopyBufferSize(b *testing.B) {
+	data := make([]byte, 4<<20)
+
+	// io.Copy allocates a new buffer for each copy
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		w := &countingWriter{}
+		for i := 0; i < b.N; i++ {
+			io.Copy(w, struct{ io.Reader }{bytes.NewReader(data)})
+		}
+		b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+	})
+
+	for _, size := range []int{4 << 10, bufSize, 256 << 10} {
+		b.Run(fmt.Sprintf("pooled-%dk", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			pool := bufferPool(size)
+			w := &countingWriter{}
+			for i := 0; i < b.N; i++ {
+				copyBuffer(w, bytes.NewReader(data), pool)
+			}
+			b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestIoCopyOutputLog(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "output-log")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := &service{}
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+
+	// the output isn't copied by default
+	assert.Empty(containerOutputLog(s, c))
+	assert.Nil(openOutputLog(s, c))
+
+	s.outputLogDir = dir
+	path := containerOutputLog(s, c)
+	assert.Equal(filepath.Join(dir, testContainerID+"-output.log"), path)
+
+	stdout := &nopWriteCloser{}
+	stderr := &nopWriteCloser{}
+	tty := &ttyIO{
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+
+	exitch := make(chan struct{})
+	go ioCopy(context.Background(), exitch, tty, nil, bytes.NewBufferString("output\n"), bytes.NewBufferString("error\n"), openOutputLog(s, c), 0)
+
+	select {
+	case <-exitch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ioCopy didn't return once the streams were closed")
+	}
+
+	// the client gets the whole output, and the log a copy of it
+	assert.Equal("output\n", stdout.String())
+	assert.Equal("error\n", stderr.String())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(data), "output\n")
+	assert.Contains(string(data), "error\n")
+	assert.Len(data, len("output\nerror\n"))
+}
+
+func TestOutputLogMaxSize(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &nopWriteCloser{}
+	l := newOutputLog(f, 16, 0, 12)
+
+	for i := 0; i < 4; i++ {
+		n, err := l.Write([]byte("line\n"))
+		assert.NoError(err)
+		assert.Equal(len("line\n"), n)
+	}
+
+	assert.NoError(l.Close())
+	assert.Equal("line\nline\nli", f.String())
+
+	// a full file isn't written to
+	f = &nopWriteCloser{}
+	l = newOutputLog(f, 16, 10, 10)
+	_, err := l.Write([]byte("line\n"))
+	assert.NoError(err)
+	assert.NoError(l.Close())
+	assert.Empty(f.String())
+}
+
+// stalledWriteCloser blocks the writes until unblock is closed.
+type stalledWriteCloser struct {
+	nopWriteCloser
+	unblock chan struct{}
+}
+
+func (w *stalledWriteCloser) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.nopWriteCloser.Write(p)
+}
+
+func TestOutputLogStalled(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &stalledWriteCloser{unblock: make(chan struct{})}
+	l := newOutputLog(f, 1, 0, outputLogMaxSize)
+
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			n, err := l.Write([]byte("line\n"))
+			assert.NoError(err)
+			assert.Equal(len("line\n"), n)
+		}
+		close(written)
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled output log blocked the writes")
+	}
+
+	// at most one write is being written and one queued
+	assert.True(l.droppedWrites() >= 8)
+
+	close(f.unblock)
+	assert.NoError(l.Close())
+	assert.True(f.closed)
+	assert.Equal(10-int(l.droppedWrites()), strings.Count(f.String(), "line\n"))
+
+	// the writes are dropped once closed
+	_, err := l.Write([]byte("late\n"))
+	assert.NoError(err)
+	assert.NotContains(f.String(), "late")
+}
+
+func TestIoCopySharedOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "shared-output")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(dir, "output")
+	client, err := fifo.OpenFifo(ctx, path, syscall.O_RDONLY|syscall.O_CREAT|syscall.O_NONBLOCK, 0700)
+	assert.NoError(err)
+	defer client.Close()
+
+	// 2>&1
+	tty, err := newTtyIO(ctx, "", path, path, false)
+	assert.NoError(err)
+	assert.True(tty.Stderr == tty.Stdout)
+
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- string(data)
+	}()
+
+	const lines = 200
+
+	stdoutPipe, stdoutWriter := io.Pipe()
+	stderrPipe, stderrWriter := io.Pipe()
+	for _, w := range []struct {
+		name string
+		pipe *io.PipeWriter
+	}{{"out", stdoutWriter}, {"err", stderrWriter}} {
+		go func(name string, pipe *io.PipeWriter) {
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(pipe, "%s %03d %s\n", name, i, strings.Repeat(name, 100))
+			}
+			pipe.Close()
+		}(w.name, w.pipe)
+	}
+
+	exitch := make(chan struct{})
+	go ioCopy(ctx, exitch, tty, nil, stdoutPipe, stderrPipe, nil, 0)
+
+	var output string
+	select {
+	case output = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shared output fifo wasn't closed")
+	}
+	<-exitch
+
+	// the lines of both streams are interleaved but intact, in order
+	next := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		var name string
+		var i int
+		_, err := fmt.Sscanf(line, "%s %d", &name, &i)
+		if !assert.NoError(err, "line %q", line) {
+			break
+		}
+		assert.Equal(fmt.Sprintf("%s %03d %s", name, next[name], strings.Repeat(name, 100)), line)
+		next[name]++
+	}
+	assert.Equal(map[string]int{"out": lines, "err": lines}, next)
+
+	// closing the shared writer again is harmless
+	tty.close()
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+)
+
+// namespacedSysctls are the sysctls of the IPC namespace.
+var namespacedSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// namespacedSysctlPrefixes are the prefixes of the sysctls of the IPC and
+// network namespaces.
+var namespacedSysctlPrefixes = []string{
+	"fs.mqueue.",
+	"net.",
+}
+
+// isNamespacedSysctl tells whether the sysctl only applies to the
+// namespaces of the container and not to the whole guest.
+func isNamespacedSysctl(name string) bool {
+	if namespacedSysctls[name] {
+		return true
+	}
+
+	for _, prefix := range namespacedSysctlPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivileged tells whether the container process may administer the
+// guest, i.e. has CAP_SYS_ADMIN in its bounding set.
+func isPrivileged(spec *oci.CompatOCISpec) bool {
+	if spec.Process == nil {
+		return false
+	}
+
+	caps, err := processCapabilities(spec)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range caps.Bounding {
+		if c == "CAP_SYS_ADMIN" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validSysctls checks the sysctls of the spec, which the agent is given
+// along with the spec to set them in the container when it starts. Only
+// namespaced sysctls are allowed unless the container is privileged, a
+// sysctl of the whole guest affecting the other containers of the sandbox.
+func validSysctls(spec *oci.CompatOCISpec) error {
+	if spec.Linux == nil || len(spec.Linux.Sysctl) == 0 {
+		return nil
+	}
+
+	privileged := isPrivileged(spec)
+
+	for name := range spec.Linux.Sysctl {
+		if name == "" {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "empty sysctl name")
+		}
+
+		if !privileged && !isNamespacedSysctl(name) {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "sysctl %q is not namespaced and requires a privileged container", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidSysctls(t *testing.T) {
+	assert := assert.New(t)
+
+	privileged := types.LinuxCapabilities{Bounding: []string{"CAP_KILL", "CAP_SYS_ADMIN"}}
+	unprivileged := types.LinuxCapabilities{Bounding: []string{"CAP_KILL", "CAP_NET_BIND_SERVICE"}}
+
+	data := []struct {
+		sysctls map[string]string
+		caps    interface{}
+		code    codes.Code
+	}{
+		// no sysctls
+		{nil, unprivileged, codes.OK},
+		// namespaced sysctls
+		{map[string]string{"net.core.somaxconn": "1024", "net.ipv4.ip_forward": "1"}, unprivileged, codes.OK},
+		{map[string]string{"kernel.shmmax": "68719476736", "fs.mqueue.msg_max": "100"}, unprivileged, codes.OK},
+		// sysctls of the whole guest
+		{map[string]string{"net.core.somaxconn": "1024", "vm.swappiness": "10"}, unprivileged, codes.InvalidArgument},
+		{map[string]string{"kernel.pid_max": "65536"}, nil, codes.InvalidArgument},
+		{map[string]string{"vm.swappiness": "10", "kernel.pid_max": "65536"}, privileged, codes.OK},
+		// capabilities not converted yet
+		{map[string]string{"vm.swappiness": "10"}, []interface{}{"CAP_SYS_ADMIN"}, codes.OK},
+		// empty name
+		{map[string]string{"": "1"}, privileged, codes.InvalidArgument},
+	}
+
+	for i, d := range data {
+		spec := &oci.CompatOCISpec{}
+		spec.Process = &oci.CompatOCIProcess{Capabilities: d.caps}
+		spec.Linux = &specs.Linux{Sysctl: d.sysctls}
+
+		err := validSysctls(spec)
+		assert.Equal(d.code, status.Code(err), "test %d: %v", i, err)
+	}
+
+	// a spec without any linux section
+	assert.NoError(validSysctls(&oci.CompatOCISpec{}))
+}
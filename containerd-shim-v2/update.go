@@ -0,0 +1,163 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// minCPUPeriod and maxCPUPeriod are the bounds of the CFS period
+	// accepted by the kernel, in microseconds.
+	minCPUPeriod = 1000
+	maxCPUPeriod = 1000000
+
+	// minCPUQuota is the smallest CFS quota accepted by the kernel,
+	// in microseconds.
+	minCPUQuota = 1000
+
+	// minBlkioWeight and maxBlkioWeight are the bounds of the block IO
+	// weight accepted by the kernel.
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+// validateResources checks the resources requested for the container,
+// before they get forwarded to the guest. A memory limit cannot be set
+// below the memory currently used by the container.
+func validateResources(s *service, containerID string, resources *specs.LinuxResources) error {
+	if cpu := resources.CPU; cpu != nil {
+		if p := cpu.Period; p != nil && *p != 0 && (*p < minCPUPeriod || *p > maxCPUPeriod) {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid CPU period %d, must be between %d and %d",
+				*p, minCPUPeriod, maxCPUPeriod)
+		}
+		if q := cpu.Quota; q != nil && *q != 0 && *q != -1 && *q < minCPUQuota {
+			return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid CPU quota %d, must be -1 or at least %d",
+				*q, minCPUQuota)
+		}
+	}
+
+	mem := resources.Memory
+	if mem == nil || mem.Limit == nil || *mem.Limit == -1 {
+		return nil
+	}
+
+	limit := *mem.Limit
+	if limit <= 0 {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid memory limit %d", limit)
+	}
+
+	stats, err := s.sandbox.StatsContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if stats.CgroupStats == nil {
+		return nil
+	}
+
+	if usage := stats.CgroupStats.MemoryStats.Usage.Usage; uint64(limit) < usage {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "cannot shrink the memory limit of container %s to %d bytes, below its current usage of %d bytes",
+			containerID, limit, usage)
+	}
+
+	return nil
+}
+
+// blkioResources returns the block IO limits requested by the annotations of
+// the container, or nil if it has none. They are forwarded to the guest,
+// whose device numbers the throttling annotations refer to.
+func blkioResources(annotations map[string]string) (*specs.LinuxBlockIO, error) {
+	var blkio specs.LinuxBlockIO
+	found := false
+
+	if value, ok := annotations[vcAnnotations.BlkioWeight]; ok {
+		weight, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || weight < minBlkioWeight || weight > maxBlkioWeight {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid %s annotation %q, must be between %d and %d",
+				vcAnnotations.BlkioWeight, value, minBlkioWeight, maxBlkioWeight)
+		}
+		w := uint16(weight)
+		blkio.Weight = &w
+		found = true
+	}
+
+	throttles := []struct {
+		annotation string
+		devices    *[]specs.LinuxThrottleDevice
+	}{
+		{vcAnnotations.BlkioReadBps, &blkio.ThrottleReadBpsDevice},
+		{vcAnnotations.BlkioWriteBps, &blkio.ThrottleWriteBpsDevice},
+		{vcAnnotations.BlkioReadIOPS, &blkio.ThrottleReadIOPSDevice},
+		{vcAnnotations.BlkioWriteIOPS, &blkio.ThrottleWriteIOPSDevice},
+	}
+
+	for _, t := range throttles {
+		value, ok := annotations[t.annotation]
+		if !ok {
+			continue
+		}
+
+		devices, err := parseThrottleDevices(value)
+		if err != nil {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid %s annotation %q: %v", t.annotation, value, err)
+		}
+		*t.devices = devices
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &blkio, nil
+}
+
+// parseThrottleDevices parses a comma separated list of major:minor=rate
+// block IO throttling entries.
+func parseThrottleDevices(value string) ([]specs.LinuxThrottleDevice, error) {
+	var devices []specs.LinuxThrottleDevice
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("entry %q is not major:minor=rate", entry)
+		}
+
+		numbers := strings.SplitN(fields[0], ":", 2)
+		if len(numbers) != 2 {
+			return nil, fmt.Errorf("device %q is not major:minor", fields[0])
+		}
+
+		major, err := strconv.ParseInt(numbers[0], 10, 64)
+		if err != nil || major < 0 {
+			return nil, fmt.Errorf("invalid major number %q", numbers[0])
+		}
+		minor, err := strconv.ParseInt(numbers[1], 10, 64)
+		if err != nil || minor < 0 {
+			return nil, fmt.Errorf("invalid minor number %q", numbers[1])
+		}
+
+		rate, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("invalid rate %q", fields[1])
+		}
+
+		device := specs.LinuxThrottleDevice{Rate: rate}
+		device.Major = major
+		device.Minor = minor
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// updateSandbox reports the configured memory usage and records the
+// resources updates.
+type updateSandbox struct {
+	vcmock.Sandbox
+	memUsage uint64
+	updates  []specs.LinuxResources
+}
+
+func (s *updateSandbox) StatsContainer(contID string) (vc.ContainerStats, error) {
+	return vc.ContainerStats{
+		CgroupStats: &vc.CgroupStats{
+			MemoryStats: vc.MemoryStats{
+				Usage: vc.MemoryData{Usage: s.memUsage},
+			},
+		},
+	}, nil
+}
+
+func (s *updateSandbox) UpdateContainer(contID string, resources specs.LinuxResources) error {
+	s.updates = append(s.updates, resources)
+	return nil
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &updateSandbox{
+		Sandbox:  vcmock.Sandbox{MockID: testSandboxID},
+		memUsage: 64 << 20,
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+
+	int64Ptr := func(v int64) *int64 { return &v }
+	uint64Ptr := func(v uint64) *uint64 { return &v }
+
+	data := []struct {
+		resources specs.LinuxResources
+		valid     bool
+	}{
+		{specs.LinuxResources{}, true},
+		{specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(128 << 20)}}, true},
+		{specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(64 << 20)}}, true},
+		{specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(-1)}}, true},
+		{specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(50000), Period: uint64Ptr(100000)}}, true},
+		{specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(-1)}}, true},
+		// below the memory usage
+		{specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(32 << 20)}}, false},
+		{specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(-2)}}, false},
+		{specs.LinuxResources{CPU: &specs.LinuxCPU{Period: uint64Ptr(100)}}, false},
+		{specs.LinuxResources{CPU: &specs.LinuxCPU{Period: uint64Ptr(2000000)}}, false},
+		{specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(10)}}, false},
+	}
+
+	for i, d := range data {
+		sandbox.updates = nil
+
+		resources := d.resources
+		any, err := typeurl.MarshalAny(&resources)
+		assert.NoError(err, "test %d", i)
+
+		_, err = s.Update(context.Background(), &taskAPI.UpdateTaskRequest{
+			ID:        testContainerID,
+			Resources: any,
+		})
+		if !d.valid {
+			assert.Error(err, "test %d", i)
+			assert.Equal(codes.InvalidArgument, status.Code(err), "test %d", i)
+			assert.Empty(sandbox.updates, "test %d", i)
+			continue
+		}
+
+		assert.NoError(err, "test %d", i)
+		assert.Equal([]specs.LinuxResources{d.resources}, sandbox.updates, "test %d", i)
+	}
+
+	any, err := typeurl.MarshalAny(&specs.LinuxResources{})
+	assert.NoError(err)
+	_, err = s.Update(context.Background(), &taskAPI.UpdateTaskRequest{
+		ID:        "unknown",
+		Resources: any,
+	})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+func throttleDevice(major, minor int64, rate uint64) specs.LinuxThrottleDevice {
+	d := specs.LinuxThrottleDevice{Rate: rate}
+	d.Major = major
+	d.Minor = minor
+	return d
+}
+
+func TestBlkioResources(t *testing.T) {
+	assert := assert.New(t)
+
+	// no annotation
+	blkio, err := blkioResources(nil)
+	assert.NoError(err)
+	assert.Nil(blkio)
+
+	blkio, err = blkioResources(map[string]string{vcAnnotations.StopSignal: "SIGINT"})
+	assert.NoError(err)
+	assert.Nil(blkio)
+
+	// valid limits
+	blkio, err = blkioResources(map[string]string{
+		vcAnnotations.BlkioWeight:    "500",
+		vcAnnotations.BlkioReadBps:   "254:0=10485760",
+		vcAnnotations.BlkioWriteBps:  "254:0=5242880, 254:16=1048576",
+		vcAnnotations.BlkioReadIOPS:  "8:0=1000",
+		vcAnnotations.BlkioWriteIOPS: "8:0=500",
+	})
+	assert.NoError(err)
+	if assert.NotNil(blkio) && assert.NotNil(blkio.Weight) {
+		assert.Equal(uint16(500), *blkio.Weight)
+	}
+	assert.Equal([]specs.LinuxThrottleDevice{throttleDevice(254, 0, 10485760)}, blkio.ThrottleReadBpsDevice)
+	assert.Equal([]specs.LinuxThrottleDevice{throttleDevice(254, 0, 5242880), throttleDevice(254, 16, 1048576)}, blkio.ThrottleWriteBpsDevice)
+	assert.Equal([]specs.LinuxThrottleDevice{throttleDevice(8, 0, 1000)}, blkio.ThrottleReadIOPSDevice)
+	assert.Equal([]specs.LinuxThrottleDevice{throttleDevice(8, 0, 500)}, blkio.ThrottleWriteIOPSDevice)
+
+	blkio, err = blkioResources(map[string]string{vcAnnotations.BlkioWriteIOPS: "8:0=500"})
+	assert.NoError(err)
+	assert.Nil(blkio.Weight)
+	assert.Empty(blkio.ThrottleReadBpsDevice)
+	assert.Len(blkio.ThrottleWriteIOPSDevice, 1)
+
+	// malformed values
+	malformed := []struct {
+		annotation string
+		value      string
+	}{
+		{vcAnnotations.BlkioWeight, "heavy"},
+		{vcAnnotations.BlkioWeight, "5"},
+		{vcAnnotations.BlkioWeight, "1001"},
+		{vcAnnotations.BlkioWeight, "-100"},
+		{vcAnnotations.BlkioReadBps, ""},
+		{vcAnnotations.BlkioReadBps, "10485760"},
+		{vcAnnotations.BlkioReadBps, "254=10485760"},
+		{vcAnnotations.BlkioWriteBps, "sda:0=10485760"},
+		{vcAnnotations.BlkioWriteBps, "254:-1=10485760"},
+		{vcAnnotations.BlkioReadIOPS, "254:0=fast"},
+		{vcAnnotations.BlkioReadIOPS, "254:0=0"},
+		{vcAnnotations.BlkioWriteIOPS, "254:0=-5"},
+		{vcAnnotations.BlkioWriteIOPS, "254:0=100,"},
+	}
+
+	for _, d := range malformed {
+		_, err := blkioResources(map[string]string{d.annotation: d.value})
+		assert.Equal(codes.InvalidArgument, status.Code(err), "%s=%q", d.annotation, d.value)
+	}
+}
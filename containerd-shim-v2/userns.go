@@ -0,0 +1,75 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// validIDMappings checks the uid and gid mappings of the user namespace of
+// the spec, which the agent is given along with the spec to set up the
+// namespace of the container in the guest. Mappings require a user
+// namespace, and the ranges of each kind must not be empty nor overlap,
+// neither in the container nor on the host.
+func validIDMappings(spec *oci.CompatOCISpec) error {
+	if spec.Linux == nil {
+		return nil
+	}
+
+	uid, gid := spec.Linux.UIDMappings, spec.Linux.GIDMappings
+	if len(uid) == 0 && len(gid) == 0 {
+		return nil
+	}
+
+	userns := false
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			userns = true
+		}
+	}
+	if !userns {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "uid and gid mappings require a user namespace")
+	}
+
+	if err := checkIDMappings(uid); err != nil {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid uid mappings: %v", err)
+	}
+
+	if err := checkIDMappings(gid); err != nil {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid gid mappings: %v", err)
+	}
+
+	return nil
+}
+
+func checkIDMappings(mappings []specs.LinuxIDMapping) error {
+	for i, m := range mappings {
+		if m.Size == 0 {
+			return fmt.Errorf("mapping %d has an empty range", i)
+		}
+
+		for j, prev := range mappings[:i] {
+			if rangesOverlap(m.ContainerID, m.Size, prev.ContainerID, prev.Size) {
+				return fmt.Errorf("the container ranges of mappings %d and %d overlap", j, i)
+			}
+			if rangesOverlap(m.HostID, m.Size, prev.HostID, prev.Size) {
+				return fmt.Errorf("the host ranges of mappings %d and %d overlap", j, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rangesOverlap reports whether the id ranges of the given starts and sizes
+// have an id in common.
+func rangesOverlap(a, aSize, b, bSize uint32) bool {
+	return uint64(a) < uint64(b)+uint64(bSize) && uint64(b) < uint64(a)+uint64(aSize)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidIDMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	userns := []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.UserNamespace}}
+	rootless := []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+
+	data := []struct {
+		namespaces []specs.LinuxNamespace
+		uid        []specs.LinuxIDMapping
+		gid        []specs.LinuxIDMapping
+		code       codes.Code
+	}{
+		// no mappings
+		{nil, nil, nil, codes.OK},
+		// a single range
+		{userns, rootless, rootless, codes.OK},
+		// several adjacent ranges
+		{
+			userns,
+			[]specs.LinuxIDMapping{
+				{ContainerID: 0, HostID: 1000, Size: 1},
+				{ContainerID: 1, HostID: 100000, Size: 65535},
+			},
+			[]specs.LinuxIDMapping{
+				{ContainerID: 0, HostID: 1000, Size: 1},
+				{ContainerID: 1000, HostID: 200000, Size: 1000},
+				{ContainerID: 1, HostID: 100000, Size: 999},
+			},
+			codes.OK,
+		},
+		// the whole id space
+		{userns, []specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 4294967295}}, nil, codes.OK},
+		// overlapping container ranges
+		{
+			userns,
+			[]specs.LinuxIDMapping{
+				{ContainerID: 0, HostID: 100000, Size: 1000},
+				{ContainerID: 999, HostID: 200000, Size: 1000},
+			},
+			nil,
+			codes.InvalidArgument,
+		},
+		// overlapping host ranges
+		{
+			userns,
+			rootless,
+			[]specs.LinuxIDMapping{
+				{ContainerID: 0, HostID: 100000, Size: 1000},
+				{ContainerID: 1000, HostID: 100500, Size: 1000},
+			},
+			codes.InvalidArgument,
+		},
+		// empty range
+		{userns, []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 0}}, nil, codes.InvalidArgument},
+		// no user namespace to map
+		{[]specs.LinuxNamespace{{Type: specs.PIDNamespace}}, rootless, rootless, codes.InvalidArgument},
+	}
+
+	for i, d := range data {
+		spec := &oci.CompatOCISpec{}
+		spec.Linux = &specs.Linux{
+			Namespaces:  d.namespaces,
+			UIDMappings: d.uid,
+			GIDMappings: d.gid,
+		}
+
+		err := validIDMappings(spec)
+		assert.Equal(d.code, status.Code(err), "test %d: %v", i, err)
+	}
+
+	// a spec without any linux section
+	assert.NoError(validIDMappings(&oci.CompatOCISpec{}))
+}
+
+func TestRangesOverlap(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(rangesOverlap(0, 10, 5, 10))
+	assert.True(rangesOverlap(5, 10, 0, 10))
+	assert.True(rangesOverlap(0, 10, 2, 1))
+	assert.False(rangesOverlap(0, 10, 10, 10))
+	assert.False(rangesOverlap(10, 10, 0, 10))
+	assert.True(rangesOverlap(4294967290, 5, 4294967294, 1))
+}
@@ -11,20 +11,51 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/errdefs"
 	cdshim "github.com/containerd/containerd/runtime/v2/shim"
 	"github.com/kata-containers/runtime/pkg/katautils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func cReap(s *service, status int, id, execid string, exitat time.Time) {
-	s.ec <- exit{
+const (
+	// defaultStopTimeout is the grace period given to a container to stop
+	// when its spec doesn't set one.
+	defaultStopTimeout = 10 * time.Second
+
+	// stopPollInterval is the interval between the checks of the state of
+	// a container being stopped.
+	stopPollInterval = 100 * time.Millisecond
+
+	// maxSignal is the highest signal number.
+	maxSignal = 64
+)
+
+// stopSignals are the signals which can be set by name as stop signal.
+var stopSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"PWR":  syscall.SIGPWR,
+}
+
+// newExit returns the exit of a process of the service.
+func newExit(s *service, status int, id, execid string, exitat time.Time) exit {
+	return exit{
 		timestamp: exitat,
 		pid:       s.pid,
 		status:    status,
@@ -33,77 +64,238 @@ func cReap(s *service, status int, id, execid string, exitat time.Time) {
 	}
 }
 
-func cleanupContainer(ctx context.Context, sid, cid, bundlePath string) error {
+func cReap(s *service, status int, id, execid string, exitat time.Time) {
+	e := newExit(s, status, id, execid, exitat)
+
+	if s.bufferExit(e) {
+		return
+	}
+
+	s.ec <- e
+}
+
+// stopGracefully sends the stop signal of the container and gives it its
+// grace period to stop, before killing it.
+func stopGracefully(sandbox vc.VCSandbox, clk clock, cid string, status vc.ContainerStatus) error {
+	signal, timeout := stopConfig(cid, status)
+	return stopWithin(sandbox, clk, cid, signal, timeout)
+}
+
+// stopWithin sends signal to the container and gives it timeout to stop,
+// before killing it.
+func stopWithin(sandbox vc.VCSandbox, clk clock, cid string, signal syscall.Signal, timeout time.Duration) error {
+	if signal == syscall.SIGKILL {
+		return sandbox.KillContainer(cid, signal, true)
+	}
+
+	if err := sandbox.KillContainer(cid, signal, true); err != nil {
+		return err
+	}
+
+	deadline := clk.After(timeout)
+	for {
+		st, err := sandbox.StatusContainer(cid)
+		if err == nil && oci.StateToOCIState(st.State.State) == oci.StateStopped {
+			return nil
+		}
+
+		select {
+		case <-deadline:
+			logrus.WithFields(logrus.Fields{
+				"container": cid,
+				"timeout":   timeout,
+			}).Warn("container didn't stop in time, killing it")
+			return sandbox.KillContainer(cid, syscall.SIGKILL, true)
+		default:
+		}
+
+		clk.Sleep(stopPollInterval)
+	}
+}
+
+// stopConfig returns the stop signal and grace period of the container,
+// from the annotations of its OCI spec. Invalid values are ignored.
+func stopConfig(cid string, status vc.ContainerStatus) (syscall.Signal, time.Duration) {
+	signal := syscall.SIGTERM
+
+	ociSpec, err := oci.GetOCIConfig(status)
+	if err != nil {
+		return signal, defaultStopTimeout
+	}
+
+	if value, ok := ociSpec.Annotations[vcAnnotations.StopSignal]; ok {
+		if s, err := parseSignal(value); err == nil {
+			signal = s
+		} else {
+			logrus.WithError(err).WithField("container", cid).Warn("ignoring invalid stop signal")
+		}
+	}
+
+	return signal, stopTimeout(cid, ociSpec.Annotations)
+}
+
+// stopTimeout returns the grace period of the container, set in seconds by
+// the StopTimeout annotation, or defaultStopTimeout if the annotation is
+// absent or invalid.
+func stopTimeout(cid string, annotations map[string]string) time.Duration {
+	value, ok := annotations[vcAnnotations.StopTimeout]
+	if !ok {
+		return defaultStopTimeout
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		logrus.WithField("container", cid).Warnf("ignoring invalid stop timeout %q", value)
+		return defaultStopTimeout
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// parseSignal converts a signal name, with or without the SIG prefix, or
+// number into a signal.
+func parseSignal(value string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+	if s, ok := stopSignals[name]; ok {
+		return s, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > maxSignal {
+		return 0, fmt.Errorf("invalid signal %q", value)
+	}
+
+	return syscall.Signal(n), nil
+}
+
+// validSignal checks that signum is a signal which can be sent to the
+// container processes.
+func validSignal(signum syscall.Signal) error {
+	if signum <= 0 || signum > maxSignal {
+		return errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid signal %d", signum)
+	}
+
+	return nil
+}
+
+// postStopHooks runs the post-stop OCI hooks of the spec. A hook failure is
+// only logged, not to block the teardown.
+func postStopHooks(ctx context.Context, spec oci.CompatOCISpec, cid, bundlePath string) {
+	if err := katautils.PostStopHooks(ctx, spec, cid, bundlePath); err != nil {
+		logrus.WithError(err).WithField("bundle", bundlePath).Warn("failed to run post-stop hooks")
+	}
+}
+
+// cleanupContainer kills, stops and removes the container and, if it was
+// the last one, the sandbox. Every step is performed regardless of the
+// previous failures, and all the step errors are returned together as a
+// *cleanupErrors.
+func cleanupContainer(ctx context.Context, m mounter, clk clock, sid, cid, bundlePath string) error {
 	logrus.WithField("Service", "Cleanup").WithField("container", cid).Info("Cleanup container")
 
-	rootfs := filepath.Join(bundlePath, "rootfs")
 	sandbox, err := vci.FetchSandbox(ctx, sid)
 	if err != nil {
 		return err
 	}
 
+	return teardownContainer(ctx, m, clk, sandbox, sid, cid, bundlePath)
+}
+
+// teardownContainer performs the cleanupContainer steps on the given
+// sandbox.
+func teardownContainer(ctx context.Context, m mounter, clk clock, sandbox vc.VCSandbox, sid, cid, bundlePath string) error {
+	rootfs := filepath.Join(bundlePath, "rootfs")
+
+	var errs []error
+
 	status, err := sandbox.StatusContainer(cid)
 	if err != nil {
 		logrus.WithError(err).WithField("container", cid).Warn("failed to get container status")
-		return err
+		errs = append(errs, err)
 	}
 
 	if oci.StateToOCIState(status.State.State) != oci.StateStopped {
-		err := sandbox.KillContainer(cid, syscall.SIGKILL, true)
+		err := stopGracefully(sandbox, clk, cid, status)
 		if err != nil {
 			logrus.WithError(err).WithField("container", cid).Warn("failed to kill container")
-			return err
+			errs = append(errs, err)
 		}
 	}
 
 	if _, err = sandbox.StopContainer(cid); err != nil {
 		logrus.WithError(err).WithField("container", cid).Warn("failed to stop container")
-		return err
+		errs = append(errs, err)
 	}
 
 	if _, err := sandbox.DeleteContainer(cid); err != nil {
 		logrus.WithError(err).WithField("container", cid).Warn("failed to remove container")
+		errs = append(errs, err)
+	}
+
+	// Run post-stop OCI hooks.
+	if ociSpec, err := oci.ParseConfigJSON(bundlePath); err == nil {
+		postStopHooks(ctx, ociSpec, sid, bundlePath)
+	} else {
+		logrus.WithError(err).WithField("container", cid).Warn("failed to load spec, not running post-stop hooks")
 	}
 
-	if err := mount.UnmountAll(rootfs, 0); err != nil {
+	if err := unmountRootfs(m, clk, rootfs, false); err != nil {
 		logrus.WithError(err).WithField("container", cid).Warn("failed to cleanup container rootfs")
+		errs = append(errs, err)
 	}
 
 	if len(sandbox.GetAllContainers()) == 0 {
-		err = sandbox.Stop()
-		if err != nil {
+		if err := sandbox.Stop(); err != nil {
 			logrus.WithError(err).WithField("sandbox", sid).Warn("failed to stop sandbox")
-			return err
+			errs = append(errs, err)
 		}
 
-		err = sandbox.Delete()
-		if err != nil {
+		if err := sandbox.Delete(); err != nil {
 			logrus.WithError(err).WithField("sandbox", sid).Warnf("failed to delete sandbox")
-			return err
+			errs = append(errs, err)
 		}
 	}
 
+	if len(errs) != 0 {
+		return &cleanupErrors{errs: errs}
+	}
+
 	return nil
 }
 
+// maxIDLength is the maximum length of a container ID, like for the
+// containerd identifiers.
+const maxIDLength = 76
+
+// validID matches the container IDs made of alphanumeric components
+// separated by a single '.', '_' or '-'.
+var validID = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
 func validBundle(containerID, bundlePath string) (string, error) {
 	// container ID MUST be provided.
 	if containerID == "" {
-		return "", fmt.Errorf("Missing container ID")
+		return "", errors.Wrap(ErrInvalidContainerID, "missing container ID")
+	}
+
+	if len(containerID) > maxIDLength {
+		return "", errors.Wrapf(ErrInvalidContainerID, "container ID '%s' is longer than %d characters", containerID, maxIDLength)
+	}
+	if !validID.MatchString(containerID) {
+		return "", errors.Wrapf(ErrInvalidContainerID, "container ID '%s' should match %s", containerID, validID)
 	}
 
 	// bundle path MUST be provided.
 	if bundlePath == "" {
-		return "", fmt.Errorf("Missing bundle path")
+		return "", errors.Wrap(ErrInvalidBundle, "missing bundle path")
 	}
 
 	// bundle path MUST be valid.
 	fileInfo, err := os.Stat(bundlePath)
 	if err != nil {
-		return "", fmt.Errorf("Invalid bundle path '%s': %s", bundlePath, err)
+		return "", errors.Wrapf(ErrInvalidBundle, "bundle path '%s': %v", bundlePath, err)
 	}
 	if !fileInfo.IsDir() {
-		return "", fmt.Errorf("Invalid bundle path '%s', it should be a directory", bundlePath)
+		return "", errors.Wrapf(ErrInvalidBundle, "bundle path '%s' should be a directory", bundlePath)
 	}
 
 	resolved, err := katautils.ResolvePath(bundlePath)
@@ -111,6 +303,21 @@ func validBundle(containerID, bundlePath string) (string, error) {
 		return "", err
 	}
 
+	// bundle MUST contain a readable config.json.
+	configPath := filepath.Join(resolved, "config.json")
+	fileInfo, err = os.Stat(configPath)
+	if err != nil {
+		return "", errors.Wrapf(ErrInvalidBundle, "bundle '%s' is missing config.json: %v", bundlePath, err)
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return "", errors.Wrapf(ErrInvalidBundle, "config.json of bundle '%s' should be a regular file", bundlePath)
+	}
+	f, err := os.Open(configPath)
+	if err != nil {
+		return "", errors.Wrapf(ErrInvalidBundle, "cannot read config.json of bundle '%s': %v", bundlePath, err)
+	}
+	f.Close()
+
 	return resolved, nil
 }
 
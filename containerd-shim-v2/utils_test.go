@@ -7,6 +7,7 @@
 package containerdshim
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,12 +17,20 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"testing"
+	"time"
 
+	eventstypes "github.com/containerd/containerd/api/events"
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
 	"github.com/kata-containers/runtime/pkg/katautils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -375,3 +384,571 @@ func readOCIConfigJSON(configFile string) (string, error) {
 	}
 	return string(ociSpecJSON), err
 }
+
+// cleanupSandbox is a vcmock.Sandbox whose cleanup steps all fail.
+type cleanupSandbox struct {
+	vcmock.Sandbox
+}
+
+func (s *cleanupSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{}, errors.New("status failed")
+}
+
+func (s *cleanupSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	return errors.New("kill failed")
+}
+
+func (s *cleanupSandbox) StopContainer(contID string) (vc.VCContainer, error) {
+	return nil, errors.New("stop container failed")
+}
+
+func (s *cleanupSandbox) DeleteContainer(contID string) (vc.VCContainer, error) {
+	return nil, errors.New("delete container failed")
+}
+
+func (s *cleanupSandbox) Stop() error {
+	return errors.New("stop sandbox failed")
+}
+
+func (s *cleanupSandbox) Delete() error {
+	return errors.New("delete sandbox failed")
+}
+
+func TestCleanupContainerAggregateErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	testingImpl.FetchSandboxFunc = func(ctx context.Context, sandboxID string) (vc.VCSandbox, error) {
+		return &cleanupSandbox{vcmock.Sandbox{MockID: sandboxID}}, nil
+	}
+	defer func() {
+		testingImpl.FetchSandboxFunc = nil
+	}()
+
+	bundlePath := filepath.Join(testDir, "cleanup-enoent")
+	ctx := context.Background()
+
+	u := &fakeMounter{errs: []error{syscall.ENOENT}}
+	err := cleanupContainer(ctx, u, realClock{}, testSandboxID, testContainerID, bundlePath)
+	assert.Error(err)
+	assert.Equal([]string{filepath.Join(bundlePath, "rootfs")}, u.targets)
+
+	cErr, ok := err.(*cleanupErrors)
+	assert.True(ok)
+
+	// status, kill, stop container, delete container, rootfs unmount,
+	// stop sandbox and delete sandbox.
+	assert.Len(cErr.Errors(), 7)
+	for _, msg := range []string{"status failed", "kill failed", "stop container failed",
+		"delete container failed", "stop sandbox failed", "delete sandbox failed"} {
+		assert.Contains(err.Error(), msg)
+	}
+}
+
+// teardownSandbox is a vcmock.Sandbox whose container is already stopped,
+// recording the cleanup steps and failing the configured ones.
+type teardownSandbox struct {
+	vcmock.Sandbox
+	steps     []string
+	stopErr   error
+	deleteErr error
+}
+
+func (s *teardownSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	return vc.ContainerStatus{
+		ID:    contID,
+		State: types.ContainerState{State: types.StateStopped},
+	}, nil
+}
+
+func (s *teardownSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	s.steps = append(s.steps, "kill")
+	return nil
+}
+
+func (s *teardownSandbox) StopContainer(contID string) (vc.VCContainer, error) {
+	s.steps = append(s.steps, "stop container")
+	return &vcmock.Container{}, nil
+}
+
+func (s *teardownSandbox) DeleteContainer(contID string) (vc.VCContainer, error) {
+	s.steps = append(s.steps, "delete container")
+	return &vcmock.Container{}, nil
+}
+
+func (s *teardownSandbox) Stop() error {
+	s.steps = append(s.steps, "stop sandbox")
+	return s.stopErr
+}
+
+func (s *teardownSandbox) Delete() error {
+	s.steps = append(s.steps, "delete sandbox")
+	return s.deleteErr
+}
+
+func TestCleanupContainerPartialTeardown(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		testingImpl.FetchSandboxFunc = nil
+	}()
+
+	allSteps := []string{"stop container", "delete container", "stop sandbox", "delete sandbox"}
+
+	data := []struct {
+		unmountErr error
+		stopErr    error
+		deleteErr  error
+		errCount   int
+	}{
+		{nil, nil, nil, 0},
+		{syscall.EPERM, nil, nil, 1},
+		{nil, errors.New("stop sandbox failed"), nil, 1},
+		{nil, nil, errors.New("delete sandbox failed"), 1},
+		{syscall.EPERM, errors.New("stop sandbox failed"), errors.New("delete sandbox failed"), 3},
+	}
+
+	for i, d := range data {
+		sandbox := &teardownSandbox{
+			Sandbox:   vcmock.Sandbox{MockID: testSandboxID},
+			stopErr:   d.stopErr,
+			deleteErr: d.deleteErr,
+		}
+		testingImpl.FetchSandboxFunc = func(ctx context.Context, sandboxID string) (vc.VCSandbox, error) {
+			return sandbox, nil
+		}
+
+		u := &fakeMounter{}
+		if d.unmountErr != nil {
+			u.errs = []error{d.unmountErr}
+		}
+
+		err := cleanupContainer(context.Background(), u, realClock{}, testSandboxID, testContainerID, testDir)
+
+		// the container is stopped, it is not killed, and every
+		// teardown step is attempted.
+		assert.Equal(allSteps, sandbox.steps, "test %d", i)
+		assert.Equal([]string{filepath.Join(testDir, "rootfs")}, u.targets, "test %d", i)
+		assert.Equal([]int{0}, u.flags, "test %d", i)
+
+		if d.errCount == 0 {
+			assert.NoError(err, "test %d", i)
+			continue
+		}
+
+		cErr, ok := err.(*cleanupErrors)
+		assert.True(ok, "test %d", i)
+		assert.Len(cErr.Errors(), d.errCount, "test %d", i)
+	}
+}
+
+func TestCReapBufferedExits(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:          testSandboxID,
+		pid:         uint32(os.Getpid()),
+		events:      make(chan interface{}, chSize),
+		ec:          make(chan exit),
+		bufferExits: true,
+	}
+
+	// ec is unbuffered and nobody reads it: cReap must not block
+	cReap(s, 1, testContainerID, "", time.Now())
+	cReap(s, 2, testContainerID, "exec1", time.Now())
+
+	go s.processExits()
+
+	for _, expected := range []struct {
+		id     string
+		status uint32
+	}{
+		{testContainerID, 1},
+		{"exec1", 2},
+	} {
+		select {
+		case e := <-s.events:
+			taskExit, ok := e.(*eventstypes.TaskExit)
+			assert.True(ok)
+			assert.Equal(testContainerID, taskExit.ContainerID)
+			assert.Equal(expected.id, taskExit.ID)
+			assert.Equal(expected.status, taskExit.ExitStatus)
+		case <-time.After(5 * time.Second):
+			t.Fatal("buffered exit not replayed")
+		}
+	}
+
+	// once the consumer is attached, exits go through ec
+	cReap(s, 3, testContainerID, "", time.Now())
+
+	select {
+	case e := <-s.events:
+		taskExit, ok := e.(*eventstypes.TaskExit)
+		assert.True(ok)
+		assert.Equal(uint32(3), taskExit.ExitStatus)
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit not forwarded")
+	}
+}
+
+func TestCReapBufferedExitsCap(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		ec:          make(chan exit),
+		bufferExits: true,
+	}
+
+	for i := 0; i < maxBufferedExits+2; i++ {
+		cReap(s, i, testContainerID, "", time.Now())
+	}
+
+	assert.Len(s.bufferedExits, maxBufferedExits)
+	assert.Equal(2, s.droppedExits)
+
+	exits := s.attachExitConsumer()
+	assert.Len(exits, maxBufferedExits)
+	assert.Equal(0, exits[0].status)
+	assert.Empty(s.bufferedExits)
+}
+
+// pollClock fires the deadline of After once enough time has been slept.
+type pollClock struct {
+	now      time.Time
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (c *pollClock) Now() time.Time {
+	return c.now
+}
+
+func (c *pollClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	if c.ch != nil && !c.now.Before(c.deadline) {
+		c.ch <- c.now
+		c.ch = nil
+	}
+}
+
+func (c *pollClock) After(d time.Duration) <-chan time.Time {
+	c.deadline = c.now.Add(d)
+	c.ch = make(chan time.Time, 1)
+	return c.ch
+}
+
+// stopSignalSandbox is a vcmock.Sandbox whose container stops a few polls
+// after receiving one of the stop signals, recording the signals sent and
+// when they were sent.
+type stopSignalSandbox struct {
+	vcmock.Sandbox
+	clk         clock
+	annotations map[string]string
+	stopsOn     []syscall.Signal
+	stopAfter   int
+	signals     []syscall.Signal
+	sentAt      []time.Time
+	stopping    bool
+	stopped     bool
+}
+
+func (s *stopSignalSandbox) StatusContainer(contID string) (vc.ContainerStatus, error) {
+	state := types.StateRunning
+	if s.stopping {
+		if s.stopAfter == 0 {
+			s.stopped = true
+		}
+		s.stopAfter--
+	}
+	if s.stopped {
+		state = types.StateStopped
+	}
+
+	return vc.ContainerStatus{
+		ID:          contID,
+		State:       types.ContainerState{State: state},
+		Annotations: s.annotations,
+	}, nil
+}
+
+func (s *stopSignalSandbox) KillContainer(contID string, signal syscall.Signal, all bool) error {
+	s.signals = append(s.signals, signal)
+	s.sentAt = append(s.sentAt, s.clk.Now())
+
+	for _, sig := range s.stopsOn {
+		if sig == signal {
+			s.stopping = true
+		}
+	}
+
+	return nil
+}
+
+func stopAnnotations(t *testing.T, annotations map[string]string) map[string]string {
+	spec := oci.CompatOCISpec{}
+	spec.Annotations = annotations
+
+	config, err := json.Marshal(spec)
+	assert.NoError(t, err)
+
+	return map[string]string{vcAnnotations.ConfigJSONKey: string(config)}
+}
+
+func TestStopTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		annotations map[string]string
+		timeout     time.Duration
+	}{
+		// absent
+		{nil, defaultStopTimeout},
+		{map[string]string{vcAnnotations.StopSignal: "SIGUSR1"}, defaultStopTimeout},
+		// present
+		{map[string]string{vcAnnotations.StopTimeout: "30"}, 30 * time.Second},
+		{map[string]string{vcAnnotations.StopTimeout: "0"}, 0},
+		// invalid
+		{map[string]string{vcAnnotations.StopTimeout: "-1"}, defaultStopTimeout},
+		{map[string]string{vcAnnotations.StopTimeout: "10s"}, defaultStopTimeout},
+		{map[string]string{vcAnnotations.StopTimeout: ""}, defaultStopTimeout},
+	}
+
+	for i, d := range data {
+		assert.Equal(d.timeout, stopTimeout(testContainerID, d.annotations), "test %d", i)
+	}
+}
+
+func TestStopGracefully(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		annotations map[string]string
+		stopsOn     []syscall.Signal
+		signals     []syscall.Signal
+		timeout     time.Duration
+	}{
+		// the container stops on SIGTERM, it is not killed
+		{nil, []syscall.Signal{syscall.SIGTERM}, []syscall.Signal{syscall.SIGTERM}, defaultStopTimeout},
+		// the container ignores SIGTERM, it is killed after the grace period
+		{nil, nil, []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, defaultStopTimeout},
+		// the stop signal and grace period of the spec are used
+		{
+			map[string]string{vcAnnotations.StopSignal: "SIGUSR1", vcAnnotations.StopTimeout: "3"},
+			[]syscall.Signal{syscall.SIGUSR1},
+			[]syscall.Signal{syscall.SIGUSR1},
+			3 * time.Second,
+		},
+		{
+			map[string]string{vcAnnotations.StopSignal: "QUIT", vcAnnotations.StopTimeout: "3"},
+			[]syscall.Signal{syscall.SIGTERM},
+			[]syscall.Signal{syscall.SIGQUIT, syscall.SIGKILL},
+			3 * time.Second,
+		},
+		// a SIGKILL stop signal kills the container at once
+		{
+			map[string]string{vcAnnotations.StopSignal: "9"},
+			nil,
+			[]syscall.Signal{syscall.SIGKILL},
+			0,
+		},
+		// invalid values fall back to the defaults
+		{
+			map[string]string{vcAnnotations.StopSignal: "SIGFOO", vcAnnotations.StopTimeout: "-1"},
+			nil,
+			[]syscall.Signal{syscall.SIGTERM, syscall.SIGKILL},
+			defaultStopTimeout,
+		},
+	}
+
+	for i, d := range data {
+		clk := &pollClock{}
+		sandbox := &stopSignalSandbox{
+			Sandbox:     vcmock.Sandbox{MockID: testSandboxID},
+			clk:         clk,
+			annotations: stopAnnotations(t, d.annotations),
+			stopsOn:     d.stopsOn,
+			stopAfter:   2,
+		}
+
+		status, err := sandbox.StatusContainer(testContainerID)
+		assert.NoError(err)
+
+		err = stopGracefully(sandbox, clk, testContainerID, status)
+		assert.NoError(err, "test %d", i)
+		assert.Equal(d.signals, sandbox.signals, "test %d", i)
+
+		// SIGKILL only fires once the grace period is over
+		for j, sig := range sandbox.signals {
+			if sig == syscall.SIGKILL {
+				assert.Equal(d.timeout, sandbox.sentAt[j].Sub(time.Time{}), "test %d", i)
+			} else {
+				assert.True(sandbox.sentAt[j].IsZero(), "test %d", i)
+			}
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		value  string
+		signal syscall.Signal
+		valid  bool
+	}{
+		{"SIGTERM", syscall.SIGTERM, true},
+		{"TERM", syscall.SIGTERM, true},
+		{"sigint", syscall.SIGINT, true},
+		{"15", syscall.SIGTERM, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"SIGFOO", 0, false},
+		{"100", 0, false},
+	}
+
+	for _, d := range data {
+		signal, err := parseSignal(d.value)
+		if !d.valid {
+			assert.Error(err, "value %q", d.value)
+			continue
+		}
+
+		assert.NoError(err, "value %q", d.value)
+		assert.Equal(d.signal, signal, "value %q", d.value)
+	}
+}
+
+// writeHook writes a hook script appending its name, its environment and
+// the state it is given to the log file, after sleeping for the delay.
+func writeHook(t *testing.T, dir, name, logFile string, delay int) specs.Hook {
+	hookPath := filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\nsleep %d\necho \"%s $HOOK_ENV $(cat)\" >> %s\n", delay, name, logFile)
+
+	err := ioutil.WriteFile(hookPath, []byte(script), 0700)
+	assert.NoError(t, err)
+
+	return specs.Hook{
+		Path: hookPath,
+		Args: []string{hookPath},
+		Env:  []string{"HOOK_ENV=" + name + "-env"},
+	}
+}
+
+func TestCleanupContainerPostStopHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		testingImpl.FetchSandboxFunc = nil
+	}()
+
+	testingImpl.FetchSandboxFunc = func(ctx context.Context, sandboxID string) (vc.VCSandbox, error) {
+		return &teardownSandbox{Sandbox: vcmock.Sandbox{MockID: sandboxID}}, nil
+	}
+
+	dir, err := ioutil.TempDir(testDir, "poststop-")
+	assert.NoError(err)
+
+	bundlePath := filepath.Join(dir, "bundle")
+	err = os.MkdirAll(bundlePath, testDirMode)
+	assert.NoError(err)
+
+	logFile := filepath.Join(dir, "hooks.log")
+	timeout := 1
+
+	slow := writeHook(t, dir, "slow", logFile, 5)
+	slow.Timeout = &timeout
+
+	data := []struct {
+		hooks    []specs.Hook
+		expected []string
+	}{
+		// the hooks run in order, with their environment and the
+		// container state
+		{
+			[]specs.Hook{writeHook(t, dir, "first", logFile, 0), writeHook(t, dir, "second", logFile, 0)},
+			[]string{"first first-env", "second second-env"},
+		},
+		// a timed out hook stops the hooks, but not the teardown
+		{
+			[]specs.Hook{writeHook(t, dir, "first", logFile, 0), slow, writeHook(t, dir, "second", logFile, 0)},
+			[]string{"first first-env"},
+		},
+	}
+
+	for i, d := range data {
+		os.Remove(logFile)
+
+		spec := oci.CompatOCISpec{}
+		spec.Process = &oci.CompatOCIProcess{}
+		spec.Hooks = &specs.Hooks{Poststop: d.hooks}
+		err = writeOCIConfigFile(spec, filepath.Join(bundlePath, specConf))
+		assert.NoError(err)
+
+		err = cleanupContainer(context.Background(), &fakeMounter{}, realClock{}, testSandboxID, testContainerID, bundlePath)
+		assert.NoError(err, "test %d", i)
+
+		content, err := ioutil.ReadFile(logFile)
+		assert.NoError(err, "test %d", i)
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		assert.Len(lines, len(d.expected), "test %d", i)
+
+		for j, line := range lines {
+			if j >= len(d.expected) {
+				break
+			}
+
+			assert.True(strings.HasPrefix(line, d.expected[j]+" "), "test %d: %q", i, line)
+
+			var state specs.State
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, d.expected[j]+" ")), &state)
+			assert.NoError(err, "test %d", i)
+			assert.Equal(testSandboxID, state.ID)
+			assert.Equal(bundlePath, state.Bundle)
+		}
+	}
+}
+
+func TestValidBundle(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	err = makeOCIBundle(bundlePath)
+	assert.NoError(err)
+
+	resolved, err := validBundle(testContainerID, bundlePath)
+	assert.NoError(err)
+	assert.Equal(bundlePath, resolved)
+
+	_, err = validBundle(testSandboxID, bundlePath)
+	assert.NoError(err)
+
+	// invalid IDs
+	for _, id := range []string{"", "-foo", "foo.", "foo..bar", "foo/bar", "foo bar", strings.Repeat("a", maxIDLength+1)} {
+		_, err = validBundle(id, bundlePath)
+		assert.Error(err, "id %q", id)
+	}
+
+	// missing bundle
+	_, err = validBundle(testContainerID, filepath.Join(tmpdir, "missing"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "bundle path")
+
+	// missing config.json
+	emptyBundle := filepath.Join(tmpdir, "empty")
+	err = os.Mkdir(emptyBundle, testDirMode)
+	assert.NoError(err)
+	_, err = validBundle(testContainerID, emptyBundle)
+	assert.Error(err)
+	assert.Contains(err.Error(), "missing config.json")
+
+	// config.json is not a file
+	err = os.Mkdir(filepath.Join(emptyBundle, specConf), testDirMode)
+	assert.NoError(err)
+	_, err = validBundle(testContainerID, emptyBundle)
+	assert.Error(err)
+	assert.Contains(err.Error(), "should be a regular file")
+}
@@ -6,6 +6,8 @@
 package containerdshim
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/containerd/containerd/api/types/task"
@@ -16,6 +18,10 @@ func wait(s *service, c *container, execID string) (int32, error) {
 	var execs *exec
 	var err error
 
+	var ret int32
+	var timeout <-chan time.Time
+	timedOut := false
+
 	processID := c.id
 
 	if execID == "" {
@@ -26,14 +32,31 @@ func wait(s *service, c *container, execID string) (int32, error) {
 		if err != nil {
 			return exitCode255, err
 		}
-		<-execs.exitIOch
+
+		// The guest process of an exec can be lost, e.g on agent
+		// restart, don't wait for it forever if a timeout is set.
+		if s.execWaitTimeout > 0 {
+			timeout = s.getClock().After(s.execWaitTimeout)
+		}
+
+		select {
+		case <-execs.exitIOch:
+		case <-timeout:
+			timedOut = true
+		}
 		//This wait could be triggered before exec start which
 		//will get the exec's id, thus this assignment must after
 		//the exec exit, to make sure it get the exec's id.
+		c.mu.Lock()
 		processID = execs.id
+		c.mu.Unlock()
 	}
 
-	ret, err := s.sandbox.WaitProcess(c.id, processID)
+	if timedOut {
+		ret, err = execTimeoutExitCode, fmt.Errorf("exec %s IO not closed after %v", execID, s.execWaitTimeout)
+	} else {
+		ret, err = waitProcess(s, c.id, processID, timeout)
+	}
 	if err != nil {
 		logrus.WithError(err).WithFields(logrus.Fields{
 			"container": c.id,
@@ -41,26 +64,143 @@ func wait(s *service, c *container, execID string) (int32, error) {
 		}).Error("Wait for process failed")
 	}
 
-	timeStamp := time.Now()
+	processExited(s, c, execs, execID, ret)
+
+	return ret, nil
+}
+
+// processExited records the exit of the container init process, or of the
+// exec if execID is set, notifies its waiters and reaps it.
+func processExited(s *service, c *container, execs *exec, execID string, ret int32) {
+	timeStamp := s.getClock().Now()
+	var waiters []*exitWaiter
 	c.mu.Lock()
 	if execID == "" {
 		c.status = task.StatusStopped
 		c.exit = uint32(ret)
 		c.exitTime = timeStamp
+		c.attached = false
+		waiters, c.exitWaiters = c.exitWaiters, nil
 	} else {
+		execs.attached = false
 		execs.status = task.StatusStopped
 		execs.exitCode = ret
 		execs.exitTime = timeStamp
 	}
 	c.mu.Unlock()
 
+	s.rememberExit(c.id, execID, uint32(ret), timeStamp)
+
 	if execID == "" {
-		c.exitCh <- uint32(ret)
+		notifyExit(waiters, newExit(s, int(ret), c.id, execID, timeStamp))
 	} else {
 		execs.exitCh <- uint32(ret)
 	}
 
 	go cReap(s, int(ret), c.id, execID, timeStamp)
+}
 
-	return ret, nil
+// waitProcess waits for the process to exit, giving up when timeout fires
+// or when the service is shut down. A nil timeout never fires.
+func waitProcess(s *service, containerID, processID string, timeout <-chan time.Time) (int32, error) {
+	ctx, cancel := context.WithCancel(s.getContext())
+	defer cancel()
+
+	timedOut := make(chan struct{})
+	if timeout != nil {
+		go func() {
+			select {
+			case <-timeout:
+				close(timedOut)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	ret, err := s.sandbox.WaitProcessWithContext(ctx, containerID, processID)
+	if err == nil || ctx.Err() == nil {
+		return ret, err
+	}
+
+	select {
+	case <-timedOut:
+		return execTimeoutExitCode, fmt.Errorf("process %s not exited after %v", processID, s.execWaitTimeout)
+	default:
+		return exitCode255, fmt.Errorf("wait for process %s interrupted: %v", processID, err)
+	}
+}
+
+// exitWaiter is a caller of WaitContainer waiting for the container exit.
+type exitWaiter struct {
+	ch chan exit
+	// notified is closed once the exit is sent to ch.
+	notified chan struct{}
+}
+
+// WaitContainer returns a channel receiving the exit of the container init
+// process, without blocking the caller, Wait blocking on it. Any number of callers
+// can wait for the same container, each channel is closed once the exit is
+// sent. If ctx is done before the container exits, the channel is closed
+// without any exit.
+func (s *service) WaitContainer(ctx context.Context, containerID string) (<-chan exit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := s.getContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// buffered for the exit to be sent without blocking the reaper
+	w := &exitWaiter{
+		ch:       make(chan exit, 1),
+		notified: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status == task.StatusStopped {
+		notifyExit([]*exitWaiter{w}, newExit(s, int(c.exit), c.id, "", c.exitTime))
+		return w.ch, nil
+	}
+
+	c.exitWaiters = append(c.exitWaiters, w)
+
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				c.removeExitWaiter(w)
+			case <-w.notified:
+			}
+		}()
+	}
+
+	return w.ch, nil
+}
+
+// removeExitWaiter closes the channel of a waiter that gave up, unless the
+// exit was already sent to it.
+func (c *container) removeExitWaiter(w *exitWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, waiter := range c.exitWaiters {
+		if waiter == w {
+			c.exitWaiters = append(c.exitWaiters[:i], c.exitWaiters[i+1:]...)
+			close(w.ch)
+			return
+		}
+	}
+}
+
+// notifyExit sends the exit to the waiters and closes their channels.
+func notifyExit(waiters []*exitWaiter, e exit) {
+	for _, w := range waiters {
+		w.ch <- e
+		close(w.ch)
+		close(w.notified)
+	}
 }
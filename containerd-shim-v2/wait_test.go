@@ -0,0 +1,358 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckSandbox never sees its processes exit, until released.
+type stuckSandbox struct {
+	vcmock.Sandbox
+	release chan struct{}
+}
+
+func (s *stuckSandbox) WaitProcessWithContext(ctx context.Context, containerID, processID string) (int32, error) {
+	select {
+	case <-s.release:
+		return 0, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func testWaitStuckExec(t *testing.T, closeIO bool, timeout time.Duration) (int32, *exec, *service) {
+	assert := assert.New(t)
+
+	sandbox := &stuckSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		release: make(chan struct{}),
+	}
+	defer close(sandbox.release)
+
+	s := &service{
+		id:              testSandboxID,
+		sandbox:         sandbox,
+		containers:      make(map[string]*container),
+		ec:              make(chan exit, 1),
+		clock:           &fakeClock{now: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)},
+		execWaitTimeout: timeout,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	execs := &exec{
+		container: c,
+		id:        "exec-token",
+		exitIOch:  make(chan struct{}),
+		exitCh:    make(chan uint32, 1),
+		status:    task.StatusRunning,
+	}
+	if closeIO {
+		close(execs.exitIOch)
+	}
+	c.execs[TestID] = execs
+
+	ret, err := wait(s, c, TestID)
+	assert.NoError(err)
+
+	return ret, execs, s
+}
+
+func TestWaitExecTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// the guest process is lost, or its IO is stuck
+	for _, closeIO := range []bool{true, false} {
+		ret, execs, s := testWaitStuckExec(t, closeIO, time.Minute)
+		assert.Equal(int32(execTimeoutExitCode), ret)
+		assert.Equal(task.StatusStopped, execs.status)
+		assert.Equal(int32(execTimeoutExitCode), execs.exitCode)
+		assert.Equal(uint32(execTimeoutExitCode), <-execs.exitCh)
+
+		select {
+		case e := <-s.ec:
+			assert.Equal(testContainerID, e.id)
+			assert.Equal(TestID, e.execid)
+			assert.Equal(execTimeoutExitCode, e.status)
+			assert.Equal(s.clock.Now(), e.timestamp)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no synthetic exit reaped")
+		}
+	}
+}
+
+func TestWaitExecNoTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	execs := &exec{
+		container: c,
+		exitIOch:  make(chan struct{}),
+		exitCh:    make(chan uint32, 1),
+	}
+	close(execs.exitIOch)
+	c.execs[TestID] = execs
+
+	ret, err := wait(s, c, TestID)
+	assert.NoError(err)
+	assert.Equal(int32(0), ret)
+
+	e := <-s.ec
+	assert.Equal(0, e.status)
+}
+
+func TestWaitShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &stuckSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		release: make(chan struct{}),
+	}
+	defer close(sandbox.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+		ctx:        ctx,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	close(c.exitIOch)
+
+	type result struct {
+		ret int32
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ret, err := wait(s, c, "")
+		done <- result{ret, err}
+	}()
+
+	// the guest process never exits
+	select {
+	case <-done:
+		t.Fatal("wait returned before the service was shut down")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case r := <-done:
+		assert.NoError(r.err)
+		assert.Equal(int32(exitCode255), r.ret)
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait didn't return once the service was shut down")
+	}
+
+	assert.Equal(task.StatusStopped, c.status)
+	assert.Equal(uint32(exitCode255), c.exit)
+}
+
+func TestWaitContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &stuckSandbox{
+		Sandbox: vcmock.Sandbox{MockID: testSandboxID},
+		release: make(chan struct{}),
+	}
+
+	clk := &fakeClock{now: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)}
+	s := &service{
+		id:         testSandboxID,
+		pid:        1234,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+		clock:      clk,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	s.containers[testContainerID] = c
+	close(c.exitIOch)
+
+	ctx := context.Background()
+
+	_, err = s.WaitContainer(ctx, "unknown")
+	assert.Error(err)
+
+	first, err := s.WaitContainer(ctx, testContainerID)
+	assert.NoError(err)
+	second, err := s.WaitContainer(ctx, testContainerID)
+	assert.NoError(err)
+
+	// a waiter giving up doesn't get the exit
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancelled, err := s.WaitContainer(cancelledCtx, testContainerID)
+	assert.NoError(err)
+	cancel()
+
+	select {
+	case _, ok := <-cancelled:
+		assert.False(ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled waiter channel wasn't closed")
+	}
+
+	// the waiters don't block
+	select {
+	case <-first:
+		t.Fatal("the container exit was received before it exited")
+	default:
+	}
+
+	close(sandbox.release)
+	_, err = wait(s, c, "")
+	assert.NoError(err)
+
+	for _, ch := range []<-chan exit{first, second} {
+		select {
+		case e, ok := <-ch:
+			assert.True(ok)
+			assert.Equal(testContainerID, e.id)
+			assert.Equal("", e.execid)
+			assert.Equal(uint32(1234), e.pid)
+			assert.Equal(0, e.status)
+			assert.Equal(clk.now, e.timestamp)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the container exit wasn't received")
+		}
+
+		_, ok := <-ch
+		assert.False(ok)
+	}
+
+	// waiting for an exited container returns its exit right away
+	late, err := s.WaitContainer(ctx, testContainerID)
+	assert.NoError(err)
+	e, ok := <-late
+	assert.True(ok)
+	assert.Equal(testContainerID, e.id)
+	assert.Equal(clk.now, e.timestamp)
+	_, ok = <-late
+	assert.False(ok)
+}
+
+// lostSandbox fails waiting for its processes, as when the agent
+// connection is lost.
+type lostSandbox struct {
+	vcmock.Sandbox
+}
+
+func (s *lostSandbox) WaitProcessWithContext(ctx context.Context, containerID, processID string) (int32, error) {
+	return exitCode255, errors.New("agent connection lost")
+}
+
+func TestWaitLostContainerProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &lostSandbox{Sandbox: vcmock.Sandbox{MockID: testSandboxID}},
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	c.status = task.StatusRunning
+	s.containers[testContainerID] = c
+	close(c.exitIOch)
+
+	// the process is reported exited with 255
+	ret, err := wait(s, c, "")
+	assert.NoError(err)
+	assert.Equal(int32(exitCode255), ret)
+	assert.Equal(uint32(exitCode255), c.exit)
+
+	c.mu.Lock()
+	assert.Equal(task.StatusStopped, c.status)
+	c.mu.Unlock()
+
+	select {
+	case e := <-s.ec:
+		assert.Equal(testContainerID, e.id)
+		assert.Equal(exitCode255, e.status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exit wasn't reaped")
+	}
+}
+
+func TestServiceWaitContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := &fakeClock{now: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)}
+	s := &service{
+		id:         testSandboxID,
+		containers: make(map[string]*container),
+		ec:         make(chan exit, 1),
+		clock:      clk,
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testContainerID}, "", nil)
+	assert.NoError(err)
+	c.status = task.StatusRunning
+	s.containers[testContainerID] = c
+
+	// a cancelled wait returns before the container exits
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Wait(ctx, &taskAPI.WaitRequest{ID: testContainerID})
+	assert.Error(err)
+
+	done := make(chan *taskAPI.WaitResponse, 1)
+	go func() {
+		resp, err := s.Wait(context.Background(), &taskAPI.WaitRequest{ID: testContainerID})
+		assert.NoError(err)
+		done <- resp
+	}()
+
+	// wait for the Wait call to be registered as a waiter
+	for i := 0; i < 50; i++ {
+		c.mu.Lock()
+		waiting := len(c.exitWaiters) > 0
+		c.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	processExited(s, c, nil, "", 3)
+
+	select {
+	case resp := <-done:
+		assert.Equal(uint32(3), resp.ExitStatus)
+		assert.Equal(clk.now, resp.ExitedAt)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return once the container exited")
+	}
+}
@@ -10,8 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	goruntime "runtime"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	vc "github.com/kata-containers/runtime/virtcontainers"
@@ -120,8 +122,11 @@ type hypervisor struct {
 }
 
 type proxy struct {
-	Path  string `toml:"path"`
-	Debug bool   `toml:"enable_debug"`
+	Path              string `toml:"path"`
+	Debug             bool   `toml:"enable_debug"`
+	ForwardLogs       bool   `toml:"forward_logs"`
+	StopGracePeriod   uint32 `toml:"stop_grace_period"`
+	SocketWaitTimeout uint32 `toml:"socket_wait_timeout"`
 }
 
 type runtime struct {
@@ -129,6 +134,19 @@ type runtime struct {
 	Tracing             bool     `toml:"enable_tracing"`
 	DisableNewNetNs     bool     `toml:"disable_new_netns"`
 	DisableGuestSeccomp bool     `toml:"disable_guest_seccomp"`
+	EphemeralStorageMax uint64   `toml:"ephemeral_storage_max_size"`
+	SyncGuestTime       bool     `toml:"sync_guest_time"`
+	OutputLogDir        string   `toml:"output_log_dir"`
+	StopConfirmation    string   `toml:"stop_confirmation"`
+	LogRateLimit        uint32   `toml:"log_rate_limit"`
+	TerminalHeight      uint32   `toml:"exec_terminal_height"`
+	TerminalWidth       uint32   `toml:"exec_terminal_width"`
+	ExecWaitTimeout     uint32   `toml:"exec_wait_timeout"`
+	IOBufferSize        uint32   `toml:"io_buffer_size"`
+	MaxExecs            uint32   `toml:"max_execs"`
+	DrainTimeout        uint32   `toml:"drain_timeout"`
+	ProxyTypeAnnotation bool     `toml:"allow_proxy_type_annotation"`
+	StrictUnmount       bool     `toml:"strict_unmount"`
 	Experimental        []string `toml:"experimental"`
 	InterNetworkModel   string   `toml:"internetworking_model"`
 }
@@ -394,6 +412,14 @@ func (p proxy) debug() bool {
 	return p.Debug
 }
 
+func (p proxy) stopGracePeriod() time.Duration {
+	return time.Duration(p.StopGracePeriod) * time.Second
+}
+
+func (p proxy) socketWaitTimeout() time.Duration {
+	return time.Duration(p.SocketWaitTimeout) * time.Second
+}
+
 func (s shim) path() (string, error) {
 	p := s.Path
 
@@ -674,8 +700,10 @@ func updateRuntimeConfigProxy(configPath string, tomlConf tomlConfig, config *oc
 		}
 
 		config.ProxyConfig = vc.ProxyConfig{
-			Path:  path,
-			Debug: proxy.debug(),
+			Path:              path,
+			Debug:             proxy.debug(),
+			StopGracePeriod:   proxy.stopGracePeriod(),
+			SocketWaitTimeout: proxy.socketWaitTimeout(),
 		}
 	}
 
@@ -941,12 +969,40 @@ func LoadConfiguration(configPath string, ignoreLogging, builtIn bool) (resolved
 	}
 
 	config.DisableGuestSeccomp = tomlConf.Runtime.DisableGuestSeccomp
+	config.EphemeralStorageMaxSize = tomlConf.Runtime.EphemeralStorageMax
+	config.SyncGuestTime = tomlConf.Runtime.SyncGuestTime
+
+	if dir := tomlConf.Runtime.OutputLogDir; dir != "" && !filepath.IsAbs(dir) {
+		return "", config, fmt.Errorf("Invalid output_log_dir %q: it should be an absolute path", dir)
+	}
+	config.OutputLogDir = tomlConf.Runtime.OutputLogDir
+
+	switch tomlConf.Runtime.StopConfirmation {
+	case "", "agent", "hypervisor":
+	default:
+		return "", config, fmt.Errorf("Invalid stop_confirmation %q: it should be \"agent\" or \"hypervisor\"", tomlConf.Runtime.StopConfirmation)
+	}
+	config.StopConfirmation = tomlConf.Runtime.StopConfirmation
+
+	config.LogRateLimit = tomlConf.Runtime.LogRateLimit
+	config.TerminalHeight = tomlConf.Runtime.TerminalHeight
+	config.TerminalWidth = tomlConf.Runtime.TerminalWidth
+	config.ExecWaitTimeout = tomlConf.Runtime.ExecWaitTimeout
+	config.IOBufferSize = tomlConf.Runtime.IOBufferSize
+	config.MaxExecs = tomlConf.Runtime.MaxExecs
+	config.DrainTimeout = tomlConf.Runtime.DrainTimeout
+
+	config.AllowProxyTypeAnnotation = tomlConf.Runtime.ProxyTypeAnnotation
+
+	config.StrictUnmount = tomlConf.Runtime.StrictUnmount
 
 	// use no proxy if HypervisorConfig.UseVSock is true
 	if config.HypervisorConfig.UseVSock {
 		kataUtilsLogger.Info("VSOCK supported, configure to not use proxy")
 		config.ProxyType = vc.NoProxyType
-		config.ProxyConfig = vc.ProxyConfig{}
+		config.ProxyConfig = vc.ProxyConfig{
+			ForwardLogs: tomlConf.Proxy[kataProxyTableType].ForwardLogs,
+		}
 	}
 
 	config.DisableNewNetNs = tomlConf.Runtime.DisableNewNetNs
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
@@ -1152,6 +1153,14 @@ func TestProxyDefaults(t *testing.T) {
 	assert.False(p.debug())
 	p.Debug = true
 	assert.True(p.debug())
+
+	assert.Zero(p.stopGracePeriod())
+	p.StopGracePeriod = 10
+	assert.Equal(10*time.Second, p.stopGracePeriod())
+
+	assert.Zero(p.socketWaitTimeout())
+	p.SocketWaitTimeout = 5
+	assert.Equal(5*time.Second, p.socketWaitTimeout())
 }
 
 func TestShimDefaults(t *testing.T) {
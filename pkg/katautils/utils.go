@@ -8,6 +8,7 @@ package katautils
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
@@ -17,6 +18,15 @@ import (
 	"syscall"
 )
 
+// Errors returned by ResolvePath, to be checked with errors.Cause().
+var (
+	// ErrEmptyPath is returned when no path has been specified.
+	ErrEmptyPath = errors.New("path must be specified")
+
+	// ErrPathNotExist is returned when the path doesn't exist.
+	ErrPathNotExist = errors.New("does not exist")
+)
+
 // FileExists test is a file exiting or not
 func FileExists(path string) bool {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -30,7 +40,7 @@ func FileExists(path string) bool {
 // specified path.
 func ResolvePath(path string) (string, error) {
 	if path == "" {
-		return "", fmt.Errorf("path must be specified")
+		return "", ErrEmptyPath
 	}
 
 	absolute, err := filepath.Abs(path)
@@ -42,7 +52,7 @@ func ResolvePath(path string) (string, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Make the error clearer than the default
-			return "", fmt.Errorf("file %v does not exist", absolute)
+			return "", errors.Wrapf(ErrPathNotExist, "file %v", absolute)
 		}
 
 		return "", err
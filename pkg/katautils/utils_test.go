@@ -7,7 +7,6 @@
 package katautils
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -19,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -204,6 +204,32 @@ func createEmptyFile(path string) (err error) {
 func TestUtilsResolvePathEmptyPath(t *testing.T) {
 	_, err := ResolvePath("")
 	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == ErrEmptyPath)
+	assert.False(t, errors.Cause(err) == ErrPathNotExist)
+	assert.Equal(t, "path must be specified", err.Error())
+}
+
+func TestUtilsResolvePathNotExist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := path.Join(dir, "missing")
+	_, err = ResolvePath(missing)
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == ErrPathNotExist)
+	assert.False(t, errors.Cause(err) == ErrEmptyPath)
+	assert.Equal(t, fmt.Sprintf("file %v: does not exist", missing), err.Error())
+
+	// dangling symlink
+	link := path.Join(dir, "link")
+	err = syscall.Symlink(missing, link)
+	assert.NoError(t, err)
+
+	_, err = ResolvePath(link)
+	assert.True(t, errors.Cause(err) == ErrPathNotExist)
 }
 
 func TestUtilsResolvePathValidPath(t *testing.T) {
@@ -7,17 +7,22 @@ package virtcontainers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"syscall"
 
 	deviceApi "github.com/kata-containers/runtime/virtcontainers/device/api"
 	deviceConfig "github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -300,18 +305,7 @@ func ListSandbox(ctx context.Context) ([]SandboxStatus, error) {
 	span, ctx := trace(ctx, "ListSandbox")
 	defer span.Finish()
 
-	dir, err := os.Open(store.ConfigStoragePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// No sandbox directory is not an error
-			return []SandboxStatus{}, nil
-		}
-		return []SandboxStatus{}, err
-	}
-
-	defer dir.Close()
-
-	sandboxesID, err := dir.Readdirnames(0)
+	sandboxesID, err := storedSandboxes()
 	if err != nil {
 		return []SandboxStatus{}, err
 	}
@@ -330,6 +324,89 @@ func ListSandbox(ctx context.Context) ([]SandboxStatus, error) {
 	return sandboxStatusList, nil
 }
 
+// ListSandboxes is the virtcontainers sandboxes listing entry point.
+// Unlike ListSandbox, it only loads the stored state of each sandbox,
+// without fetching the sandbox. The entries without any configuration or
+// with a missing or corrupt state are skipped.
+func ListSandboxes(ctx context.Context) ([]SandboxListEntry, error) {
+	span, ctx := trace(ctx, "ListSandboxes")
+	defer span.Finish()
+
+	sandboxesID, err := storedSandboxes()
+	if err != nil {
+		return []SandboxListEntry{}, err
+	}
+
+	sandboxes := []SandboxListEntry{}
+
+	for _, sandboxID := range sandboxesID {
+		state, err := loadSandboxState(ctx, sandboxID)
+		if err != nil {
+			virtLog.WithField("sandbox", sandboxID).WithError(err).Warn("skipping sandbox with invalid state")
+			continue
+		}
+
+		sandboxes = append(sandboxes, SandboxListEntry{
+			ID:    sandboxID,
+			State: state,
+		})
+	}
+
+	return sandboxes, nil
+}
+
+// storedSandboxes returns the IDs of the sandboxes found in the store.
+func storedSandboxes() ([]string, error) {
+	entries, err := ioutil.ReadDir(store.ConfigStoragePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No sandbox directory is not an error
+			return []string{}, nil
+		}
+		return []string{}, err
+	}
+
+	var sandboxesID []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			sandboxesID = append(sandboxesID, entry.Name())
+		}
+	}
+
+	return sandboxesID, nil
+}
+
+// loadSandboxState loads the stored state of the sandbox, under the sandbox
+// read lock.
+func loadSandboxState(ctx context.Context, sandboxID string) (types.SandboxState, error) {
+	lockFile, err := rLockSandbox(ctx, sandboxID)
+	if err != nil {
+		return types.SandboxState{}, err
+	}
+	defer unlockSandbox(ctx, sandboxID, lockFile)
+
+	vcStore, err := store.NewVCSandboxStore(ctx, sandboxID)
+	if err != nil {
+		return types.SandboxState{}, err
+	}
+
+	var config SandboxConfig
+	if err := vcStore.Load(store.Configuration, &config); err != nil {
+		return types.SandboxState{}, err
+	}
+
+	state, err := vcStore.LoadState()
+	if err != nil {
+		return types.SandboxState{}, err
+	}
+
+	if !state.Valid() {
+		return types.SandboxState{}, fmt.Errorf("invalid sandbox state %q", state.State)
+	}
+
+	return state, nil
+}
+
 // StatusSandbox is the virtcontainers sandbox status entry point.
 func StatusSandbox(ctx context.Context, sandboxID string) (SandboxStatus, error) {
 	span, ctx := trace(ctx, "StatusSandbox")
@@ -561,6 +638,102 @@ func StatusContainer(ctx context.Context, sandboxID, containerID string) (Contai
 	return statusContainer(s, containerID)
 }
 
+// FetchContainerSpec is the virtcontainers container spec retrieval entry
+// point. It returns the OCI spec the container was created from, loaded from
+// its stored configuration without fetching the sandbox. Unknown sandboxes
+// and containers are reported as vcTypes.ErrNoSuchSandbox and
+// vcTypes.ErrNoSuchContainer.
+func FetchContainerSpec(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error) {
+	span, ctx := trace(ctx, "FetchContainerSpec")
+	defer span.Finish()
+
+	config, err := loadContainerConfig(ctx, sandboxID, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ociSpecJSON, ok := config.Annotations[annotations.ConfigJSONKey]
+	if !ok {
+		return nil, errorMissingOCISpec
+	}
+
+	var spec specs.Spec
+	if err := json.Unmarshal([]byte(ociSpecJSON), &spec); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse the OCI spec of container %s", containerID)
+	}
+
+	return &spec, nil
+}
+
+// GetContainerAnnotations is the virtcontainers container annotations
+// retrieval entry point. It returns the annotations the container was
+// created with, loaded from its stored configuration without fetching the
+// sandbox. Unknown sandboxes and containers are reported as
+// vcTypes.ErrNoSuchSandbox and vcTypes.ErrNoSuchContainer.
+func GetContainerAnnotations(ctx context.Context, sandboxID, containerID string) (map[string]string, error) {
+	span, ctx := trace(ctx, "GetContainerAnnotations")
+	defer span.Finish()
+
+	config, err := loadContainerConfig(ctx, sandboxID, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make(map[string]string, len(config.Annotations))
+	for k, v := range config.Annotations {
+		annotations[k] = v
+	}
+
+	return annotations, nil
+}
+
+// loadContainerConfig loads the stored configuration of the container,
+// under the sandbox read lock.
+func loadContainerConfig(ctx context.Context, sandboxID, containerID string) (ContainerConfig, error) {
+	var config ContainerConfig
+
+	if sandboxID == "" {
+		return config, vcTypes.ErrNeedSandboxID
+	}
+
+	if containerID == "" {
+		return config, vcTypes.ErrNeedContainerID
+	}
+
+	// Creating the stores of an unknown sandbox or container would leave
+	// their layout behind.
+	if _, err := os.Stat(store.SandboxConfigurationRootPath(sandboxID)); err != nil {
+		if os.IsNotExist(err) {
+			return config, errors.Wrapf(vcTypes.ErrNoSuchSandbox, "sandbox %s", sandboxID)
+		}
+		return config, err
+	}
+
+	if _, err := os.Stat(store.ContainerConfigurationRootPath(sandboxID, containerID)); err != nil {
+		if os.IsNotExist(err) {
+			return config, errors.Wrapf(vcTypes.ErrNoSuchContainer, "container %s of sandbox %s", containerID, sandboxID)
+		}
+		return config, err
+	}
+
+	lockFile, err := rLockSandbox(ctx, sandboxID)
+	if err != nil {
+		return config, err
+	}
+	defer unlockSandbox(ctx, sandboxID, lockFile)
+
+	vcStore, err := store.NewVCContainerStore(ctx, sandboxID, containerID)
+	if err != nil {
+		return config, err
+	}
+
+	if err := vcStore.Load(store.Configuration, &config); err != nil {
+		return config, errors.Wrapf(err, "Could not load the configuration of container %s", containerID)
+	}
+
+	return config, nil
+}
+
 // This function might have to stop the container if it realizes the shim
 // process is not running anymore. This might be caused by two different
 // reasons, either the process inside the VM exited and the shim terminated
@@ -8,6 +8,7 @@ package virtcontainers
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -23,6 +24,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/kata-containers/runtime/virtcontainers/utils"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -575,6 +577,61 @@ func TestListSandboxNoSandboxDirectory(t *testing.T) {
 	}
 }
 
+// writeSandboxLayout writes the configuration and the state files of a fake
+// sandbox in the store, skipping the empty ones. A sandbox with a
+// configuration gets its store created first, along with the store lock.
+func writeSandboxLayout(t *testing.T, sandboxID, config, state string) {
+	assert := assert.New(t)
+
+	if config != "" {
+		_, err := store.NewVCSandboxStore(context.Background(), sandboxID)
+		assert.NoError(err)
+	}
+
+	for _, f := range []struct {
+		dir     string
+		file    string
+		content string
+	}{
+		{store.ConfigStoragePath, store.ConfigurationFile, config},
+		{store.RunStoragePath, store.StateFile, state},
+	} {
+		if f.content == "" {
+			continue
+		}
+
+		dir := filepath.Join(f.dir, sandboxID)
+		assert.NoError(os.MkdirAll(dir, store.DirMode))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, f.file), []byte(f.content), 0640))
+	}
+}
+
+func TestListSandboxes(t *testing.T) {
+	defer cleanUp()
+
+	assert := assert.New(t)
+
+	sandboxes, err := ListSandboxes(context.Background())
+	assert.NoError(err)
+	assert.Empty(sandboxes)
+
+	writeSandboxLayout(t, "running", "{}", `{"state":"running"}`)
+	writeSandboxLayout(t, "ready", "{}", `{"state":"ready","blockIndex":2}`)
+	// partial or corrupt entries
+	writeSandboxLayout(t, "no-state", "{}", "")
+	writeSandboxLayout(t, "no-config", "", `{"state":"ready"}`)
+	writeSandboxLayout(t, "corrupt-state", "{}", `{"state":`)
+	writeSandboxLayout(t, "invalid-state", "{}", `{"state":"unknown"}`)
+	assert.NoError(ioutil.WriteFile(filepath.Join(store.ConfigStoragePath, "not-a-dir"), []byte{}, 0640))
+
+	sandboxes, err = ListSandboxes(context.Background())
+	assert.NoError(err)
+	assert.Equal([]SandboxListEntry{
+		{ID: "ready", State: types.SandboxState{State: types.StateReady, BlockIndex: 2}},
+		{ID: "running", State: types.SandboxState{State: types.StateRunning}},
+	}, sandboxes)
+}
+
 func TestStatusSandboxSuccessfulStateReady(t *testing.T) {
 	defer cleanUp()
 
@@ -1685,6 +1742,139 @@ func TestFetchStatefulSandbox(t *testing.T) {
 	assert.Equal(t, fetched, s, "fetched stateful sandboxed should match")
 }
 
+func TestFetchSandboxMigratesStore(t *testing.T) {
+	defer cleanUp()
+
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	s, err := CreateSandbox(ctx, newTestSandboxConfigNoop(), nil)
+	assert.NoError(err)
+
+	versionFile := filepath.Join(store.SandboxConfigurationRootPath(s.ID()), store.VersionFile)
+	sandboxStore, err := store.NewVCSandboxStore(ctx, s.ID())
+	assert.NoError(err)
+
+	version, err := sandboxStore.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(store.CurrentVersion, version)
+
+	// a sandbox created before the store layout got versioned
+	assert.NoError(os.Remove(versionFile))
+
+	_, err = FetchSandbox(ctx, s.ID())
+	assert.NoError(err)
+
+	version, err = sandboxStore.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(store.CurrentVersion, version)
+
+	// a sandbox created by a newer runtime
+	assert.NoError(ioutil.WriteFile(versionFile, []byte(`{"version":100}`), 0640))
+	globalSandboxList.removeSandbox(s.ID())
+
+	_, err = FetchSandbox(ctx, s.ID())
+	assert.Error(err)
+	assert.Contains(err.Error(), "Unsupported store version 100")
+}
+
+func TestFetchContainerSpec(t *testing.T) {
+	defer cleanUp()
+
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	sandboxStore, err := store.NewVCSandboxStore(ctx, testSandboxID)
+	assert.NoError(err)
+	assert.NoError(sandboxStore.Store(store.Configuration, SandboxConfig{ID: testSandboxID}))
+
+	containerStore, err := store.NewVCContainerStore(ctx, testSandboxID, containerID)
+	assert.NoError(err)
+
+	// the container configuration, as persisted on create
+	containerConfig := ContainerConfig{
+		ID: containerID,
+		Annotations: map[string]string{
+			annotations.ConfigJSONKey: `{"ociVersion":"1.0.1","process":{"args":["sh"],"cwd":"/"},"hostname":"spec"}`,
+		},
+	}
+	assert.NoError(containerStore.Store(store.Configuration, containerConfig))
+
+	spec, err := FetchContainerSpec(ctx, testSandboxID, containerID)
+	assert.NoError(err)
+	assert.Equal("1.0.1", spec.Version)
+	assert.Equal([]string{"sh"}, spec.Process.Args)
+	assert.Equal("spec", spec.Hostname)
+
+	_, err = FetchContainerSpec(ctx, "unknown", containerID)
+	assert.Equal(vcTypes.ErrNoSuchSandbox, errors.Cause(err))
+
+	_, err = FetchContainerSpec(ctx, testSandboxID, "unknown")
+	assert.Equal(vcTypes.ErrNoSuchContainer, errors.Cause(err))
+
+	_, err = FetchContainerSpec(ctx, "", containerID)
+	assert.Equal(vcTypes.ErrNeedSandboxID, err)
+
+	_, err = FetchContainerSpec(ctx, testSandboxID, "")
+	assert.Equal(vcTypes.ErrNeedContainerID, err)
+
+	// a container without any spec
+	containerConfig.Annotations = nil
+	assert.NoError(containerStore.Store(store.Configuration, containerConfig))
+
+	_, err = FetchContainerSpec(ctx, testSandboxID, containerID)
+	assert.Equal(errorMissingOCISpec, err)
+}
+
+func TestGetContainerAnnotations(t *testing.T) {
+	defer cleanUp()
+
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	sandboxStore, err := store.NewVCSandboxStore(ctx, testSandboxID)
+	assert.NoError(err)
+	assert.NoError(sandboxStore.Store(store.Configuration, SandboxConfig{ID: testSandboxID}))
+
+	containerStore, err := store.NewVCContainerStore(ctx, testSandboxID, containerID)
+	assert.NoError(err)
+
+	// the container configuration, as persisted on create
+	expected := map[string]string{
+		annotations.ContainerTypeKey:   string(PodContainer),
+		"io.kubernetes.cri.sandbox-id": testSandboxID,
+	}
+	assert.NoError(containerStore.Store(store.Configuration, ContainerConfig{ID: containerID, Annotations: expected}))
+
+	got, err := GetContainerAnnotations(ctx, testSandboxID, containerID)
+	assert.NoError(err)
+	assert.Equal(expected, got)
+
+	// the returned annotations are a copy
+	got["foo"] = "bar"
+	got, err = GetContainerAnnotations(ctx, testSandboxID, containerID)
+	assert.NoError(err)
+	assert.Equal(expected, got)
+
+	_, err = GetContainerAnnotations(ctx, "unknown", containerID)
+	assert.Equal(vcTypes.ErrNoSuchSandbox, errors.Cause(err))
+
+	_, err = GetContainerAnnotations(ctx, testSandboxID, "unknown")
+	assert.Equal(vcTypes.ErrNoSuchContainer, errors.Cause(err))
+
+	_, err = GetContainerAnnotations(ctx, "", containerID)
+	assert.Equal(vcTypes.ErrNeedSandboxID, err)
+
+	_, err = GetContainerAnnotations(ctx, testSandboxID, "")
+	assert.Equal(vcTypes.ErrNeedContainerID, err)
+
+	// a corrupted configuration
+	containerFile := filepath.Join(store.ContainerConfigurationRootPath(testSandboxID, containerID), store.ConfigurationFile)
+	assert.NoError(ioutil.WriteFile(containerFile, []byte("{"), 0640))
+	_, err = GetContainerAnnotations(ctx, testSandboxID, containerID)
+	assert.Error(err)
+}
+
 func TestFetchNonExistingSandbox(t *testing.T) {
 	defer cleanUp()
 
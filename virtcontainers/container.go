@@ -452,6 +452,11 @@ func (c *Container) setContainerState(state types.StateString) error {
 	// update in-memory state
 	c.state.State = state
 
+	return c.flushState()
+}
+
+// flushState writes the in-memory container state to the storage.
+func (c *Container) flushState() error {
 	if c.sandbox.supportNewStore() {
 		// flush data to storage
 		if err := c.sandbox.Save(); err != nil {
@@ -479,7 +484,7 @@ func (c *Container) shareFiles(m Mount, idx int, hostSharedDir, guestSharedDir s
 
 	// copy file to contaier's rootfs if filesystem sharing is not supported, otherwise
 	// bind mount it in the shared directory.
-	caps := c.sandbox.hypervisor.capabilities()
+	caps := c.sandbox.Capabilities()
 	if !caps.IsFsSharingSupported() {
 		c.Logger().Debug("filesystem sharing is not supported, files will be copied")
 
@@ -564,6 +569,13 @@ func (c *Container) mountSharedDirMounts(hostSharedDir, guestSharedDir string) (
 			continue
 		}
 
+		// Check the readonly and propagation options, let the agent
+		// handle them within the VM.
+		options, readonly, err := bindMountOptions(m.Options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid mount %s: %v", m.Destination, err)
+		}
+
 		guestDest, ignore, err := c.shareFiles(m, idx, hostSharedDir, guestSharedDir)
 		if err != nil {
 			return nil, nil, err
@@ -575,20 +587,11 @@ func (c *Container) mountSharedDirMounts(hostSharedDir, guestSharedDir string) (
 			continue
 		}
 
-		// Check if mount is readonly, let the agent handle the readonly mount
-		// within the VM.
-		readonly := false
-		for _, flag := range m.Options {
-			if flag == "ro" {
-				readonly = true
-			}
-		}
-
 		sharedDirMount := Mount{
 			Source:      guestDest,
 			Destination: m.Destination,
 			Type:        m.Type,
-			Options:     m.Options,
+			Options:     options,
 			ReadOnly:    readonly,
 		}
 
@@ -825,7 +828,7 @@ func (c *Container) rollbackFailingContainerCreation() {
 func (c *Container) checkBlockDeviceSupport() bool {
 	if !c.sandbox.config.HypervisorConfig.DisableBlockDeviceUse {
 		agentCaps := c.sandbox.agent.capabilities()
-		hypervisorCaps := c.sandbox.hypervisor.capabilities()
+		hypervisorCaps := c.sandbox.Capabilities()
 
 		if agentCaps.IsBlockDeviceSupported() && hypervisorCaps.IsBlockDeviceHotplugSupported() {
 			return true
@@ -962,6 +965,10 @@ func (c *Container) start() error {
 		return err
 	}
 
+	if c.state.State == types.StateStopped {
+		c.state.RestartCount++
+	}
+
 	return c.setContainerState(types.StateRunning)
 }
 
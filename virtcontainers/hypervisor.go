@@ -291,6 +291,10 @@ type HypervisorConfig struct {
 	// DisableVhostNet is used to indicate if host supports vhost_net
 	DisableVhostNet bool
 
+	// DisableMemoryHotplug is used to keep the VM memory size fixed,
+	// whatever the memory limits of the containers
+	DisableMemoryHotplug bool
+
 	// GuestHookPath is the path within the VM that will be used for 'drop-in' hooks
 	GuestHookPath string
 
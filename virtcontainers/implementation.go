@@ -66,6 +66,11 @@ func (impl *VCImpl) ListSandbox(ctx context.Context) ([]SandboxStatus, error) {
 	return ListSandbox(ctx)
 }
 
+// ListSandboxes implements the VC function of the same name.
+func (impl *VCImpl) ListSandboxes(ctx context.Context) ([]SandboxListEntry, error) {
+	return ListSandboxes(ctx)
+}
+
 // FetchSandbox will find out and connect to an existing sandbox and
 // return the sandbox structure.
 func (impl *VCImpl) FetchSandbox(ctx context.Context, sandboxID string) (VCSandbox, error) {
@@ -117,6 +122,16 @@ func (impl *VCImpl) StatusContainer(ctx context.Context, sandboxID, containerID
 	return StatusContainer(ctx, sandboxID, containerID)
 }
 
+// FetchContainerSpec implements the VC function of the same name.
+func (impl *VCImpl) FetchContainerSpec(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error) {
+	return FetchContainerSpec(ctx, sandboxID, containerID)
+}
+
+// GetContainerAnnotations implements the VC function of the same name.
+func (impl *VCImpl) GetContainerAnnotations(ctx context.Context, sandboxID, containerID string) (map[string]string, error) {
+	return GetContainerAnnotations(ctx, sandboxID, containerID)
+}
+
 // StatsContainer implements the VC function of the same name.
 func (impl *VCImpl) StatsContainer(ctx context.Context, sandboxID, containerID string) (ContainerStats, error) {
 	return StatsContainer(ctx, sandboxID, containerID)
@@ -27,6 +27,7 @@ type VC interface {
 	DeleteSandbox(ctx context.Context, sandboxID string) (VCSandbox, error)
 	FetchSandbox(ctx context.Context, sandboxID string) (VCSandbox, error)
 	ListSandbox(ctx context.Context) ([]SandboxStatus, error)
+	ListSandboxes(ctx context.Context) ([]SandboxListEntry, error)
 	PauseSandbox(ctx context.Context, sandboxID string) (VCSandbox, error)
 	ResumeSandbox(ctx context.Context, sandboxID string) (VCSandbox, error)
 	RunSandbox(ctx context.Context, sandboxConfig SandboxConfig) (VCSandbox, error)
@@ -40,6 +41,8 @@ type VC interface {
 	KillContainer(ctx context.Context, sandboxID, containerID string, signal syscall.Signal, all bool) error
 	StartContainer(ctx context.Context, sandboxID, containerID string) (VCContainer, error)
 	StatusContainer(ctx context.Context, sandboxID, containerID string) (ContainerStatus, error)
+	FetchContainerSpec(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error)
+	GetContainerAnnotations(ctx context.Context, sandboxID, containerID string) (map[string]string, error)
 	StatsContainer(ctx context.Context, sandboxID, containerID string) (ContainerStats, error)
 	StopContainer(ctx context.Context, sandboxID, containerID string) (VCContainer, error)
 	ProcessListContainer(ctx context.Context, sandboxID, containerID string, options ProcessListOptions) (ProcessList, error)
@@ -66,6 +69,7 @@ type VCSandbox interface {
 	GetContainer(containerID string) VCContainer
 	ID() string
 	SetAnnotations(annotations map[string]string) error
+	Capabilities() types.Capabilities
 
 	Start() error
 	Stop() error
@@ -88,6 +92,7 @@ type VCSandbox interface {
 	UpdateContainer(containerID string, resources specs.LinuxResources) error
 	ProcessListContainer(containerID string, options ProcessListOptions) (ProcessList, error)
 	WaitProcess(containerID, processID string) (int32, error)
+	WaitProcessWithContext(ctx context.Context, containerID, processID string) (int32, error)
 	SignalProcess(containerID, processID string, signal syscall.Signal, all bool) error
 	WinsizeProcess(containerID, processID string, height, width uint32) error
 	IOStream(containerID, processID string) (io.WriteCloser, io.Reader, io.Reader, error)
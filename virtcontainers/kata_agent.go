@@ -621,6 +621,10 @@ func (k *kataAgent) startProxy(sandbox *Sandbox) error {
 		consoleURL: consoleURL,
 		logger:     k.Logger().WithField("sandbox", sandbox.id),
 		debug:      sandbox.config.ProxyConfig.Debug,
+
+		stopGracePeriod: sandbox.config.ProxyConfig.StopGracePeriod,
+		waitTimeout:     sandbox.config.ProxyConfig.SocketWaitTimeout,
+		forwardLogs:     sandbox.config.ProxyConfig.ForwardLogs,
 	}
 
 	// Start the proxy here
@@ -637,6 +641,10 @@ func (k *kataAgent) startProxy(sandbox *Sandbox) error {
 		}
 	}()
 
+	if err = validateProxyPid(sandbox.config.ProxyType, pid); err != nil {
+		return err
+	}
+
 	// Fill agent state with proxy information, and store them.
 	if err = k.setProxy(sandbox, k.proxy, pid, uri); err != nil {
 		return err
@@ -737,7 +745,7 @@ func (k *kataAgent) startSandbox(sandbox *Sandbox) error {
 	}
 
 	storages := []*grpc.Storage{}
-	caps := sandbox.hypervisor.capabilities()
+	caps := sandbox.Capabilities()
 
 	// append 9p shared volume to storages only if filesystem sharing is supported
 	if caps.IsFsSharingSupported() {
@@ -856,6 +864,9 @@ func (k *kataAgent) replaceOCIMountSource(spec *specs.Spec, guestMounts []Mount)
 
 			k.Logger().Debugf("Replacing OCI mount (%s) source %s with %s", m.Destination, m.Source, guestMount.Source)
 			ociMounts[index].Source = guestMount.Source
+			if guestMount.Options != nil {
+				ociMounts[index].Options = guestMount.Options
+			}
 		}
 	}
 
@@ -973,6 +984,35 @@ func constraintGRPCSpec(grpcSpec *grpc.Spec, systemdCgroup bool, passSeccomp boo
 	grpcSpec.Linux.Namespaces = tmpNamespaces
 }
 
+// handleIDMappings passes the uid and gid mappings of the user namespace to
+// the agent. OCItoGRPC copies the fields by name, which drops the size of
+// the ranges, named Size_ in the gRPC mappings.
+func handleIDMappings(grpcSpec *grpc.Spec, ociSpec *specs.Spec) {
+	if ociSpec.Linux == nil || grpcSpec.Linux == nil {
+		return
+	}
+
+	grpcSpec.Linux.UIDMappings = grpcIDMappings(ociSpec.Linux.UIDMappings)
+	grpcSpec.Linux.GIDMappings = grpcIDMappings(ociSpec.Linux.GIDMappings)
+}
+
+func grpcIDMappings(mappings []specs.LinuxIDMapping) []grpc.LinuxIDMapping {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	grpcMappings := make([]grpc.LinuxIDMapping, 0, len(mappings))
+	for _, m := range mappings {
+		grpcMappings = append(grpcMappings, grpc.LinuxIDMapping{
+			HostID:      m.HostID,
+			ContainerID: m.ContainerID,
+			Size_:       m.Size,
+		})
+	}
+
+	return grpcMappings
+}
+
 func (k *kataAgent) handleShm(grpcSpec *grpc.Spec, sandbox *Sandbox) {
 	for idx, mnt := range grpcSpec.Mounts {
 		if mnt.Destination != "/dev/shm" {
@@ -1120,6 +1160,21 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		return nil, errorMissingOCISpec
 	}
 
+	ociSpec := &specs.Spec{}
+	if err = json.Unmarshal([]byte(ociSpecJSON), ociSpec); err != nil {
+		return nil, err
+	}
+
+	passSeccomp := !sandbox.config.DisableGuestSeccomp && sandbox.seccompSupported
+
+	// A malformed seccomp profile would only fail the container start in
+	// the guest, check it before creating anything.
+	if passSeccomp && ociSpec.Linux != nil {
+		if err = validSeccomp(ociSpec.Linux.Seccomp); err != nil {
+			return nil, err
+		}
+	}
+
 	var ctrStorages []*grpc.Storage
 	var ctrDevices []*grpc.Device
 	var rootfs *grpc.Storage
@@ -1146,18 +1201,18 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		ctrStorages = append(ctrStorages, rootfs)
 	}
 
-	ociSpec := &specs.Spec{}
-	if err = json.Unmarshal([]byte(ociSpecJSON), ociSpec); err != nil {
+	// Handle container mounts
+	newMounts, ignoredMounts, err := c.mountSharedDirMounts(kataHostSharedDir, kataGuestSharedDir)
+	if err != nil {
 		return nil, err
 	}
 
-	// Handle container mounts
-	newMounts, ignoredMounts, err := c.mountSharedDirMounts(kataHostSharedDir, kataGuestSharedDir)
+	epheSize, err := ephemeralStorageSize(ociSpec.Annotations, sandbox.config.EphemeralStorageMaxSize)
 	if err != nil {
 		return nil, err
 	}
 
-	epheStorages := k.handleEphemeralStorage(ociSpec.Mounts)
+	epheStorages := k.handleEphemeralStorage(ociSpec.Mounts, epheSize)
 	ctrStorages = append(ctrStorages, epheStorages...)
 
 	localStorages := k.handleLocalStorage(ociSpec.Mounts, sandbox.id)
@@ -1193,13 +1248,13 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		return nil, err
 	}
 
+	handleIDMappings(grpcSpec, ociSpec)
+
 	// We need to give the OCI spec our absolute rootfs path in the guest.
 	grpcSpec.Root.Path = rootPath
 
 	sharedPidNs := k.handlePidNamespace(grpcSpec, sandbox)
 
-	passSeccomp := !sandbox.config.DisableGuestSeccomp && sandbox.seccompSupported
-
 	// We need to constraint the spec to make sure we're not passing
 	// irrelevant information to the agent.
 	constraintGRPCSpec(grpcSpec, sandbox.config.SystemdCgroup, passSeccomp)
@@ -1242,9 +1297,31 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		k.state.URL, consoleURL, c.config.Cmd, createNSList, enterNSList)
 }
 
+// ephemeralStorageSize returns the size of the tmpfs backing the ephemeral
+// volumes of a container, from its EphemeralStorageSizeLimit annotation, 0
+// if it doesn't set any. The size cannot exceed maxSize, if not 0.
+func ephemeralStorageSize(annotations map[string]string, maxSize uint64) (uint64, error) {
+	value, ok := annotations[vcAnnotations.EphemeralStorageSizeLimit]
+	if !ok {
+		return 0, nil
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("Invalid ephemeral storage size limit %q", value)
+	}
+
+	if maxSize > 0 && size > maxSize {
+		return 0, fmt.Errorf("Ephemeral storage size limit %d exceeds the maximum of %d bytes", size, maxSize)
+	}
+
+	return size, nil
+}
+
 // handleEphemeralStorage handles ephemeral storages by
-// creating a Storage from corresponding source of the mount point
-func (k *kataAgent) handleEphemeralStorage(mounts []specs.Mount) []*grpc.Storage {
+// creating a Storage from corresponding source of the mount point.
+// The tmpfs backing the storages is limited to size bytes, if not 0.
+func (k *kataAgent) handleEphemeralStorage(mounts []specs.Mount, size uint64) []*grpc.Storage {
 	var epheStorages []*grpc.Storage
 	for idx, mnt := range mounts {
 		if mnt.Type == KataEphemeralDevType {
@@ -1261,6 +1338,9 @@ func (k *kataAgent) handleEphemeralStorage(mounts []specs.Mount) []*grpc.Storage
 				Fstype:     "tmpfs",
 				MountPoint: mounts[idx].Source,
 			}
+			if size > 0 {
+				epheStorage.Options = []string{fmt.Sprintf("size=%d", size)}
+			}
 			epheStorages = append(epheStorages, epheStorage)
 		}
 	}
@@ -374,12 +374,68 @@ func TestHandleEphemeralStorage(t *testing.T) {
 	}
 
 	ociMounts = append(ociMounts, mount)
-	epheStorages := k.handleEphemeralStorage(ociMounts)
+	epheStorages := k.handleEphemeralStorage(ociMounts, 0)
 
 	epheMountPoint := epheStorages[0].GetMountPoint()
 	expected := filepath.Join(ephemeralPath, filepath.Base(mountSource))
 	assert.Equal(t, epheMountPoint, expected,
 		"Ephemeral mount point didn't match: got %s, expecting %s", epheMountPoint, expected)
+	assert.Empty(t, epheStorages[0].Options)
+}
+
+func TestHandleEphemeralStorageSize(t *testing.T) {
+	assert := assert.New(t)
+	k := kataAgent{}
+
+	ociMounts := []specs.Mount{
+		{Type: KataEphemeralDevType, Source: "/tmp/volume1"},
+		{Type: "bind", Source: "/tmp/other"},
+		{Type: KataEphemeralDevType, Source: "/tmp/volume2"},
+	}
+
+	epheStorages := k.handleEphemeralStorage(ociMounts, 64*1024*1024)
+	if assert.Len(epheStorages, 2) {
+		for _, st := range epheStorages {
+			assert.Equal([]string{"size=67108864"}, st.Options)
+		}
+	}
+}
+
+func TestEphemeralStorageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	const maxSize = 128 * 1024 * 1024
+
+	data := []struct {
+		annotations map[string]string
+		maxSize     uint64
+		size        uint64
+		expectErr   bool
+	}{
+		// no size requested
+		{nil, maxSize, 0, false},
+		// within the cap
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "67108864"}, maxSize, 64 * 1024 * 1024, false},
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "134217728"}, maxSize, maxSize, false},
+		// no cap
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "1073741824"}, 0, 1024 * 1024 * 1024, false},
+		// above the cap
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "134217729"}, maxSize, 0, true},
+		// invalid sizes
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "0"}, maxSize, 0, true},
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "64M"}, maxSize, 0, true},
+		{map[string]string{vcAnnotations.EphemeralStorageSizeLimit: "-1"}, 0, 0, true},
+	}
+
+	for i, d := range data {
+		size, err := ephemeralStorageSize(d.annotations, d.maxSize)
+		if d.expectErr {
+			assert.Error(err, "test %d", i)
+		} else {
+			assert.NoError(err, "test %d", i)
+		}
+		assert.Equal(d.size, size, "test %d", i)
+	}
 }
 
 func TestAppendDevicesEmptyContainerDeviceList(t *testing.T) {
@@ -478,6 +534,7 @@ func TestConstraintGRPCSpec(t *testing.T) {
 				Network:        &pb.LinuxNetwork{},
 			},
 			CgroupsPath: "system.slice:foo:bar",
+			Sysctl:      map[string]string{"net.core.somaxconn": "1024"},
 		},
 	}
 
@@ -503,6 +560,54 @@ func TestConstraintGRPCSpec(t *testing.T) {
 
 	// check cgroup path
 	assert.Equal(expectedCgroupPath, g.Linux.CgroupsPath)
+
+	// the sysctls are set by the agent
+	assert.Equal(map[string]string{"net.core.somaxconn": "1024"}, g.Linux.Sysctl)
+}
+
+func TestHandleIDMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	mappings := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65535},
+	}
+
+	ociSpec := &specs.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.UserNamespace},
+			},
+			UIDMappings: mappings,
+			GIDMappings: mappings[:1],
+			Resources:   &specs.LinuxResources{},
+		},
+	}
+
+	g, err := pb.OCItoGRPC(ociSpec)
+	assert.NoError(err)
+
+	handleIDMappings(g, ociSpec)
+	constraintGRPCSpec(g, false, false)
+
+	// the guest sets up the user namespace of the container
+	assert.Len(g.Linux.Namespaces, 1)
+	assert.Equal(string(specs.UserNamespace), g.Linux.Namespaces[0].Type)
+	assert.Equal([]pb.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size_: 1},
+		{ContainerID: 1, HostID: 100000, Size_: 65535},
+	}, g.Linux.UIDMappings)
+	assert.Equal([]pb.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size_: 1}}, g.Linux.GIDMappings)
+
+	// a spec without any mapping
+	ociSpec.Linux.UIDMappings = nil
+	ociSpec.Linux.GIDMappings = nil
+	g, err = pb.OCItoGRPC(ociSpec)
+	assert.NoError(err)
+
+	handleIDMappings(g, ociSpec)
+	assert.Nil(g.Linux.UIDMappings)
+	assert.Nil(g.Linux.GIDMappings)
 }
 
 func TestHandleShm(t *testing.T) {
@@ -21,6 +21,7 @@ var buildinProxyConsoleProto = consoleProtoUnix
 type kataBuiltInProxy struct {
 	sandboxID string
 	conn      net.Conn
+	logger    *logrus.Entry
 }
 
 // check if the proxy has watched the vm console.
@@ -52,23 +53,30 @@ func (p *kataBuiltInProxy) start(params proxyParams) (int, string, error) {
 		return -1, "", fmt.Errorf("kata builtin proxy running for sandbox %s", params.id)
 	}
 
-	params.logger.Debug("Starting builtin kata proxy")
+	logger := newProxyLogger(params.logger, KataBuiltInProxyType, params.id)
+	logger.Debug("Starting builtin kata proxy")
 
 	p.sandboxID = params.id
 
 	if params.debug {
-		err := p.watchConsole(buildinProxyConsoleProto, params.consoleURL, params.logger)
+		err := p.watchConsole(buildinProxyConsoleProto, params.consoleURL, logger)
 		if err != nil {
 			p.sandboxID = ""
 			return -1, "", err
 		}
 	}
 
+	p.logger = proxyStarted(logger, -1, params.agentURL)
+
 	return -1, params.agentURL, nil
 }
 
 // stop is the proxy stop implementation for kata builtin proxy.
 func (p *kataBuiltInProxy) stop(pid int) error {
+	if p.logger != nil {
+		p.logger.Info("Stopping proxy")
+	}
+
 	if p.conn != nil {
 		p.conn.Close()
 		p.conn = nil
@@ -7,13 +7,18 @@ package virtcontainers
 
 import (
 	"os/exec"
-	"syscall"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // This is the Kata Containers implementation of the proxy interface.
 // This is pretty simple since it provides the same interface to both
 // runtime and shim as if they were talking directly to the agent.
 type kataProxy struct {
+	stopGracePeriod time.Duration
+	logger          *logrus.Entry
 }
 
 // The kata proxy doesn't need to watch the vm console, thus return false always.
@@ -27,10 +32,11 @@ func (p *kataProxy) start(params proxyParams) (int, string, error) {
 		return -1, "", err
 	}
 
-	params.logger.Debug("Starting regular Kata proxy rather than built-in")
+	logger := newProxyLogger(params.logger, KataProxyType, params.id)
+	logger.Debug("Starting regular Kata proxy rather than built-in")
 
 	// construct the socket path the proxy instance will use
-	proxyURL, err := defaultProxyURL(params.id, SocketTypeUNIX)
+	proxyURL, err := defaultProxyURL(params.id, SocketTypeUNIX, "", nil)
 	if err != nil {
 		return -1, "", err
 	}
@@ -47,17 +53,36 @@ func (p *kataProxy) start(params proxyParams) (int, string, error) {
 	}
 
 	cmd := exec.Command(args[0], args[1:]...)
+
 	if err := cmd.Start(); err != nil {
 		return -1, "", err
 	}
 
+	p.stopGracePeriod = params.stopGracePeriod
+
 	go cmd.Wait()
 
+	if params.waitTimeout > 0 {
+		socketPath := strings.TrimPrefix(proxyURL, "unix://")
+		if err := waitProxySocket(socketPath, params.waitTimeout); err != nil {
+			logger.WithError(err).Error("Proxy socket not ready")
+			cmd.Process.Kill()
+			return -1, "", err
+		}
+	}
+
+	// the spawn command is logged with all the proxy lifecycle events,
+	// for a crashed proxy to be debugged.
+	p.logger = proxyStarted(logger.WithField("args", cmd.Args), cmd.Process.Pid, proxyURL)
+
 	return cmd.Process.Pid, proxyURL, nil
 }
 
 // stop is kataProxy stop implementation for proxy interface.
 func (p *kataProxy) stop(pid int) error {
-	// Signal the proxy with SIGTERM.
-	return syscall.Kill(pid, syscall.SIGTERM)
+	if p.logger != nil {
+		p.logger.Info("Stopping proxy")
+	}
+
+	return terminateProxyProcess(pid, p.stopGracePeriod)
 }
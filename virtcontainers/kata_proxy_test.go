@@ -6,7 +6,15 @@
 package virtcontainers
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestKataProxyStart(t *testing.T) {
@@ -15,3 +23,56 @@ func TestKataProxyStart(t *testing.T) {
 
 	testProxyStart(t, agent, proxy)
 }
+
+func TestKataProxySpawnCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &kataProxy{}
+
+	params := proxyParams{
+		id:         testSandboxID,
+		path:       "echo",
+		agentURL:   "agentURL",
+		consoleURL: "consoleURL",
+		logger:     testDefaultLogger,
+	}
+
+	_, uri, err := proxy.start(params)
+	assert.NoError(err)
+
+	// the spawn command is logged with the proxy lifecycle events
+	assert.Equal([]string{"echo", "-listen-socket", uri, "-mux-socket", "agentURL", "-sandbox", testSandboxID}, proxy.logger.Data["args"])
+}
+
+func TestKataProxyStartWaitSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	params := proxyParams{
+		id:          testSandboxID,
+		path:        "echo",
+		agentURL:    "agentURL",
+		consoleURL:  "consoleURL",
+		logger:      testDefaultLogger,
+		waitTimeout: 200 * time.Millisecond,
+	}
+
+	socketPath := filepath.Join(store.SandboxRuntimeRootPath(testSandboxID), "proxy.sock")
+	os.Remove(socketPath)
+
+	// nothing listens to the proxy socket
+	proxy := &kataProxy{}
+	_, _, err := proxy.start(params)
+	assert.Error(err)
+	assert.Contains(err.Error(), socketPath)
+
+	err = os.MkdirAll(filepath.Dir(socketPath), store.DirMode)
+	assert.NoError(err)
+	l, err := net.Listen("unix", socketPath)
+	assert.NoError(err)
+	defer l.Close()
+
+	pid, uri, err := proxy.start(params)
+	assert.NoError(err)
+	assert.True(pid > 0)
+	assert.Equal("unix://"+socketPath, uri)
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 )
 
@@ -325,6 +326,53 @@ type Mount struct {
 	BlockDeviceID string
 }
 
+// propagationOptions are the mount propagation options of a bind mount.
+var propagationOptions = map[string]bool{
+	"shared":      true,
+	"rshared":     true,
+	"slave":       true,
+	"rslave":      true,
+	"private":     true,
+	"rprivate":    true,
+	"unbindable":  true,
+	"runbindable": true,
+}
+
+// bindMountOptions translates the options of an OCI bind mount into the
+// options of the guest mount and tells if it is read only. The propagation
+// and the ro/rw options are kept for the agent to apply them in the guest,
+// at most one of each being allowed.
+func bindMountOptions(options []string) ([]string, bool, error) {
+	var guestOptions []string
+	var propagation, access string
+
+	for _, o := range options {
+		switch {
+		case propagationOptions[o]:
+			if propagation != "" && propagation != o {
+				return nil, false, fmt.Errorf("Conflicting bind mount propagation options %q and %q", propagation, o)
+			}
+			propagation = o
+		case o == "ro" || o == "rw":
+			if access != "" && access != o {
+				return nil, false, fmt.Errorf("Conflicting bind mount options %q and %q", access, o)
+			}
+			access = o
+		default:
+			guestOptions = append(guestOptions, o)
+		}
+	}
+
+	if access != "" {
+		guestOptions = append(guestOptions, access)
+	}
+	if propagation != "" {
+		guestOptions = append(guestOptions, propagation)
+	}
+
+	return guestOptions, access == "ro", nil
+}
+
 func bindUnmountContainerRootfs(ctx context.Context, sharedDir, sandboxID, cID string) error {
 	span, _ := trace(ctx, "bindUnmountContainerRootfs")
 	defer span.Finish()
@@ -367,18 +415,30 @@ func IsDockerVolume(path string) bool {
 const (
 	// K8sEmptyDir is the k8s specific path for `empty-dir` volumes
 	K8sEmptyDir = "kubernetes.io~empty-dir"
+
+	// K8sSecret is the k8s specific path for `secret` volumes
+	K8sSecret = "kubernetes.io~secret"
+
+	// K8sConfigMap is the k8s specific path for `configmap` volumes
+	K8sConfigMap = "kubernetes.io~configmap"
 )
 
+// EphemeralStorageProvider detects the ephemeral storage volumes of an
+// orchestrator, based on their host path layout.
+type EphemeralStorageProvider interface {
+	IsEphemeralStorage(path string) bool
+}
+
+// k8sEphemeralStorage is the default EphemeralStorageProvider, detecting
+// the kubernetes empty-dir volumes backed by memory.
+type k8sEphemeralStorage struct{}
+
 // IsEphemeralStorage returns true if the given path
 // to the storage belongs to kubernetes ephemeral storage
 //
 // This method depends on a specific path used by k8s
-// to detect if it's of type ephemeral. As of now,
-// this is a very k8s specific solution that works
-// but in future there should be a better way for this
-// method to determine if the path is for ephemeral
-// volume type
-func IsEphemeralStorage(path string) bool {
+// to detect if it's of type ephemeral.
+func (k8sEphemeralStorage) IsEphemeralStorage(path string) bool {
 	if !isEmptyDir(path) {
 		return false
 	}
@@ -390,6 +450,72 @@ func IsEphemeralStorage(path string) bool {
 	return false
 }
 
+var (
+	defaultEphemeralStorageProvider EphemeralStorageProvider = k8sEphemeralStorage{}
+
+	ephemeralStorageProvidersLock sync.RWMutex
+	ephemeralStorageProviders     = make(map[string]EphemeralStorageProvider)
+)
+
+// RegisterEphemeralStorageProvider registers the provider detecting the
+// ephemeral storage of the paths starting with the given prefix. This
+// allows orchestrators using their own volume layout to be supported.
+func RegisterEphemeralStorageProvider(prefix string, provider EphemeralStorageProvider) error {
+	if prefix == "" {
+		return fmt.Errorf("Ephemeral storage provider prefix cannot be empty")
+	}
+
+	if provider == nil {
+		return fmt.Errorf("Ephemeral storage provider for prefix %q cannot be nil", prefix)
+	}
+
+	ephemeralStorageProvidersLock.Lock()
+	defer ephemeralStorageProvidersLock.Unlock()
+
+	if _, exist := ephemeralStorageProviders[prefix]; exist {
+		return fmt.Errorf("Ephemeral storage provider already registered for prefix %q", prefix)
+	}
+
+	ephemeralStorageProviders[prefix] = provider
+
+	return nil
+}
+
+// UnregisterEphemeralStorageProvider removes the provider registered for
+// the given prefix, if any.
+func UnregisterEphemeralStorageProvider(prefix string) {
+	ephemeralStorageProvidersLock.Lock()
+	defer ephemeralStorageProvidersLock.Unlock()
+
+	delete(ephemeralStorageProviders, prefix)
+}
+
+// ephemeralStorageProvider returns the provider registered for the longest
+// prefix of the path, or the default kubernetes provider.
+func ephemeralStorageProvider(path string) EphemeralStorageProvider {
+	ephemeralStorageProvidersLock.RLock()
+	defer ephemeralStorageProvidersLock.RUnlock()
+
+	provider := defaultEphemeralStorageProvider
+	longest := 0
+
+	for prefix, p := range ephemeralStorageProviders {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			provider = p
+			longest = len(prefix)
+		}
+	}
+
+	return provider
+}
+
+// IsEphemeralStorage returns true if the given path to the storage belongs
+// to an ephemeral storage, as detected by the provider registered for the
+// path, defaulting to the kubernetes one.
+func IsEphemeralStorage(path string) bool {
+	return ephemeralStorageProvider(path).IsEphemeralStorage(path)
+}
+
 // Isk8sHostEmptyDir returns true if the given path
 // to the storage belongs to kubernetes empty-dir of medium "default"
 // i.e volumes that are directories on the host.
@@ -404,12 +530,32 @@ func Isk8sHostEmptyDir(path string) bool {
 	return false
 }
 
+// IsSecretStorage returns true if the given path
+// to the storage belongs to a kubernetes secret volume.
+func IsSecretStorage(path string) bool {
+	return isK8sStorageType(path, K8sSecret)
+}
+
+// IsConfigMapStorage returns true if the given path
+// to the storage belongs to a kubernetes configmap volume.
+func IsConfigMapStorage(path string) bool {
+	return isK8sStorageType(path, K8sConfigMap)
+}
+
 func isEmptyDir(path string) bool {
-	splitSourceSlice := strings.Split(path, "/")
+	return isK8sStorageType(path, K8sEmptyDir)
+}
+
+// isK8sStorageType returns true if the k8s volume type of the path, found
+// as its penultimate element, is one of the given storage types.
+func isK8sStorageType(path string, storageTypes ...string) bool {
+	splitSourceSlice := strings.Split(strings.TrimRight(path, "/"), "/")
 	if len(splitSourceSlice) > 1 {
 		storageType := splitSourceSlice[len(splitSourceSlice)-2]
-		if storageType == K8sEmptyDir {
-			return true
+		for _, t := range storageTypes {
+			if storageType == t {
+				return true
+			}
 		}
 	}
 	return false
@@ -339,3 +339,123 @@ func TestIsEphemeralStorage(t *testing.T) {
 	isHostEmptyDir = Isk8sHostEmptyDir(sampleEphePath)
 	assert.False(t, isHostEmptyDir)
 }
+
+func TestIsSecretStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	path := "/var/lib/kubelet/pods/366c3a75-4869-11e8-b479-507b9ddd5ce4/volumes/kubernetes.io~secret/default-token"
+	assert.True(IsSecretStorage(path))
+	assert.True(IsSecretStorage(path + "/"))
+	assert.False(IsConfigMapStorage(path))
+	assert.False(isEmptyDir(path))
+
+	assert.True(IsSecretStorage(K8sSecret + "/default-token"))
+	assert.False(IsSecretStorage(K8sSecret))
+	assert.False(IsSecretStorage(""))
+	assert.False(IsSecretStorage("/"))
+	assert.False(IsSecretStorage(filepath.Join(path, "ca.crt")))
+}
+
+func TestIsConfigMapStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	path := "/var/lib/kubelet/pods/366c3a75-4869-11e8-b479-507b9ddd5ce4/volumes/kubernetes.io~configmap/config"
+	assert.True(IsConfigMapStorage(path))
+	assert.True(IsConfigMapStorage(path + "//"))
+	assert.False(IsSecretStorage(path))
+	assert.False(isEmptyDir(path))
+
+	assert.True(IsConfigMapStorage(K8sConfigMap + "/config"))
+	assert.False(IsConfigMapStorage(K8sConfigMap))
+	assert.False(IsConfigMapStorage(""))
+	assert.False(IsConfigMapStorage(filepath.Join(path, "app.conf")))
+}
+
+func TestIsEmptyDirTrailingSlash(t *testing.T) {
+	assert := assert.New(t)
+
+	path := "/var/lib/kubelet/pods/366c3a75-4869-11e8-b479-507b9ddd5ce4/volumes/kubernetes.io~empty-dir/cache"
+	assert.True(isEmptyDir(path))
+	assert.True(isEmptyDir(path + "/"))
+	assert.False(isEmptyDir(K8sEmptyDir))
+}
+
+type fakeEphemeralStorage struct {
+	paths []string
+}
+
+func (p *fakeEphemeralStorage) IsEphemeralStorage(path string) bool {
+	p.paths = append(p.paths, path)
+	return strings.HasSuffix(path, "/ephemeral")
+}
+
+func TestRegisterEphemeralStorageProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	provider := &fakeEphemeralStorage{}
+	prefix := "/var/lib/nomad/alloc"
+
+	assert.Error(RegisterEphemeralStorageProvider("", provider))
+	assert.Error(RegisterEphemeralStorageProvider(prefix, nil))
+
+	assert.NoError(RegisterEphemeralStorageProvider(prefix, provider))
+	defer UnregisterEphemeralStorageProvider(prefix)
+	assert.Error(RegisterEphemeralStorageProvider(prefix, provider))
+
+	assert.True(IsEphemeralStorage(prefix + "/1234/ephemeral"))
+	assert.False(IsEphemeralStorage(prefix + "/1234/data"))
+	assert.Equal([]string{prefix + "/1234/ephemeral", prefix + "/1234/data"}, provider.paths)
+
+	// the longest prefix wins
+	nested := &fakeEphemeralStorage{}
+	assert.NoError(RegisterEphemeralStorageProvider(prefix+"/1234", nested))
+	defer UnregisterEphemeralStorageProvider(prefix + "/1234")
+
+	assert.True(IsEphemeralStorage(prefix + "/1234/ephemeral"))
+	assert.Len(provider.paths, 2)
+	assert.Equal([]string{prefix + "/1234/ephemeral"}, nested.paths)
+
+	// other paths are still handled by the default k8s provider
+	assert.False(IsEphemeralStorage("/var/lib/kubelet/pods/366c3a75/volumes/cache-volume/ephemeral"))
+	assert.Equal(defaultEphemeralStorageProvider, ephemeralStorageProvider("/var/lib/kubelet/pods"))
+
+	UnregisterEphemeralStorageProvider(prefix)
+	assert.Equal(defaultEphemeralStorageProvider, ephemeralStorageProvider(prefix+"/5678/ephemeral"))
+}
+
+func TestBindMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		options  []string
+		expected []string
+		readonly bool
+	}{
+		// emptyDir and configMap volumes
+		{[]string{"rbind", "rprivate"}, []string{"rbind", "rprivate"}, false},
+		// mountPropagation: Bidirectional
+		{[]string{"rbind", "rshared"}, []string{"rbind", "rshared"}, false},
+		// mountPropagation: HostToContainer
+		{[]string{"rbind", "rslave"}, []string{"rbind", "rslave"}, false},
+		// readOnly secret volume
+		{[]string{"ro", "rbind", "rprivate"}, []string{"rbind", "ro", "rprivate"}, true},
+		// /etc/hosts and /dev/termination-log
+		{[]string{"rbind", "rprivate", "rw"}, []string{"rbind", "rw", "rprivate"}, false},
+		// docker volume
+		{[]string{"rbind", "nosuid", "rprivate", "ro", "rprivate"}, []string{"rbind", "nosuid", "ro", "rprivate"}, true},
+		{[]string{"bind"}, []string{"bind"}, false},
+	}
+
+	for i, d := range data {
+		options, readonly, err := bindMountOptions(d.options)
+		assert.NoError(err, "test %d", i)
+		assert.Equal(d.expected, options, "test %d", i)
+		assert.Equal(d.readonly, readonly, "test %d", i)
+	}
+
+	_, _, err := bindMountOptions([]string{"rbind", "rshared", "rprivate"})
+	assert.Error(err)
+
+	_, _, err = bindMountOptions([]string{"ro", "rbind", "rw"})
+	assert.Error(err)
+}
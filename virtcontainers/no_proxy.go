@@ -6,7 +6,11 @@
 package virtcontainers
 
 import (
+	"bufio"
 	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
 )
 
 // This is the no proxy implementation of the proxy interface. This
@@ -19,7 +23,11 @@ import (
 // That's why this implementation is very generic, and all it does
 // is to provide both shim and runtime the correct URL to connect
 // directly to the VM.
+// The agent logs can still be forwarded, by reading them from the VM
+// console like the kata-proxy does.
 type noProxy struct {
+	// conn is the console connection the agent logs are read from.
+	conn net.Conn
 }
 
 // start is noProxy start implementation for proxy interface.
@@ -28,21 +36,67 @@ func (p *noProxy) start(params proxyParams) (int, string, error) {
 		return -1, "", fmt.Errorf("proxy logger is not set")
 	}
 
-	params.logger.Debug("No proxy started because of no-proxy implementation")
+	logger := newProxyLogger(params.logger, NoProxyType, params.id)
+	logger.Debug("No proxy started because of no-proxy implementation")
 
 	if params.agentURL == "" {
 		return -1, "", fmt.Errorf("AgentURL cannot be empty")
 	}
 
+	if params.forwardLogs {
+		if params.consoleURL == "" {
+			return -1, "", fmt.Errorf("ConsoleURL cannot be empty to forward the agent logs")
+		}
+
+		if err := p.forwardLogs(params.consoleURL, logger); err != nil {
+			return -1, "", err
+		}
+	}
+
+	proxyStarted(logger, 0, params.agentURL)
+
 	return 0, params.agentURL, nil
 }
 
 // stop is noProxy stop implementation for proxy interface.
 func (p *noProxy) stop(pid int) error {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+
 	return nil
 }
 
-// The noproxy doesn't need to watch the vm console, thus return false always.
+// The noproxy only watches the vm console to forward the agent logs.
 func (p *noProxy) consoleWatched() bool {
-	return false
+	return p.conn != nil
+}
+
+// forwardLogs connects to the console and logs its lines until it is
+// closed.
+func (p *noProxy) forwardLogs(console string, logger *logrus.Entry) error {
+	if p.conn != nil {
+		return fmt.Errorf("agent logs already forwarded")
+	}
+
+	conn, err := net.Dial("unix", console)
+	if err != nil {
+		return err
+	}
+
+	p.conn = conn
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			logger.WithField("vmconsole", scanner.Text()).Debug("reading guest console")
+		}
+
+		if err := scanner.Err(); err != nil {
+			logger.WithError(err).WithField("console-socket", console).Debug("agent logs forwarding stopped")
+		}
+	}()
+
+	return nil
 }
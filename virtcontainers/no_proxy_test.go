@@ -6,8 +6,14 @@
 package virtcontainers
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +40,79 @@ func TestNoProxyStart(t *testing.T) {
 
 	assert.False(p.consoleWatched())
 }
+
+// consoleLogHook sends the guest console lines it is fired with.
+type consoleLogHook struct {
+	lines chan string
+}
+
+func (h *consoleLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *consoleLogHook) Fire(e *logrus.Entry) error {
+	if line, ok := e.Data["vmconsole"]; ok {
+		h.lines <- line.(string)
+	}
+	return nil
+}
+
+func TestNoProxyForwardLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	// the fake console writes the agent logs to its first client
+	consoleURL := filepath.Join(tmpdir, "console.sock")
+	l, err := net.Listen("unix", consoleURL)
+	assert.NoError(err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("agent started\nlistening\n"))
+	}()
+
+	hook := &consoleLogHook{lines: make(chan string, 2)}
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+	logger.Out = ioutil.Discard
+	logger.AddHook(hook)
+
+	params := proxyParams{
+		id:          testSandboxID,
+		agentURL:    "agentURL",
+		logger:      logrus.NewEntry(logger),
+		forwardLogs: true,
+	}
+
+	p := &noProxy{}
+
+	// forwarding needs the console
+	_, _, err = p.start(params)
+	assert.Error(err)
+	assert.False(p.consoleWatched())
+
+	params.consoleURL = consoleURL
+	_, url, err := p.start(params)
+	assert.NoError(err)
+	assert.Equal(params.agentURL, url)
+	assert.True(p.consoleWatched())
+
+	for _, expected := range []string{"agent started", "listening"} {
+		select {
+		case line := <-hook.lines:
+			assert.Equal(expected, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q wasn't forwarded", expected)
+		}
+	}
+
+	assert.NoError(p.stop(0))
+	assert.False(p.consoleWatched())
+}
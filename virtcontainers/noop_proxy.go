@@ -14,6 +14,10 @@ var noopProxyURL = "noopProxyURL"
 // register is the proxy start implementation for testing purpose.
 // It does nothing.
 func (p *noopProxy) start(params proxyParams) (int, string, error) {
+	if params.logger != nil {
+		proxyStarted(newProxyLogger(params.logger, NoopProxyType, params.id), 0, noopProxyURL)
+	}
+
 	return 0, noopProxyURL, nil
 }
 
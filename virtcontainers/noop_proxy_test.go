@@ -6,11 +6,43 @@
 package virtcontainers
 
 import (
+	"context"
+	"sync"
 	"testing"
 
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/stretchr/testify/assert"
 )
 
+// recordingProxy is a noopProxy recording the parameters of the start
+// calls, for the tests to check how the runtime started the proxy.
+type recordingProxy struct {
+	noopProxy
+
+	mu     sync.Mutex
+	params []proxyParams
+}
+
+func (p *recordingProxy) start(params proxyParams) (int, string, error) {
+	p.mu.Lock()
+	p.params = append(p.params, params)
+	p.mu.Unlock()
+
+	return p.noopProxy.start(params)
+}
+
+// startParams returns the parameters of the start calls so far.
+func (p *recordingProxy) startParams() []proxyParams {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	params := make([]proxyParams, len(p.params))
+	copy(params, p.params)
+
+	return params
+}
+
 func TestNoopProxy(t *testing.T) {
 	n := &noopProxy{}
 	assert := assert.New(t)
@@ -24,3 +56,50 @@ func TestNoopProxy(t *testing.T) {
 
 	assert.False(n.consoleWatched())
 }
+
+func TestRecordingProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		id:  testSandboxID,
+		config: &SandboxConfig{
+			ProxyType: NoopProxyType,
+			ProxyConfig: ProxyConfig{
+				Path:        "/usr/libexec/kata-containers/kata-proxy",
+				Debug:       true,
+				ForwardLogs: true,
+			},
+		},
+		hypervisor: &mockHypervisor{},
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	defer vcStore.Delete()
+	sandbox.store = vcStore
+
+	p := &recordingProxy{}
+	k := &kataAgent{
+		ctx:      sandbox.ctx,
+		proxy:    p,
+		vmSocket: types.Socket{HostPath: "/run/vc/sbs/" + testSandboxID + "/kata.sock"},
+	}
+
+	assert.NoError(k.startProxy(sandbox))
+	assert.Equal(noopProxyURL, k.state.URL)
+
+	params := p.startParams()
+	if assert.Len(params, 1) {
+		assert.Equal(testSandboxID, params[0].id)
+		assert.Equal(sandbox.config.ProxyConfig.Path, params[0].path)
+		assert.Equal("/run/vc/sbs/"+testSandboxID+"/kata.sock", params[0].agentURL)
+		assert.True(params[0].debug)
+		assert.True(params[0].forwardLogs)
+		assert.NotNil(params[0].logger)
+	}
+
+	// an already started proxy isn't started again
+	assert.NoError(k.startProxy(sandbox))
+	assert.Len(p.startParams(), 1)
+}
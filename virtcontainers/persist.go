@@ -46,6 +46,8 @@ func (s *Sandbox) dumpState(ss *persistapi.SandboxState, cs map[string]persistap
 			FsType:        cont.state.Fstype,
 		}
 		state.CgroupPath = cont.state.CgroupPath
+		state.RestartCount = cont.state.RestartCount
+		state.LastExitCode = cont.state.LastExitCode
 		cs[id] = state
 	}
 
@@ -187,6 +189,8 @@ func (c *Container) loadContState(cs persistapi.ContainerState) {
 		BlockDeviceID: cs.Rootfs.BlockDeviceID,
 		Fstype:        cs.Rootfs.FsType,
 		CgroupPath:    cs.CgroupPath,
+		RestartCount:  cs.RestartCount,
+		LastExitCode:  cs.LastExitCode,
 	}
 }
 
@@ -96,6 +96,13 @@ type ContainerState struct {
 	// including the hypervisor are placed.
 	CgroupPath string
 
+	// RestartCount is the number of times the container has been started
+	// again after being stopped.
+	RestartCount int
+
+	// LastExitCode is the exit code of the last container process.
+	LastExitCode int32
+
 	// DeviceMaps is mapping between sandbox device to dest in container
 	DeviceMaps []DeviceMap
 
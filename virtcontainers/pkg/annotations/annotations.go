@@ -49,6 +49,46 @@ const (
 
 	// ContainerTypeKey is the annotation key to fetch container type.
 	ContainerTypeKey = vcAnnotationsPrefix + "pkg.oci.container_type"
+
+	// ContainerOrder is a container annotation for passing the position of
+	// the container in the pod dependency order, e.g for init containers.
+	// The containers are deleted in the reverse order.
+	ContainerOrder = vcAnnotationsPrefix + "ContainerOrder"
+
+	// EnableMemoryHotplug is a sandbox annotation for disabling, "false",
+	// the hotplug of the VM memory when the container memory limits change.
+	// "true" keeps the hotplug of the runtime configuration.
+	EnableMemoryHotplug = vcAnnotationsPrefix + "EnableMemoryHotplug"
+
+	// ProxyType is a sandbox annotation for overriding the proxy type of
+	// the runtime configuration.
+	ProxyType = vcAnnotationsPrefix + "ProxyType"
+
+	// StopSignal is a container annotation for passing the signal, by name
+	// or by number, sent to the container when it is stopped gracefully.
+	StopSignal = vcAnnotationsPrefix + "StopSignal"
+
+	// StopTimeout is a container annotation for passing the number of
+	// seconds a container is given to stop before it is killed.
+	StopTimeout = vcAnnotationsPrefix + "StopTimeout"
+
+	// BlkioWeight is a container annotation for passing the relative
+	// block IO weight of the container, between 10 and 1000.
+	BlkioWeight = vcAnnotationsPrefix + "BlkioWeight"
+
+	// BlkioReadBps, BlkioWriteBps, BlkioReadIOPS and BlkioWriteIOPS are
+	// container annotations for passing the block IO throttling limits
+	// of the container, as a comma separated list of major:minor=rate
+	// entries. The device numbers are the ones of the guest devices.
+	BlkioReadBps   = vcAnnotationsPrefix + "BlkioReadBps"
+	BlkioWriteBps  = vcAnnotationsPrefix + "BlkioWriteBps"
+	BlkioReadIOPS  = vcAnnotationsPrefix + "BlkioReadIOPS"
+	BlkioWriteIOPS = vcAnnotationsPrefix + "BlkioWriteIOPS"
+
+	// EphemeralStorageSizeLimit is a container annotation for passing the
+	// size, in bytes, of the guest tmpfs backing each memory-backed
+	// ephemeral volume of the container.
+	EphemeralStorageSizeLimit = vcAnnotationsPrefix + "EphemeralStorageSizeLimit"
 )
 
 const (
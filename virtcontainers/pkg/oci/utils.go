@@ -136,6 +136,43 @@ type RuntimeConfig struct {
 	//Determines if seccomp should be applied inside guest
 	DisableGuestSeccomp bool
 
+	//Maximum size in bytes of the ephemeral volumes of a container
+	EphemeralStorageMaxSize uint64
+
+	//Determines if the guest clock is set from the host one at sandbox start
+	SyncGuestTime bool
+
+	//Directory the shim copies the container output to, if set
+	OutputLogDir string
+
+	//Determines how the shim confirms a container stop, "agent" or "hypervisor"
+	StopConfirmation string
+
+	//Maximum number of output lines per second forwarded for each container, 0 disables the limit
+	LogRateLimit uint32
+
+	//Size of the exec terminals started without any explicit size, 0 selects the default
+	TerminalHeight uint32
+	TerminalWidth  uint32
+
+	//Seconds after which an exec whose exit hasn't been observed is reported as exited, 0 waits forever
+	ExecWaitTimeout uint32
+
+	//Size in bytes of the buffers copying the process IO streams, 0 selects the default
+	IOBufferSize uint32
+
+	//Maximum number of execs running at once in each container, 0 disables the limit
+	MaxExecs uint32
+
+	//Seconds bounding the stop of all the containers when the shim is terminated, 0 selects the default
+	DrainTimeout uint32
+
+	//Determines if a pod can override the proxy type through its annotation
+	AllowProxyTypeAnnotation bool
+
+	//Determines if a container rootfs which cannot be unmounted is left mounted instead of being lazily detached
+	StrictUnmount bool
+
 	//Determines if create a netns for hypervisor process
 	DisableNewNetNs bool
 
@@ -453,6 +490,66 @@ func addAssetAnnotations(ocispec CompatOCISpec, config *vc.SandboxConfig) {
 	}
 }
 
+// memoryHotplugDisabled returns whether the memory hotplug is disabled for
+// the sandbox, according to the runtime configuration and to its annotation.
+// The annotation can only disable the memory hotplug, a pod must not enable
+// it when the runtime configuration disables it.
+func memoryHotplugDisabled(ocispec CompatOCISpec, runtime RuntimeConfig) (bool, error) {
+	value, ok := ocispec.Annotations[vcAnnotations.EnableMemoryHotplug]
+	if !ok {
+		return runtime.HypervisorConfig.DisableMemoryHotplug, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s annotation %q: %v", vcAnnotations.EnableMemoryHotplug, value, err)
+	}
+
+	return runtime.HypervisorConfig.DisableMemoryHotplug || !enabled, nil
+}
+
+// ProxyConfig returns the proxy type and configuration of the runtime
+// configuration, the proxy type being overridden by the ProxyType
+// annotation of the spec when the runtime configuration allows it. The proxy
+// binary is only taken from the runtime configuration, a pod must not pick
+// the host binary the runtime executes, and the proxy type must match the
+// connection to the agent the hypervisor is configured for.
+// The overridden configuration is validated.
+func ProxyConfig(ocispec CompatOCISpec, runtime RuntimeConfig) (vc.ProxyType, vc.ProxyConfig, error) {
+	proxyType, proxyConfig := runtime.ProxyType, runtime.ProxyConfig
+
+	value, ok := ocispec.Annotations[vcAnnotations.ProxyType]
+	if !ok {
+		return proxyType, proxyConfig, nil
+	}
+
+	if !runtime.AllowProxyTypeAnnotation {
+		ociLog.Warnf("Ignoring the %s annotation, not allowed by the runtime configuration", vcAnnotations.ProxyType)
+		return proxyType, proxyConfig, nil
+	}
+
+	if err := proxyType.Set(value); err != nil {
+		return "", vc.ProxyConfig{}, fmt.Errorf("Invalid %s annotation: %v", vcAnnotations.ProxyType, err)
+	}
+
+	// the noop proxy never forwards anything, and the agent is only
+	// reached without any proxy through VSOCK.
+	switch {
+	case proxyType == vc.NoopProxyType:
+		return "", vc.ProxyConfig{}, fmt.Errorf("Invalid %s annotation: proxy type %s not allowed", vcAnnotations.ProxyType, value)
+	case runtime.HypervisorConfig.UseVSock && proxyType != vc.NoProxyType:
+		return "", vc.ProxyConfig{}, fmt.Errorf("Invalid %s annotation: proxy type %s cannot be used with VSOCK", vcAnnotations.ProxyType, value)
+	case !runtime.HypervisorConfig.UseVSock && proxyType == vc.NoProxyType:
+		return "", vc.ProxyConfig{}, fmt.Errorf("Invalid %s annotation: proxy type %s requires VSOCK", vcAnnotations.ProxyType, value)
+	}
+
+	if err := vc.ValidateProxyConfig(proxyType, proxyConfig); err != nil {
+		return "", vc.ProxyConfig{}, fmt.Errorf("Invalid %s annotation: %v", vcAnnotations.ProxyType, err)
+	}
+
+	return proxyType, proxyConfig, nil
+}
+
 // SandboxConfig converts an OCI compatible runtime configuration file
 // to a virtcontainers sandbox configuration structure.
 func SandboxConfig(ocispec CompatOCISpec, runtime RuntimeConfig, bundlePath, cid, console string, detach, systemdCgroup bool) (vc.SandboxConfig, error) {
@@ -471,6 +568,17 @@ func SandboxConfig(ocispec CompatOCISpec, runtime RuntimeConfig, bundlePath, cid
 		return vc.SandboxConfig{}, err
 	}
 
+	proxyType, proxyConfig, err := ProxyConfig(ocispec, runtime)
+	if err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	hypervisorConfig := runtime.HypervisorConfig
+	hypervisorConfig.DisableMemoryHotplug, err = memoryHotplugDisabled(ocispec, runtime)
+	if err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
 	ociSpecJSON, err := json.Marshal(ocispec)
 	if err != nil {
 		return vc.SandboxConfig{}, err
@@ -482,13 +590,13 @@ func SandboxConfig(ocispec CompatOCISpec, runtime RuntimeConfig, bundlePath, cid
 		Hostname: ocispec.Hostname,
 
 		HypervisorType:   runtime.HypervisorType,
-		HypervisorConfig: runtime.HypervisorConfig,
+		HypervisorConfig: hypervisorConfig,
 
 		AgentType:   runtime.AgentType,
 		AgentConfig: runtime.AgentConfig,
 
-		ProxyType:   runtime.ProxyType,
-		ProxyConfig: runtime.ProxyConfig,
+		ProxyType:   proxyType,
+		ProxyConfig: proxyConfig,
 
 		ShimType:   runtime.ShimType,
 		ShimConfig: runtime.ShimConfig,
@@ -508,6 +616,10 @@ func SandboxConfig(ocispec CompatOCISpec, runtime RuntimeConfig, bundlePath, cid
 
 		DisableGuestSeccomp: runtime.DisableGuestSeccomp,
 
+		EphemeralStorageMaxSize: runtime.EphemeralStorageMaxSize,
+
+		SyncGuestTime: runtime.SyncGuestTime,
+
 		Experimental: runtime.Experimental,
 	}
 
@@ -856,3 +856,122 @@ func TestMain(m *testing.M) {
 
 	os.Exit(m.Run())
 }
+
+func TestProxyConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	proxyPath := filepath.Join(dir, "proxy")
+	err = ioutil.WriteFile(proxyPath, []byte("#!/bin/sh\n"), 0700)
+	assert.NoError(err)
+
+	runtimeConfig := RuntimeConfig{
+		ProxyType:                vc.KataBuiltInProxyType,
+		ProxyConfig:              vc.ProxyConfig{Path: proxyPath},
+		AllowProxyTypeAnnotation: true,
+	}
+
+	data := []struct {
+		annotations map[string]string
+		useVSock    bool
+		proxyType   vc.ProxyType
+		valid       bool
+	}{
+		// no annotation, the runtime configuration is used
+		{nil, false, vc.KataBuiltInProxyType, true},
+		{map[string]string{"foo": "bar"}, false, vc.KataBuiltInProxyType, true},
+		// overrides
+		{map[string]string{vcAnnotations.ProxyType: "kataProxy"}, false, vc.KataProxyType, true},
+		{map[string]string{vcAnnotations.ProxyType: "noProxy"}, true, vc.NoProxyType, true},
+		// the proxy binary can't be picked by the pod
+		{map[string]string{"com.github.containers.virtcontainers.ProxyPath": dir}, false, vc.KataBuiltInProxyType, true},
+		// the proxy type must match the connection to the agent
+		{map[string]string{vcAnnotations.ProxyType: "noProxy"}, false, "", false},
+		{map[string]string{vcAnnotations.ProxyType: "kataProxy"}, true, "", false},
+		// invalid values
+		{map[string]string{vcAnnotations.ProxyType: "noopProxy"}, false, "", false},
+		{map[string]string{vcAnnotations.ProxyType: "noopProxy"}, true, "", false},
+		{map[string]string{vcAnnotations.ProxyType: "foobar"}, false, "", false},
+		{map[string]string{vcAnnotations.ProxyType: ""}, false, "", false},
+	}
+
+	for i, d := range data {
+		ocispec := CompatOCISpec{}
+		ocispec.Annotations = d.annotations
+		runtimeConfig.HypervisorConfig.UseVSock = d.useVSock
+
+		proxyType, proxyConfig, err := ProxyConfig(ocispec, runtimeConfig)
+		if !d.valid {
+			assert.Error(err, "test %d", i)
+			continue
+		}
+
+		assert.NoError(err, "test %d", i)
+		assert.Equal(d.proxyType, proxyType, "test %d", i)
+		assert.Equal(proxyPath, proxyConfig.Path, "test %d", i)
+	}
+
+	// the annotation is ignored unless allowed by the runtime configuration
+	runtimeConfig.HypervisorConfig.UseVSock = false
+	runtimeConfig.AllowProxyTypeAnnotation = false
+	ocispec := CompatOCISpec{}
+	ocispec.Annotations = map[string]string{vcAnnotations.ProxyType: "kataProxy"}
+	proxyType, _, err := ProxyConfig(ocispec, runtimeConfig)
+	assert.NoError(err)
+	assert.Equal(vc.KataBuiltInProxyType, proxyType)
+
+	// the overridden configuration must be valid
+	runtimeConfig.AllowProxyTypeAnnotation = true
+	runtimeConfig.ProxyConfig.Path = dir
+	_, _, err = ProxyConfig(ocispec, runtimeConfig)
+	assert.Error(err)
+
+	// the runtime configuration is not modified
+	assert.Equal(vc.KataBuiltInProxyType, runtimeConfig.ProxyType)
+}
+
+func TestMemoryHotplugDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		annotations    map[string]string
+		runtimeDisable bool
+		disabled       bool
+		valid          bool
+	}{
+		// absent, the runtime configuration is used
+		{nil, false, false, true},
+		{nil, true, true, true},
+		{map[string]string{"foo": "bar"}, true, true, true},
+		// the annotation can only disable the memory hotplug
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "true"}, false, false, true},
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "true"}, true, true, true},
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "false"}, false, true, true},
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "false"}, true, true, true},
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "0"}, false, true, true},
+		// invalid values
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: ""}, false, false, false},
+		{map[string]string{vcAnnotations.EnableMemoryHotplug: "yes please"}, false, false, false},
+	}
+
+	for i, d := range data {
+		ocispec := CompatOCISpec{}
+		ocispec.Annotations = d.annotations
+
+		runtimeConfig := RuntimeConfig{
+			HypervisorConfig: vc.HypervisorConfig{DisableMemoryHotplug: d.runtimeDisable},
+		}
+
+		disabled, err := memoryHotplugDisabled(ocispec, runtimeConfig)
+		if !d.valid {
+			assert.Error(err, "test %d", i)
+			continue
+		}
+
+		assert.NoError(err, "test %d", i)
+		assert.Equal(d.disabled, disabled, "test %d", i)
+	}
+}
@@ -15,6 +15,7 @@ var (
 	ErrNeedSandboxID     = errors.New("Sandbox ID cannot be empty")
 	ErrNeedContainerID   = errors.New("Container ID cannot be empty")
 	ErrNeedState         = errors.New("State cannot be empty")
+	ErrNoSuchSandbox     = errors.New("Sandbox does not exist")
 	ErrNoSuchContainer   = errors.New("Container does not exist")
 	ErrInvalidConfigType = errors.New("Invalid config type")
 )
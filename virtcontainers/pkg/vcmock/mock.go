@@ -110,6 +110,15 @@ func (m *VCMock) ListSandbox(ctx context.Context) ([]vc.SandboxStatus, error) {
 	return nil, fmt.Errorf("%s: %s", mockErrorPrefix, getSelf())
 }
 
+// ListSandboxes implements the VC function of the same name.
+func (m *VCMock) ListSandboxes(ctx context.Context) ([]vc.SandboxListEntry, error) {
+	if m.ListSandboxesFunc != nil {
+		return m.ListSandboxesFunc(ctx)
+	}
+
+	return nil, fmt.Errorf("%s: %s", mockErrorPrefix, getSelf())
+}
+
 // StatusSandbox implements the VC function of the same name.
 func (m *VCMock) StatusSandbox(ctx context.Context, sandboxID string) (vc.SandboxStatus, error) {
 	if m.StatusSandboxFunc != nil {
@@ -191,6 +200,24 @@ func (m *VCMock) StatusContainer(ctx context.Context, sandboxID, containerID str
 	return vc.ContainerStatus{}, fmt.Errorf("%s: %s (%+v): sandboxID: %v, containerID: %v", mockErrorPrefix, getSelf(), m, sandboxID, containerID)
 }
 
+// FetchContainerSpec implements the VC function of the same name.
+func (m *VCMock) FetchContainerSpec(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error) {
+	if m.FetchContainerSpecFunc != nil {
+		return m.FetchContainerSpecFunc(ctx, sandboxID, containerID)
+	}
+
+	return nil, fmt.Errorf("%s: %s (%+v): sandboxID: %v, containerID: %v", mockErrorPrefix, getSelf(), m, sandboxID, containerID)
+}
+
+// GetContainerAnnotations implements the VC function of the same name.
+func (m *VCMock) GetContainerAnnotations(ctx context.Context, sandboxID, containerID string) (map[string]string, error) {
+	if m.GetContainerAnnotationsFunc != nil {
+		return m.GetContainerAnnotationsFunc(ctx, sandboxID, containerID)
+	}
+
+	return nil, fmt.Errorf("%s: %s (%+v): sandboxID: %v, containerID: %v", mockErrorPrefix, getSelf(), m, sandboxID, containerID)
+}
+
 // StatsContainer implements the VC function of the same name.
 func (m *VCMock) StatsContainer(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error) {
 	if m.StatsContainerFunc != nil {
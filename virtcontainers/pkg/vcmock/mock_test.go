@@ -15,6 +15,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/factory"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -197,6 +198,33 @@ func TestVCMockListSandbox(t *testing.T) {
 	assert.True(IsMockError(err))
 }
 
+func TestVCMockListSandboxes(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.ListSandboxesFunc)
+
+	ctx := context.Background()
+	_, err := m.ListSandboxes(ctx)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.ListSandboxesFunc = func(ctx context.Context) ([]vc.SandboxListEntry, error) {
+		return []vc.SandboxListEntry{}, nil
+	}
+
+	sandboxes, err := m.ListSandboxes(ctx)
+	assert.NoError(err)
+	assert.Equal(sandboxes, []vc.SandboxListEntry{})
+
+	// reset
+	m.ListSandboxesFunc = nil
+
+	_, err = m.ListSandboxes(ctx)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
 func TestVCMockPauseSandbox(t *testing.T) {
 	assert := assert.New(t)
 
@@ -533,6 +561,60 @@ func TestVCMockStatusContainer(t *testing.T) {
 	assert.True(IsMockError(err))
 }
 
+func TestVCMockFetchContainerSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.FetchContainerSpecFunc)
+
+	ctx := context.Background()
+	_, err := m.FetchContainerSpec(ctx, testSandboxID, testContainerID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.FetchContainerSpecFunc = func(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error) {
+		return &specs.Spec{}, nil
+	}
+
+	spec, err := m.FetchContainerSpec(ctx, testSandboxID, testContainerID)
+	assert.NoError(err)
+	assert.Equal(spec, &specs.Spec{})
+
+	// reset
+	m.FetchContainerSpecFunc = nil
+
+	_, err = m.FetchContainerSpec(ctx, testSandboxID, testContainerID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
+func TestVCMockGetContainerAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.GetContainerAnnotationsFunc)
+
+	ctx := context.Background()
+	_, err := m.GetContainerAnnotations(ctx, testSandboxID, testContainerID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.GetContainerAnnotationsFunc = func(ctx context.Context, sandboxID, containerID string) (map[string]string, error) {
+		return map[string]string{"foo": "bar"}, nil
+	}
+
+	annotations, err := m.GetContainerAnnotations(ctx, testSandboxID, testContainerID)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"foo": "bar"}, annotations)
+
+	// reset
+	m.GetContainerAnnotationsFunc = nil
+
+	_, err = m.GetContainerAnnotations(ctx, testSandboxID, testContainerID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
 func TestVCMockStatsContainer(t *testing.T) {
 	assert := assert.New(t)
 
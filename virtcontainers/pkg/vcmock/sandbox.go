@@ -6,6 +6,7 @@
 package vcmock
 
 import (
+	"context"
 	"io"
 	"syscall"
 
@@ -168,11 +169,21 @@ func (s *Sandbox) WaitProcess(containerID, processID string) (int32, error) {
 	return 0, nil
 }
 
+// WaitProcessWithContext implements the VCSandbox function of the same name.
+func (s *Sandbox) WaitProcessWithContext(ctx context.Context, containerID, processID string) (int32, error) {
+	return 0, nil
+}
+
 // SignalProcess implements the VCSandbox function of the same name.
 func (s *Sandbox) SignalProcess(containerID, processID string, signal syscall.Signal, all bool) error {
 	return nil
 }
 
+// Capabilities implements the VCSandbox function of the same name.
+func (s *Sandbox) Capabilities() types.Capabilities {
+	return types.Capabilities{}
+}
+
 // WinsizeProcess implements the VCSandbox function of the same name.
 func (s *Sandbox) WinsizeProcess(containerID, processID string, height, width uint32) error {
 	return nil
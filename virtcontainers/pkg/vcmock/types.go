@@ -47,6 +47,7 @@ type VCMock struct {
 	CreateSandboxFunc  func(ctx context.Context, sandboxConfig vc.SandboxConfig) (vc.VCSandbox, error)
 	DeleteSandboxFunc  func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
 	ListSandboxFunc    func(ctx context.Context) ([]vc.SandboxStatus, error)
+	ListSandboxesFunc  func(ctx context.Context) ([]vc.SandboxListEntry, error)
 	FetchSandboxFunc   func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
 	PauseSandboxFunc   func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
 	ResumeSandboxFunc  func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
@@ -56,17 +57,19 @@ type VCMock struct {
 	StatsContainerFunc func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error)
 	StopSandboxFunc    func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
 
-	CreateContainerFunc      func(ctx context.Context, sandboxID string, containerConfig vc.ContainerConfig) (vc.VCSandbox, vc.VCContainer, error)
-	DeleteContainerFunc      func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
-	EnterContainerFunc       func(ctx context.Context, sandboxID, containerID string, cmd types.Cmd) (vc.VCSandbox, vc.VCContainer, *vc.Process, error)
-	KillContainerFunc        func(ctx context.Context, sandboxID, containerID string, signal syscall.Signal, all bool) error
-	StartContainerFunc       func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
-	StatusContainerFunc      func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStatus, error)
-	StopContainerFunc        func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
-	ProcessListContainerFunc func(ctx context.Context, sandboxID, containerID string, options vc.ProcessListOptions) (vc.ProcessList, error)
-	UpdateContainerFunc      func(ctx context.Context, sandboxID, containerID string, resources specs.LinuxResources) error
-	PauseContainerFunc       func(ctx context.Context, sandboxID, containerID string) error
-	ResumeContainerFunc      func(ctx context.Context, sandboxID, containerID string) error
+	CreateContainerFunc         func(ctx context.Context, sandboxID string, containerConfig vc.ContainerConfig) (vc.VCSandbox, vc.VCContainer, error)
+	DeleteContainerFunc         func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
+	EnterContainerFunc          func(ctx context.Context, sandboxID, containerID string, cmd types.Cmd) (vc.VCSandbox, vc.VCContainer, *vc.Process, error)
+	KillContainerFunc           func(ctx context.Context, sandboxID, containerID string, signal syscall.Signal, all bool) error
+	StartContainerFunc          func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
+	StatusContainerFunc         func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStatus, error)
+	FetchContainerSpecFunc      func(ctx context.Context, sandboxID, containerID string) (*specs.Spec, error)
+	GetContainerAnnotationsFunc func(ctx context.Context, sandboxID, containerID string) (map[string]string, error)
+	StopContainerFunc           func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
+	ProcessListContainerFunc    func(ctx context.Context, sandboxID, containerID string, options vc.ProcessListOptions) (vc.ProcessList, error)
+	UpdateContainerFunc         func(ctx context.Context, sandboxID, containerID string, resources specs.LinuxResources) error
+	PauseContainerFunc          func(ctx context.Context, sandboxID, containerID string) error
+	ResumeContainerFunc         func(ctx context.Context, sandboxID, containerID string) error
 
 	AddDeviceFunc func(ctx context.Context, sandboxID string, info config.DeviceInfo) (api.Device, error)
 
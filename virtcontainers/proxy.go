@@ -7,10 +7,17 @@ package virtcontainers
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // ProxyConfig is a structure storing information needed from any
@@ -18,6 +25,18 @@ import (
 type ProxyConfig struct {
 	Path  string
 	Debug bool
+
+	// StopGracePeriod is the time given to a proxy process to terminate
+	// after SIGTERM, before it gets killed. Zero means the default.
+	StopGracePeriod time.Duration
+
+	// SocketWaitTimeout is the time given to a proxy process to make
+	// its socket connectable when starting. Zero means not waiting.
+	SocketWaitTimeout time.Duration
+
+	// ForwardLogs makes the no proxy forward the agent logs read from
+	// the guest console, as the kata-proxy does.
+	ForwardLogs bool
 }
 
 // proxyParams is the structure providing specific parameters needed
@@ -29,6 +48,16 @@ type proxyParams struct {
 	consoleURL string
 	logger     *logrus.Entry
 	debug      bool
+
+	stopGracePeriod time.Duration
+
+	// waitTimeout is the time start() waits for the proxy socket to be
+	// connectable before returning. Zero means not waiting at all.
+	waitTimeout time.Duration
+
+	// forwardLogs makes the proxy forward the agent logs read from the
+	// console, if it doesn't already.
+	forwardLogs bool
 }
 
 // ProxyType describes a proxy type.
@@ -46,8 +75,20 @@ const (
 
 	// KataBuiltInProxyType is the kataBuiltInProxy.
 	KataBuiltInProxyType ProxyType = "kataBuiltInProxy"
+
+	// DefaultProxyType is the proxy used when no proxy type is set,
+	// i.e. for the zero ProxyType value.
+	DefaultProxyType = KataBuiltInProxyType
 )
 
+// defaultProxySocketName is the name of the proxy UNIX socket, in the
+// sandbox runtime directory, when none is given.
+const defaultProxySocketName = "proxy.sock"
+
+// defaultProxyStopGracePeriod is the time given by default to a proxy
+// process to terminate after SIGTERM.
+const defaultProxyStopGracePeriod = 3 * time.Second
+
 const (
 	// unix socket type of console
 	consoleProtoUnix = "unix"
@@ -92,11 +133,15 @@ func (pType *ProxyType) String() string {
 	}
 }
 
-// newProxy returns a proxy from a proxy type.
+// newProxy returns a proxy from a proxy type. The zero proxy type selects
+// DefaultProxyType, any other value outside of the known proxy types is an
+// error.
 func newProxy(pType ProxyType) (proxy, error) {
+	if pType == "" {
+		pType = DefaultProxyType
+	}
+
 	switch pType {
-	case "":
-		return &kataBuiltInProxy{}, nil
 	case NoopProxyType:
 		return &noopProxy{}, nil
 	case NoProxyType:
@@ -106,43 +151,227 @@ func newProxy(pType ProxyType) (proxy, error) {
 	case KataBuiltInProxyType:
 		return &kataBuiltInProxy{}, nil
 	default:
-		return &noopProxy{}, fmt.Errorf("Invalid proxy type: %s", pType)
+		return nil, fmt.Errorf("Invalid proxy type %q, expecting one of %s, %s, %s or %s",
+			pType, NoopProxyType, NoProxyType, KataProxyType, KataBuiltInProxyType)
 	}
 }
 
-func validateProxyParams(p proxyParams) error {
-	if len(p.path) == 0 || len(p.id) == 0 || len(p.agentURL) == 0 || len(p.consoleURL) == 0 {
-		return fmt.Errorf("Invalid proxy parameters %+v", p)
+// proxyParamsErrors gathers all the problems found in proxy parameters.
+type proxyParamsErrors []error
+
+func (e proxyParamsErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// validateAllProxyParams checks the proxy parameters and reports all the
+// missing or invalid ones at once, or none if the parameters are valid.
+func validateAllProxyParams(p proxyParams) proxyParamsErrors {
+	var errs proxyParamsErrors
+
+	if len(p.path) == 0 {
+		errs = append(errs, fmt.Errorf("Invalid proxy parameter: proxy path is empty"))
+	}
+
+	if len(p.id) == 0 {
+		errs = append(errs, fmt.Errorf("Invalid proxy parameter: sandbox ID is empty"))
+	}
+
+	if len(p.agentURL) == 0 {
+		errs = append(errs, fmt.Errorf("Invalid proxy parameter: agent URL is empty"))
+	}
+
+	if len(p.consoleURL) == 0 {
+		errs = append(errs, fmt.Errorf("Invalid proxy parameter: console URL is empty"))
 	}
 
 	if p.logger == nil {
-		return fmt.Errorf("Invalid proxy parameter: proxy logger is not set")
+		errs = append(errs, fmt.Errorf("Invalid proxy parameter: proxy logger is not set"))
+	}
+
+	return errs
+}
+
+// validateProxyParams checks the proxy parameters, returning the first
+// problem found.
+func validateProxyParams(p proxyParams) error {
+	if errs := validateAllProxyParams(p); len(errs) != 0 {
+		return errs[0]
 	}
 
 	return nil
 }
 
-func validateProxyConfig(proxyConfig ProxyConfig) error {
+// ValidateProxyConfig checks the proxy configuration is usable by the given
+// proxy type, see validateProxyConfig.
+func ValidateProxyConfig(proxyType ProxyType, proxyConfig ProxyConfig) error {
+	return validateProxyConfig(proxyType, proxyConfig)
+}
+
+// validateProxyConfig checks the proxy configuration is usable by the given
+// proxy type. The proxy path is only relevant, and must then point to an
+// executable file, for the proxies running a dedicated host process.
+func validateProxyConfig(proxyType ProxyType, proxyConfig ProxyConfig) error {
+	if !isProcessProxy(proxyType) {
+		return nil
+	}
+
 	if len(proxyConfig.Path) == 0 {
 		return fmt.Errorf("Proxy path cannot be empty")
 	}
 
+	fi, err := os.Stat(proxyConfig.Path)
+	if err != nil {
+		return fmt.Errorf("Invalid proxy path %q: %v", proxyConfig.Path, err)
+	}
+
+	if fi.IsDir() {
+		return fmt.Errorf("Invalid proxy path %q: is a directory", proxyConfig.Path)
+	}
+
+	if err := unix.Access(proxyConfig.Path, unix.X_OK); err != nil {
+		return fmt.Errorf("Invalid proxy path %q: not executable: %v", proxyConfig.Path, err)
+	}
+
 	return nil
 }
 
-func defaultProxyURL(id, socketType string) (string, error) {
+// defaultProxyURL returns the URL the proxy should listen to. The
+// socketName parameter is only used for the UNIX socket type, it is the
+// name of the socket in the sandbox runtime directory and defaults to
+// defaultProxySocketName when empty. The vsock parameter is only used, and
+// must then carry a non-zero context ID and port, for the VSOCK socket
+// type.
+func defaultProxyURL(id, socketType, socketName string, vsock *kataVSOCK) (string, error) {
 	switch socketType {
 	case SocketTypeUNIX:
-		socketPath := filepath.Join(store.SandboxRuntimeRootPath(id), "proxy.sock")
+		if socketName == "" {
+			socketName = defaultProxySocketName
+		}
+		// The socket must live in the sandbox runtime directory.
+		if socketName != filepath.Base(socketName) || socketName == "." || socketName == ".." {
+			return "", fmt.Errorf("Invalid proxy socket name %q", socketName)
+		}
+		socketPath := filepath.Join(store.SandboxRuntimeRootPath(id), socketName)
 		return fmt.Sprintf("unix://%s", socketPath), nil
 	case SocketTypeVSOCK:
-		// TODO Build the VSOCK default URL
-		return "", nil
+		if vsock == nil || vsock.contextID == 0 || vsock.port == 0 {
+			return "", fmt.Errorf("Invalid vsock proxy address %+v: context ID and port cannot be zero", vsock)
+		}
+		return vsock.String(), nil
 	default:
 		return "", fmt.Errorf("Unknown socket type: %s", socketType)
 	}
 }
 
+// waitProxySocket polls the proxy socket until it accepts connections,
+// backing off between the attempts, or until the timeout elapses.
+func waitProxySocket(socketPath string, timeout time.Duration) error {
+	var err error
+	var conn net.Conn
+
+	delay := 10 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if conn, err = net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			break
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+	}
+
+	return errors.Wrapf(err, "proxy socket %s not connectable after %v", socketPath, timeout)
+}
+
+// terminateProxyProcess sends SIGTERM to the proxy process, and kills it
+// with SIGKILL if it is still running after the grace period.
+func terminateProxyProcess(pid int, gracePeriod time.Duration) error {
+	// Never signal a process group, or every process.
+	if pid <= 0 {
+		return nil
+	}
+
+	if gracePeriod <= 0 {
+		gracePeriod = defaultProxyStopGracePeriod
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(gracePeriod)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, syscall.Signal(0)); err == syscall.ESRCH {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	return nil
+}
+
+// newProxyLogger returns the logger tracing the lifecycle of a proxy. All
+// the proxy implementations use it so that every proxy log entry carries
+// the same field set, the pid and uri being only known once the proxy has
+// been started, see proxyStarted().
+func newProxyLogger(logger *logrus.Entry, pType ProxyType, sandboxID string) *logrus.Entry {
+	return logger.WithFields(logrus.Fields{
+		"proxy-type": pType,
+		"sandbox-id": sandboxID,
+		"pid":        -1,
+		"uri":        "",
+	})
+}
+
+// proxyStarted attaches the pid and uri of a started proxy to its lifecycle
+// logger, logs the start and returns the logger for the later events.
+func proxyStarted(logger *logrus.Entry, pid int, uri string) *logrus.Entry {
+	logger = logger.WithFields(logrus.Fields{
+		"pid": pid,
+		"uri": uri,
+	})
+	logger.Info("Proxy started")
+
+	return logger
+}
+
+// isProcessProxy tells if the proxy type runs a dedicated host process.
+// Only those proxies return a positive PID from start().
+func isProcessProxy(pType ProxyType) bool {
+	return pType == KataProxyType
+}
+
+// validateProxyPid checks the PID returned by the start() of a proxy of the
+// given type is consistent with it running a process or not.
+func validateProxyPid(pType ProxyType, pid int) error {
+	if isProcessProxy(pType) && pid <= 0 {
+		return fmt.Errorf("Invalid PID %d for %s proxy, a positive PID is expected", pid, pType)
+	}
+
+	if !isProcessProxy(pType) && pid > 0 {
+		return fmt.Errorf("Invalid PID %d for %s proxy, it does not run any process", pid, pType)
+	}
+
+	return nil
+}
+
 func isProxyBuiltIn(pType ProxyType) bool {
 	return pType == KataBuiltInProxyType
 }
@@ -151,10 +380,14 @@ func isProxyBuiltIn(pType ProxyType) bool {
 type proxy interface {
 	// start launches a proxy instance with specified parameters, returning
 	// the PID of the process and the URL used to connect to it.
+	// Only the proxies running a process (see isProcessProxy) return
+	// a positive PID, the others return 0 or -1.
 	start(params proxyParams) (int, string, error)
 
 	// stop terminates a proxy instance after all communications with the
-	// agent inside the VM have been properly stopped.
+	// agent inside the VM have been properly stopped. The proxies running
+	// a process first ask it to terminate, and kill it after a grace
+	// period.
 	stop(pid int) error
 
 	//check if the proxy has watched the vm console.
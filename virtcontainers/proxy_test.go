@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/sirupsen/logrus"
@@ -133,12 +136,26 @@ func TestNewProxyFromKataBuiltInProxyType(t *testing.T) {
 	testNewProxyFromProxyType(t, proxyType, expectedProxy)
 }
 
-func TestNewProxyFromUnknownProxyType(t *testing.T) {
+// The zero proxy type is mapped to the default proxy type.
+func TestNewProxyFromZeroProxyType(t *testing.T) {
 	var proxyType ProxyType
+	testNewProxyFromProxyType(t, proxyType, &kataBuiltInProxy{})
+}
 
-	_, err := newProxy(proxyType)
-	if err != nil {
-		t.Fatal(err)
+func TestNewProxyFromUnknownProxyType(t *testing.T) {
+	proxyType := ProxyType("foobar")
+
+	p, err := newProxy(proxyType)
+	if err == nil {
+		t.Fatal("Should fail because of the unknown proxy type")
+	}
+
+	if p != nil {
+		t.Fatalf("Got %+v, expecting no proxy", p)
+	}
+
+	if !strings.Contains(err.Error(), `"foobar"`) {
+		t.Fatalf("Error %q should name the unknown proxy type", err)
 	}
 }
 
@@ -147,7 +164,7 @@ func testNewProxyFromSandboxConfig(t *testing.T, sandboxConfig SandboxConfig) {
 		t.Fatal(err)
 	}
 
-	if err := validateProxyConfig(sandboxConfig.ProxyConfig); err != nil {
+	if err := validateProxyConfig(sandboxConfig.ProxyType, sandboxConfig.ProxyConfig); err != nil {
 		t.Fatal(err)
 	}
 
@@ -156,8 +173,14 @@ func testNewProxyFromSandboxConfig(t *testing.T, sandboxConfig SandboxConfig) {
 var testProxyPath = "proxy-path"
 
 func TestNewProxyConfigFromKataProxySandboxConfig(t *testing.T) {
+	proxyPath := filepath.Join(testDir, testProxyPath)
+	if err := ioutil.WriteFile(proxyPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(proxyPath)
+
 	proxyConfig := ProxyConfig{
-		Path: testProxyPath,
+		Path: proxyPath,
 	}
 
 	sandboxConfig := SandboxConfig{
@@ -169,19 +192,19 @@ func TestNewProxyConfigFromKataProxySandboxConfig(t *testing.T) {
 }
 
 func TestNewProxyConfigNoPathFailure(t *testing.T) {
-	if err := validateProxyConfig(ProxyConfig{}); err == nil {
+	if err := validateProxyConfig(KataProxyType, ProxyConfig{}); err == nil {
 		t.Fatal("Should fail because ProxyConfig has no Path")
 	}
 }
 
 const sandboxID = "123456789"
 
-func testDefaultProxyURL(expectedURL string, socketType string, sandboxID string) error {
+func testDefaultProxyURL(expectedURL string, socketType string, sandboxID string, vsock *kataVSOCK) error {
 	sandbox := &Sandbox{
 		id: sandboxID,
 	}
 
-	url, err := defaultProxyURL(sandbox.id, socketType)
+	url, err := defaultProxyURL(sandbox.id, socketType, "", vsock)
 	if err != nil {
 		return err
 	}
@@ -197,22 +220,50 @@ func TestDefaultProxyURLUnix(t *testing.T) {
 	path := filepath.Join(store.SandboxRuntimeRootPath(sandboxID), "proxy.sock")
 	socketPath := fmt.Sprintf("unix://%s", path)
 
-	if err := testDefaultProxyURL(socketPath, SocketTypeUNIX, sandboxID); err != nil {
+	if err := testDefaultProxyURL(socketPath, SocketTypeUNIX, sandboxID, nil); err != nil {
 		t.Fatal(err)
 	}
+
+	// custom socket name
+	url, err := defaultProxyURL(sandboxID, SocketTypeUNIX, "proxy-migration.sock", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("unix://%s", filepath.Join(store.SandboxRuntimeRootPath(sandboxID), "proxy-migration.sock"))
+	if url != expected {
+		t.Fatalf("Mismatched URL: %s vs %s", url, expected)
+	}
+
+	// the socket can't live outside of the sandbox runtime directory
+	for _, invalid := range []string{"../proxy.sock", "dir/proxy.sock", "/tmp/proxy.sock", ".", ".."} {
+		if _, err := defaultProxyURL(sandboxID, SocketTypeUNIX, invalid, nil); err == nil {
+			t.Fatalf("Should fail because of invalid socket name %q", invalid)
+		}
+	}
 }
 
 func TestDefaultProxyURLVSock(t *testing.T) {
-	if err := testDefaultProxyURL("", SocketTypeVSOCK, sandboxID); err != nil {
+	vsock := &kataVSOCK{
+		contextID: 3,
+		port:      1024,
+	}
+
+	if err := testDefaultProxyURL("vsock://3:1024", SocketTypeVSOCK, sandboxID, vsock); err != nil {
 		t.Fatal(err)
 	}
+
+	for _, invalid := range []*kataVSOCK{nil, {}, {contextID: 3}, {port: 1024}} {
+		if err := testDefaultProxyURL("", SocketTypeVSOCK, sandboxID, invalid); err == nil {
+			t.Fatalf("Should fail because of invalid vsock address %+v", invalid)
+		}
+	}
 }
 
 func TestDefaultProxyURLUnknown(t *testing.T) {
 	path := filepath.Join(store.SandboxRuntimeRootPath(sandboxID), "proxy.sock")
 	socketPath := fmt.Sprintf("unix://%s", path)
 
-	if err := testDefaultProxyURL(socketPath, "foobar", sandboxID); err == nil {
+	if err := testDefaultProxyURL(socketPath, "foobar", sandboxID, nil); err == nil {
 		t.Fatal()
 	}
 }
@@ -285,16 +336,135 @@ func testProxyStart(t *testing.T, agent agent, proxy proxy) {
 	}
 }
 
+func startTestProxyProcess(t *testing.T, script string) (int, chan struct{}) {
+	cmd := exec.Command("sh", "-c", script)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	return cmd.Process.Pid, exited
+}
+
+func TestTerminateProxyProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	// never signal a process group
+	assert.NoError(terminateProxyProcess(0, time.Second))
+	assert.NoError(terminateProxyProcess(-1, time.Second))
+
+	// the process terminates on SIGTERM
+	pid, exited := startTestProxyProcess(t, "exec sleep 30")
+	assert.NoError(terminateProxyProcess(pid, 10*time.Second))
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy process should have been terminated")
+	}
+
+	// the process ignores SIGTERM, and gets killed after the grace period
+	pid, exited = startTestProxyProcess(t, "trap '' TERM; while true; do sleep 0.1; done")
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	assert.NoError(terminateProxyProcess(pid, 300*time.Millisecond))
+	assert.True(time.Since(start) >= 300*time.Millisecond)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy process should have been killed")
+	}
+
+	// the process does not exist anymore
+	assert.Error(terminateProxyProcess(pid, time.Second))
+}
+
+func TestProxyStartPid(t *testing.T) {
+	assert := assert.New(t)
+
+	params := proxyParams{
+		id:         testSandboxID,
+		path:       "echo",
+		agentURL:   "agentURL",
+		consoleURL: "consoleURL",
+		logger:     testDefaultLogger,
+	}
+
+	for _, pType := range []ProxyType{NoopProxyType, NoProxyType, KataProxyType, KataBuiltInProxyType} {
+		p, err := newProxy(pType)
+		assert.NoError(err)
+
+		pid, _, err := p.start(params)
+		assert.NoError(err, "proxy type %s", pType)
+
+		if isProcessProxy(pType) {
+			assert.True(pid > 0, "proxy type %s", pType)
+		} else {
+			assert.False(pid > 0, "proxy type %s", pType)
+		}
+
+		assert.NoError(validateProxyPid(pType, pid), "proxy type %s", pType)
+	}
+}
+
+func TestValidateProxyPid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(validateProxyPid(KataProxyType, 1234))
+	assert.Error(validateProxyPid(KataProxyType, 0))
+	assert.Error(validateProxyPid(KataProxyType, -1))
+	assert.NoError(validateProxyPid(NoProxyType, 0))
+	assert.NoError(validateProxyPid(KataBuiltInProxyType, -1))
+	assert.Error(validateProxyPid(NoopProxyType, 1234))
+}
+
 func TestValidateProxyConfig(t *testing.T) {
 	assert := assert.New(t)
 
-	config := ProxyConfig{}
-	err := validateProxyConfig(config)
-	assert.Error(err)
+	dir, err := ioutil.TempDir("", "proxy-config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
 
-	config.Path = "foobar"
-	err = validateProxyConfig(config)
-	assert.Nil(err)
+	executable := filepath.Join(dir, "proxy")
+	err = ioutil.WriteFile(executable, []byte("#!/bin/sh\n"), 0755)
+	assert.NoError(err)
+
+	nonExecutable := filepath.Join(dir, "not-executable")
+	err = ioutil.WriteFile(nonExecutable, []byte("#!/bin/sh\n"), 0644)
+	assert.NoError(err)
+
+	data := []struct {
+		proxyType ProxyType
+		path      string
+		expectErr bool
+	}{
+		{KataProxyType, "", true},
+		{KataProxyType, filepath.Join(dir, "missing"), true},
+		{KataProxyType, dir, true},
+		{KataProxyType, nonExecutable, true},
+		{KataProxyType, executable, false},
+		{NoopProxyType, "", false},
+		{NoopProxyType, filepath.Join(dir, "missing"), false},
+		{NoProxyType, "", false},
+		{NoProxyType, nonExecutable, false},
+	}
+
+	for i, d := range data {
+		config := ProxyConfig{
+			Path: d.path,
+		}
+
+		err := validateProxyConfig(d.proxyType, config)
+		if d.expectErr {
+			assert.Error(err, "test %d (%+v)", i, d)
+		} else {
+			assert.NoError(err, "test %d (%+v)", i, d)
+		}
+	}
 }
 
 func TestValidateProxyParams(t *testing.T) {
@@ -324,3 +494,91 @@ func TestValidateProxyParams(t *testing.T) {
 	err = validateProxyParams(p)
 	assert.Nil(err)
 }
+
+func TestValidateAllProxyParams(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := validateAllProxyParams(proxyParams{})
+	assert.Len(errs, 5)
+
+	for _, problem := range []string{"path", "sandbox ID", "agent URL", "console URL", "logger"} {
+		assert.Contains(errs.Error(), problem)
+	}
+
+	// the first problem only
+	err := validateProxyParams(proxyParams{})
+	assert.Equal(errs[0], err)
+
+	p := proxyParams{
+		path:       "foobar",
+		id:         "foobar1",
+		agentURL:   "foobar2",
+		consoleURL: "foobar3",
+	}
+	assert.Len(validateAllProxyParams(p), 1)
+
+	p.logger = testDefaultLogger
+	assert.Empty(validateAllProxyParams(p))
+}
+
+// proxyLogHook records the log entries it is fired with.
+type proxyLogHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *proxyLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *proxyLogHook) Fire(e *logrus.Entry) error {
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func TestProxyLifecycleLogFields(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	proxyPath := filepath.Join(tmpdir, "proxy")
+	err = ioutil.WriteFile(proxyPath, []byte("#!/bin/sh\nsleep 10\n"), 0700)
+	assert.NoError(err)
+
+	for _, pType := range []ProxyType{KataProxyType, KataBuiltInProxyType, NoProxyType, NoopProxyType} {
+		hook := &proxyLogHook{}
+		logger := logrus.New()
+		logger.SetLevel(logrus.DebugLevel)
+		logger.Out = ioutil.Discard
+		logger.AddHook(hook)
+
+		params := proxyParams{
+			id:         testSandboxID,
+			path:       proxyPath,
+			agentURL:   "agentURL",
+			consoleURL: "consoleURL",
+			logger:     logrus.NewEntry(logger),
+		}
+
+		p, err := newProxy(pType)
+		assert.NoError(err)
+
+		pid, uri, err := p.start(params)
+		assert.NoError(err, "proxy %s", pType)
+		assert.NoError(p.stop(pid), "proxy %s", pType)
+
+		assert.NotEmpty(hook.entries, "proxy %s", pType)
+		for _, e := range hook.entries {
+			for _, field := range []string{"proxy-type", "sandbox-id", "pid", "uri"} {
+				assert.Contains(e.Data, field, "proxy %s: %q", pType, e.Message)
+			}
+			assert.Equal(pType, e.Data["proxy-type"])
+			assert.Equal(testSandboxID, e.Data["sandbox-id"])
+		}
+
+		last := hook.entries[len(hook.entries)-1]
+		assert.Equal(pid, last.Data["pid"], "proxy %s", pType)
+		assert.Equal(uri, last.Data["uri"], "proxy %s", pType)
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -20,6 +22,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 
 	"github.com/kata-containers/agent/protocols/grpc"
 	"github.com/kata-containers/runtime/virtcontainers/device/api"
@@ -57,6 +61,12 @@ type SandboxStatus struct {
 	Annotations map[string]string
 }
 
+// SandboxListEntry describes a sandbox found in the store, with its state.
+type SandboxListEntry struct {
+	ID    string
+	State types.SandboxState
+}
+
 // SandboxConfig is a Sandbox configuration.
 type SandboxConfig struct {
 	ID string
@@ -104,6 +114,15 @@ type SandboxConfig struct {
 
 	DisableGuestSeccomp bool
 
+	// EphemeralStorageMaxSize is the maximum size, in bytes, a container
+	// can request for the tmpfs of its ephemeral volumes, 0 disables the
+	// limit.
+	EphemeralStorageMaxSize uint64
+
+	// SyncGuestTime sets the guest clock from the host one when the
+	// sandbox starts.
+	SyncGuestTime bool
+
 	// Experimental features enabled
 	Experimental []exp.Feature
 }
@@ -342,7 +361,62 @@ func (s *Sandbox) WaitProcess(containerID, processID string) (int32, error) {
 		return 0, err
 	}
 
-	return c.wait(processID)
+	exitCode, err := c.wait(processID)
+	if err != nil {
+		return exitCode, err
+	}
+
+	// The container process is reaped, remember how it exited. The
+	// container init process has the container ID as its ID.
+	if processID == containerID {
+		if err := s.storeExitCode(c, exitCode); err != nil {
+			c.Logger().WithError(err).Warn("Failed to store the container exit code")
+		}
+	}
+
+	return exitCode, nil
+}
+
+// storeExitCode records the exit code of the container init process in the
+// container state. The wait doesn't hold the sandbox lock, which is taken
+// for the state update only.
+func (s *Sandbox) storeExitCode(c *Container, exitCode int32) error {
+	lockFile, err := rwLockSandbox(s.ctx, s.id)
+	if err != nil {
+		return err
+	}
+	defer unlockSandbox(s.ctx, s.id, lockFile)
+
+	c.state.LastExitCode = exitCode
+
+	return c.flushState()
+}
+
+// WaitProcessWithContext waits on a container process like WaitProcess,
+// but gives up and returns the context error once ctx is done. The wait
+// request sent to the agent is left pending in that case.
+func (s *Sandbox) WaitProcessWithContext(ctx context.Context, containerID, processID string) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	type result struct {
+		ret int32
+		err error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		ret, err := s.WaitProcess(containerID, processID)
+		ch <- result{ret, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.ret, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
 }
 
 // SignalProcess sends a signal to a process of a container when all is false.
@@ -360,6 +434,12 @@ func (s *Sandbox) SignalProcess(containerID, processID string, signal syscall.Si
 	return c.signalProcess(processID, signal, all)
 }
 
+// Capabilities returns the capabilities of the sandbox hypervisor, to
+// branch on what the sandbox supports rather than on the hypervisor type.
+func (s *Sandbox) Capabilities() types.Capabilities {
+	return s.hypervisor.capabilities()
+}
+
 // WinsizeProcess resizes the tty window of a process
 func (s *Sandbox) WinsizeProcess(containerID, processID string, height, width uint32) error {
 	if s.state.State != types.StateRunning {
@@ -504,6 +584,12 @@ func createSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Fac
 		return nil, err
 	}
 
+	if !s.supportNewStore() {
+		if err := s.store.StoreVersion(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Set sandbox state
 	if err := s.setSandboxState(types.StateReady); err != nil {
 		return nil, err
@@ -674,6 +760,11 @@ func fetchSandbox(ctx context.Context, sandboxID string) (sandbox *Sandbox, err
 		return nil, err
 	}
 
+	// Upgrade the store written by a previous runtime before reading it.
+	if err := vcStore.Migrate(); err != nil {
+		return nil, err
+	}
+
 	var config SandboxConfig
 	if err := vcStore.Load(store.Configuration, &config); err != nil {
 		return nil, err
@@ -767,7 +858,44 @@ func (s *Sandbox) Delete() error {
 
 	s.agent.cleanup(s.id)
 
-	return s.store.Delete()
+	if err := s.store.Delete(); err != nil {
+		// Don't leave the sockets of the sandbox components behind
+		// the store items which couldn't be removed.
+		if err2 := cleanupRuntimeFiles(s.id); err2 != nil {
+			s.Logger().WithError(err2).Warn("failed to cleanup sandbox runtime files")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// sandboxRuntimeFiles are the files the sandbox components create in the
+// sandbox runtime directory, besides the store items.
+var sandboxRuntimeFiles = []string{defaultProxySocketName, fireSocket}
+
+// cleanupRuntimeFiles removes the known runtime files of a sandbox, and its
+// runtime directory if it is left empty, once the store failed to delete
+// the sandbox. They may already be gone.
+func cleanupRuntimeFiles(id string) error {
+	dir := store.SandboxRuntimeRootPath(id)
+
+	for _, name := range sandboxRuntimeFiles {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err := os.Remove(dir)
+	if pathErr, ok := err.(*os.PathError); ok && (pathErr.Err == syscall.ENOTEMPTY || pathErr.Err == syscall.EEXIST) {
+		// unknown files are left alone
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
 }
 
 func (s *Sandbox) startNetworkMonitor() error {
@@ -1017,6 +1145,36 @@ func (s *Sandbox) startVM() (err error) {
 
 	s.Logger().Info("Agent started in the sandbox")
 
+	if s.config.SyncGuestTime {
+		if err := s.syncGuestTime(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncGuestTime sets the guest clock from the host one. The agent doesn't
+// report the guest time, the offset logged is the time the agent took to
+// set it, bounding the error of the guest clock. An agent not supporting
+// the call leaves the guest clock as is.
+func (s *Sandbox) syncGuestTime() error {
+	now := time.Now()
+
+	err := s.agent.setGuestDateTime(now)
+	if grpcStatus.Code(err) == codes.Unimplemented {
+		s.Logger().WithError(err).Warn("Agent can't set the guest time, not syncing it")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Logger().WithFields(logrus.Fields{
+		"time":   now,
+		"offset": time.Since(now),
+	}).Info("Guest time synced")
+
 	return nil
 }
 
@@ -1797,6 +1955,11 @@ func (s *Sandbox) updateResources() error {
 	}
 	s.Logger().Debugf("Sandbox CPUs: %d", newCPUs)
 
+	if s.config.HypervisorConfig.DisableMemoryHotplug {
+		s.Logger().Debug("Memory hotplug disabled, not updating the sandbox memory")
+		return nil
+	}
+
 	// Update Memory
 	s.Logger().WithField("memory-sandbox-size-byte", sandboxMemoryByte).Debugf("Request to hypervisor to update memory")
 	newMemory, updatedMemoryDevice, err := s.hypervisor.resizeMemory(uint32(sandboxMemoryByte>>utils.MibToBytesShift), s.state.GuestMemoryBlockSizeMB, s.state.GuestMemoryHotplugProbe)
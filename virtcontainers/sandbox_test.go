@@ -18,6 +18,7 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
@@ -29,6 +30,8 @@ import (
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 )
 
 func newHypervisorConfig(kernelParams []Param, hParams []Param) HypervisorConfig {
@@ -1176,6 +1179,82 @@ func TestWaitProcess(t *testing.T) {
 
 	_, err = s.WaitProcess(contID, execID)
 	assert.Nil(t, err, "Wait process failed: %v", err)
+
+	_, err = s.WaitProcessWithContext(context.Background(), contID, execID)
+	assert.Nil(t, err, "Wait process with context failed: %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.WaitProcessWithContext(ctx, contID, execID)
+	assert.Equal(t, context.Canceled, err)
+}
+
+// exitCodeAgent is a noop agent whose processes exit with exitCode.
+type exitCodeAgent struct {
+	noopAgent
+	exitCode int32
+}
+
+func (n *exitCodeAgent) waitProcess(c *Container, processID string) (int32, error) {
+	return n.exitCode, nil
+}
+
+func TestContainerRestartCount(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := testCreateSandbox(t, testSandboxID, MockHypervisor, newHypervisorConfig(nil, nil), NoopAgentType, NetworkConfig{}, nil, nil)
+	assert.NoError(err)
+	defer cleanUp()
+
+	agent := &exitCodeAgent{exitCode: 3}
+	s.agent = agent
+
+	assert.NoError(s.Start())
+
+	contID := "foo"
+	_, err = s.CreateContainer(newTestContainerConfigNoop(contID))
+	assert.NoError(err)
+
+	c, err := s.StartContainer(contID)
+	assert.NoError(err)
+
+	status, err := s.StatusContainer(contID)
+	assert.NoError(err)
+	assert.Equal(0, status.State.RestartCount)
+	assert.Equal(int32(0), status.State.LastExitCode)
+
+	// an exec exit isn't the container exit
+	_, err = s.WaitProcess(contID, "bar")
+	assert.NoError(err)
+	status, err = s.StatusContainer(contID)
+	assert.NoError(err)
+	assert.Equal(int32(0), status.State.LastExitCode)
+
+	exitCode, err := s.WaitProcess(contID, contID)
+	assert.NoError(err)
+	assert.Equal(int32(3), exitCode)
+
+	status, err = s.StatusContainer(contID)
+	assert.NoError(err)
+	assert.Equal(int32(3), status.State.LastExitCode)
+
+	_, err = s.StopContainer(contID)
+	assert.NoError(err)
+
+	agent.exitCode = 0
+	_, err = s.StartContainer(contID)
+	assert.NoError(err)
+
+	status, err = s.StatusContainer(contID)
+	assert.NoError(err)
+	assert.Equal(1, status.State.RestartCount)
+	assert.Equal(int32(3), status.State.LastExitCode)
+
+	// the counters are persisted with the container state
+	var state types.ContainerState
+	assert.NoError(c.(*Container).store.Load(store.State, &state))
+	assert.Equal(1, state.RestartCount)
+	assert.Equal(int32(3), state.LastExitCode)
 }
 
 func TestSignalProcess(t *testing.T) {
@@ -1602,3 +1681,114 @@ func TestSandboxExperimentalFeature(t *testing.T) {
 	assert.NotNil(t, exp.Get(testFeature.Name))
 	assert.True(t, sconfig.valid())
 }
+
+func TestCleanupRuntimeFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxID := "cleanup-runtime-files"
+	dir := store.SandboxRuntimeRootPath(sandboxID)
+	defer os.RemoveAll(dir)
+
+	err := os.MkdirAll(dir, store.DirMode)
+	assert.NoError(err)
+	err = ioutil.WriteFile(filepath.Join(dir, defaultProxySocketName), nil, 0600)
+	assert.NoError(err)
+
+	assert.NoError(cleanupRuntimeFiles(sandboxID))
+	_, err = os.Stat(dir)
+	assert.True(os.IsNotExist(err))
+
+	// already cleaned up
+	assert.NoError(cleanupRuntimeFiles(sandboxID))
+
+	// the directory is kept with the unknown files
+	err = os.MkdirAll(dir, store.DirMode)
+	assert.NoError(err)
+	err = ioutil.WriteFile(filepath.Join(dir, defaultProxySocketName), nil, 0600)
+	assert.NoError(err)
+	err = ioutil.WriteFile(filepath.Join(dir, "unknown"), nil, 0600)
+	assert.NoError(err)
+
+	assert.NoError(cleanupRuntimeFiles(sandboxID))
+	_, err = os.Stat(filepath.Join(dir, defaultProxySocketName))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "unknown"))
+	assert.NoError(err)
+}
+
+// capsHypervisor is a mock hypervisor reporting the given capabilities.
+type capsHypervisor struct {
+	mockHypervisor
+	caps types.Capabilities
+}
+
+func (h *capsHypervisor) capabilities() types.Capabilities {
+	return h.caps
+}
+
+func TestSandboxCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	var noFsSharing types.Capabilities
+	noFsSharing.SetFsSharingUnsupported()
+
+	data := []struct {
+		caps      types.Capabilities
+		fsSharing bool
+	}{
+		{types.Capabilities{}, true},
+		{noFsSharing, false},
+	}
+
+	for i, d := range data {
+		s := &Sandbox{hypervisor: &capsHypervisor{caps: d.caps}}
+
+		caps := s.Capabilities()
+		assert.Equal(d.fsSharing, caps.IsFsSharingSupported(), "test %d", i)
+	}
+}
+
+// timeSyncAgent records the guest times it is asked to set.
+type timeSyncAgent struct {
+	noopAgent
+	times []time.Time
+	err   error
+}
+
+func (a *timeSyncAgent) setGuestDateTime(tv time.Time) error {
+	a.times = append(a.times, tv)
+	return a.err
+}
+
+func TestStartVMSyncGuestTime(t *testing.T) {
+	assert := assert.New(t)
+
+	agent := &timeSyncAgent{}
+	s := &Sandbox{
+		id:         testSandboxID,
+		ctx:        context.Background(),
+		hypervisor: &mockHypervisor{},
+		agent:      agent,
+		network:    Network{},
+		config:     &SandboxConfig{},
+	}
+
+	// disabled by default
+	assert.NoError(s.startVM())
+	assert.Empty(agent.times)
+
+	s.config.SyncGuestTime = true
+	before := time.Now()
+	assert.NoError(s.startVM())
+	assert.Len(agent.times, 1)
+	assert.False(agent.times[0].Before(before))
+	assert.False(agent.times[0].After(time.Now()))
+
+	// an agent not supporting the call doesn't fail the start
+	agent.err = grpcStatus.Error(codes.Unimplemented, "unknown method SetGuestDateTime")
+	assert.NoError(s.startVM())
+	assert.Len(agent.times, 2)
+
+	agent.err = fmt.Errorf("agent failure")
+	assert.Error(s.startVM())
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// seccompMaxArgs is the number of syscall arguments a seccomp rule can
+// compare.
+const seccompMaxArgs = 6
+
+// seccompActions are the seccomp actions the guest knows about.
+var seccompActions = map[specs.LinuxSeccompAction]bool{
+	specs.ActKill:  true,
+	specs.ActTrap:  true,
+	specs.ActErrno: true,
+	specs.ActTrace: true,
+	specs.ActAllow: true,
+}
+
+// seccompOperators are the seccomp argument comparisons the guest knows
+// about.
+var seccompOperators = map[specs.LinuxSeccompOperator]bool{
+	specs.OpNotEqual:     true,
+	specs.OpLessThan:     true,
+	specs.OpLessEqual:    true,
+	specs.OpEqualTo:      true,
+	specs.OpGreaterEqual: true,
+	specs.OpGreaterThan:  true,
+	specs.OpMaskedEqual:  true,
+}
+
+// validSeccomp checks a seccomp profile forwarded to the agent. A profile
+// must have a default action and at least one syscall rule, and only use
+// actions, operators and argument indexes the guest knows about.
+func validSeccomp(seccomp *specs.LinuxSeccomp) error {
+	if seccomp == nil {
+		return nil
+	}
+
+	if seccomp.DefaultAction == "" {
+		return fmt.Errorf("seccomp profile without any default action")
+	}
+
+	if !seccompActions[seccomp.DefaultAction] {
+		return fmt.Errorf("unknown seccomp default action %q", seccomp.DefaultAction)
+	}
+
+	if len(seccomp.Syscalls) == 0 {
+		return fmt.Errorf("seccomp profile without any syscall rule")
+	}
+
+	for i, rule := range seccomp.Syscalls {
+		if len(rule.Names) == 0 {
+			return fmt.Errorf("seccomp syscall rule %d without any syscall name", i)
+		}
+
+		if !seccompActions[rule.Action] {
+			return fmt.Errorf("unknown seccomp action %q for syscalls %v", rule.Action, rule.Names)
+		}
+
+		for _, arg := range rule.Args {
+			if arg.Index >= seccompMaxArgs {
+				return fmt.Errorf("invalid seccomp argument index %d for syscalls %v", arg.Index, rule.Names)
+			}
+
+			if !seccompOperators[arg.Op] {
+				return fmt.Errorf("unknown seccomp operator %q for syscalls %v", arg.Op, rule.Names)
+			}
+		}
+	}
+
+	return nil
+}
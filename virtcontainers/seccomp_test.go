@@ -0,0 +1,65 @@
+// Copyright (c) 2019 hyper.sh
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidSeccomp(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := []specs.LinuxSyscall{
+		{Names: []string{"read", "write"}, Action: specs.ActAllow},
+		{
+			Names:  []string{"personality"},
+			Action: specs.ActAllow,
+			Args:   []specs.LinuxSeccompArg{{Index: 0, Value: 0, Op: specs.OpEqualTo}},
+		},
+	}
+
+	data := []struct {
+		seccomp *specs.LinuxSeccomp
+		valid   bool
+	}{
+		// no profile
+		{nil, true},
+		// valid profile
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno, Syscalls: rules}, true},
+		// missing default action
+		{&specs.LinuxSeccomp{Syscalls: rules}, false},
+		// unknown default action
+		{&specs.LinuxSeccomp{DefaultAction: "SCMP_ACT_FOO", Syscalls: rules}, false},
+		// no syscall rule
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno}, false},
+		// malformed syscall rules
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno, Syscalls: []specs.LinuxSyscall{{Action: specs.ActAllow}}}, false},
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno, Syscalls: []specs.LinuxSyscall{{Names: []string{"read"}}}}, false},
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno, Syscalls: []specs.LinuxSyscall{{
+			Names:  []string{"read"},
+			Action: specs.ActAllow,
+			Args:   []specs.LinuxSeccompArg{{Op: "SCMP_CMP_FOO"}},
+		}}}, false},
+		// out of range argument index
+		{&specs.LinuxSeccomp{DefaultAction: specs.ActErrno, Syscalls: []specs.LinuxSyscall{{
+			Names:  []string{"read"},
+			Action: specs.ActAllow,
+			Args:   []specs.LinuxSeccompArg{{Index: seccompMaxArgs, Op: specs.OpEqualTo}},
+		}}}, false},
+	}
+
+	for i, d := range data {
+		err := validSeccomp(d.seccomp)
+		if d.valid {
+			assert.NoError(err, "test %d", i)
+		} else {
+			assert.Error(err, "test %d", i)
+		}
+	}
+}
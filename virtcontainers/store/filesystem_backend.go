@@ -46,6 +46,9 @@ const (
 
 	// DevicesFile is the file name storing a container's devices.
 	DevicesFile = "devices.json"
+
+	// VersionFile is the file name storing a sandbox store layout version.
+	VersionFile = "version.json"
 )
 
 // DirMode is the permission bits used for creating a directory
@@ -95,6 +98,8 @@ func itemToFile(item Item) (string, error) {
 		return MountsFile, nil
 	case Devices, DeviceIDs:
 		return DevicesFile, nil
+	case Version:
+		return VersionFile, nil
 	}
 
 	return "", fmt.Errorf("Unknown item %s", item)
@@ -226,6 +231,12 @@ func (f *filesystem) store(item Item, data interface{}) error {
 		return err
 	}
 
+	// The version is written while the store is only read locked, by
+	// the store migration: it must never be seen partially written.
+	if item == Version {
+		return storeAtomic(filePath, data)
+	}
+
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
@@ -241,6 +252,34 @@ func (f *filesystem) store(item Item, data interface{}) error {
 	return nil
 }
 
+// storeAtomic writes data to a temporary file next to filePath, then
+// renames it to filePath.
+func storeAtomic(filePath string, data interface{}) error {
+	jsonOut, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Could not marshall data: %s", err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+
+	_, err = file.Write(jsonOut)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
 func (f *filesystem) raw(id string) (string, error) {
 	span, _ := f.trace("raw")
 	defer span.Finish()
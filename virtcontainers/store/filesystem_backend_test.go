@@ -10,6 +10,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -66,6 +68,49 @@ func TestStoreFilesystemLoad(t *testing.T) {
 	assert.Equal(t, newData, data)
 }
 
+func TestStoreFilesystemStoreVersionConcurrent(t *testing.T) {
+	f := filesystem{}
+
+	err := f.new(context.Background(), rootPath, "")
+	defer f.delete()
+	assert.Nil(t, err)
+
+	data := storeVersion{Version: CurrentVersion}
+	err = f.store(Version, data)
+	assert.Nil(t, err)
+
+	// The readers never see a partially written version.
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- f.store(Version, data)
+		}()
+		go func() {
+			defer wg.Done()
+			var v storeVersion
+			errs <- f.load(Version, &v)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	// No temporary file is left behind.
+	files, err := ioutil.ReadDir(rootPath)
+	assert.Nil(t, err)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), VersionFile) {
+			assert.Equal(t, VersionFile, file.Name())
+		}
+	}
+}
+
 func TestStoreFilesystemDelete(t *testing.T) {
 	f := filesystem{}
 
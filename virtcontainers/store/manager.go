@@ -48,6 +48,9 @@ const (
 
 	// DeviceIDs represents a set of reference IDs item to be stored.
 	DeviceIDs
+
+	// Version represents the store layout version item to be stored.
+	Version
 )
 
 func (i Item) String() string {
@@ -72,6 +75,8 @@ func (i Item) String() string {
 		return "Devices"
 	case DeviceIDs:
 		return "Device IDs"
+	case Version:
+		return "Version"
 	}
 
 	return ""
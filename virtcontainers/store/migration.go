@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CurrentVersion is the layout version of the sandbox stores written by
+// this runtime. A store without any Version item was written before the
+// layout got versioned, it is a version 1 one.
+const CurrentVersion = 2
+
+// storeVersion is the content of the Version item.
+type storeVersion struct {
+	Version int `json:"version"`
+}
+
+// migration upgrades a sandbox store from the previous layout version to
+// version, rewriting its items in place.
+type migration struct {
+	version     int
+	description string
+	migrate     func(s *VCStore) error
+}
+
+// migrations are the sandbox store layout upgrades, in version order.
+var migrations = []migration{
+	{
+		// The version 1 items are still read as they are, only the
+		// layout version needs to be recorded.
+		version:     2,
+		description: "record the layout version",
+		migrate:     func(s *VCStore) error { return nil },
+	},
+}
+
+// LoadVersion returns the layout version of a sandbox store, 1 if it has
+// none recorded.
+func (s *VCStore) LoadVersion() (int, error) {
+	var v storeVersion
+
+	if err := s.config.Load(Version, &v); err != nil {
+		if os.IsNotExist(err) {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	if v.Version < 1 {
+		return 0, fmt.Errorf("Invalid store version %d", v.Version)
+	}
+
+	return v.Version, nil
+}
+
+// StoreVersion records the current layout version into a sandbox store.
+func (s *VCStore) StoreVersion() error {
+	return s.storeVersion(CurrentVersion)
+}
+
+func (s *VCStore) storeVersion(version int) error {
+	return s.config.Store(Version, storeVersion{Version: version})
+}
+
+// Migrate upgrades a sandbox store to the current layout version, running
+// the migrations of all the versions it is behind. The version is recorded
+// after each migration, so that a failed upgrade resumes from there. A
+// store written by a newer runtime is rejected instead of being misread,
+// and a store without any configuration, being created, is left as it is.
+func (s *VCStore) Migrate() error {
+	version, err := s.LoadVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > CurrentVersion {
+		return fmt.Errorf("Unsupported store version %d, this runtime supports up to version %d", version, CurrentVersion)
+	}
+
+	if version == CurrentVersion {
+		return nil
+	}
+
+	var config json.RawMessage
+	if err := s.config.Load(Configuration, &config); os.IsNotExist(err) {
+		return nil
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if err := m.migrate(s); err != nil {
+			return fmt.Errorf("Could not migrate the store from version %d to %d: %v", version, m.version, err)
+		}
+
+		if err := s.storeVersion(m.version); err != nil {
+			return err
+		}
+
+		s.config.Logger().WithFields(logrus.Fields{
+			"from": version,
+			"to":   m.version,
+		}).Infof("Migrated store: %s", m.description)
+
+		version = m.version
+	}
+
+	return nil
+}
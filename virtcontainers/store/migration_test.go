@@ -0,0 +1,138 @@
+// Copyright (c) 2019 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// v1 sandbox store items, written before the layout got versioned.
+const (
+	v1Configuration = `{"ID":"migrated","HypervisorType":"mock","AgentType":"noop"}`
+	v1State         = `{"state":"running","blockIndex":2,"guestMemoryBlockSize":128,"guestMemoryHotplugProbe":false}`
+)
+
+// newV1SandboxStore writes a v1 sandbox store on disk and opens it.
+func newV1SandboxStore(t *testing.T, sandboxID string) *VCStore {
+	for path, data := range map[string]string{
+		filepath.Join(SandboxConfigurationRootPath(sandboxID), ConfigurationFile): v1Configuration,
+		filepath.Join(SandboxRuntimeRootPath(sandboxID), StateFile):               v1State,
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), DirMode))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0640))
+	}
+
+	s, err := NewVCSandboxStore(context.Background(), sandboxID)
+	assert.NoError(t, err)
+
+	return s
+}
+
+func TestStoreMigrateV1(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newV1SandboxStore(t, "migrate-v1")
+	defer s.Delete()
+
+	version, err := s.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(1, version)
+
+	assert.NoError(s.Migrate())
+
+	version, err = s.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(CurrentVersion, version)
+
+	data, err := ioutil.ReadFile(filepath.Join(SandboxConfigurationRootPath("migrate-v1"), VersionFile))
+	assert.NoError(err)
+	assert.JSONEq(`{"version":2}`, string(data))
+
+	// the v1 items are read as current ones
+	state, err := s.LoadState()
+	assert.NoError(err)
+	assert.Equal(types.StateRunning, state.State)
+	assert.Equal(2, state.BlockIndex)
+	assert.Equal(uint32(128), state.GuestMemoryBlockSizeMB)
+
+	data, err = ioutil.ReadFile(filepath.Join(SandboxConfigurationRootPath("migrate-v1"), ConfigurationFile))
+	assert.NoError(err)
+	assert.Equal(v1Configuration, string(data))
+
+	// an up to date store is left as it is
+	assert.NoError(s.Migrate())
+}
+
+func TestStoreMigrateFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newV1SandboxStore(t, "migrate-failure")
+	defer s.Delete()
+
+	saved := migrations
+	defer func() {
+		migrations = saved
+	}()
+
+	migrations = []migration{
+		{
+			version:     2,
+			description: "fail",
+			migrate:     func(s *VCStore) error { return errors.New("corrupted item") },
+		},
+	}
+
+	err := s.Migrate()
+	assert.Error(err)
+	assert.Contains(err.Error(), "from version 1 to 2")
+
+	// the failed migration is run again on the next fetch
+	version, err := s.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(1, version)
+}
+
+func TestStoreMigrateUnknownVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newV1SandboxStore(t, "migrate-unknown")
+	defer s.Delete()
+
+	// written by a newer runtime
+	assert.NoError(s.storeVersion(CurrentVersion + 1))
+	err := s.Migrate()
+	assert.Error(err)
+	assert.Contains(err.Error(), "Unsupported store version 3")
+
+	assert.NoError(s.storeVersion(0))
+	assert.Error(s.Migrate())
+}
+
+func TestStoreMigrateNewStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewVCSandboxStore(context.Background(), "migrate-new")
+	assert.NoError(err)
+	defer s.Delete()
+
+	// nothing to migrate before the configuration is stored
+	assert.NoError(s.Migrate())
+	_, err = os.Stat(filepath.Join(SandboxConfigurationRootPath("migrate-new"), VersionFile))
+	assert.True(os.IsNotExist(err))
+
+	assert.NoError(s.StoreVersion())
+	version, err := s.LoadVersion()
+	assert.NoError(err)
+	assert.Equal(CurrentVersion, version)
+}
@@ -30,7 +30,7 @@ type VCStore struct {
 
 func (s *VCStore) itemToStore(item Item) *Store {
 	switch item {
-	case Configuration:
+	case Configuration, Version:
 		return s.config
 	case State, Network, Hypervisor, Agent, Process, Lock, Mounts, Devices, DeviceIDs:
 		return s.state
@@ -17,6 +17,14 @@ type ContainerState struct {
 	// CgroupPath is the cgroup hierarchy where sandbox's processes
 	// including the hypervisor are placed.
 	CgroupPath string `json:"cgroupPath,omitempty"`
+
+	// RestartCount is the number of times the container has been
+	// started again after being stopped.
+	RestartCount int `json:"restartCount,omitempty"`
+
+	// LastExitCode is the exit code of the last container process
+	// which has been waited for.
+	LastExitCode int32 `json:"lastExitCode,omitempty"`
 }
 
 // Valid checks that the container state is valid.
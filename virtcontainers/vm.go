@@ -121,6 +121,9 @@ func setupProxy(h hypervisor, agent agent, config VMConfig, id string) (int, str
 		consoleURL: consoleURL,
 		logger:     virtLog.WithField("vm", id),
 		debug:      config.ProxyConfig.Debug,
+
+		stopGracePeriod: config.ProxyConfig.StopGracePeriod,
+		waitTimeout:     config.ProxyConfig.SocketWaitTimeout,
 	}
 	pid, url, err := proxy.start(proxyParams)
 	if err != nil {